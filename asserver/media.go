@@ -0,0 +1,74 @@
+package asserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/sdbbs/idok/utils"
+)
+
+// MediaPaths returns the url path of each file served by MediaHandler.
+// Paths are prefixed by the file index, so files with the same name in
+// different directories are not mixed.
+func MediaPaths(files []string) []string {
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = fmt.Sprintf("%d/%s", i, filepath.Base(file))
+	}
+	return paths
+}
+
+// MediaHandler serves each file under its MediaPaths path.
+func MediaHandler(files []string) *http.ServeMux {
+	m := http.NewServeMux()
+	for i, path := range MediaPaths(files) {
+		fullpath := files[i]
+		m.Handle("/"+path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if verbose {
+				log.Println("Serving", fullpath, "to", r.RemoteAddr, r.Header.Get("Range"))
+			}
+			http.ServeFile(w, r, fullpath)
+		}))
+	}
+	return m
+}
+
+// PlaylistHandler serves local entries as MediaHandler does, and an
+// extended M3U playlist of all entries on utils.PLAYLIST_PATH. In that
+// playlist, local entries point to the server and remote ones are kept.
+func PlaylistHandler(entries []utils.PlaylistEntry) http.Handler {
+	paths := MediaPaths(utils.LocalFiles(entries))
+	m := MediaHandler(utils.LocalFiles(entries))
+	m.HandleFunc("/"+utils.PLAYLIST_PATH, func(w http.ResponseWriter, r *http.Request) {
+		urls := make([]string, len(entries))
+		local := 0
+		for i, entry := range entries {
+			if entry.Remote {
+				urls[i] = entry.Location
+				continue
+			}
+			// Kodi asks the playlist with the host:port it uses for media
+			u := url.URL{Scheme: "http", Host: r.Host, Path: "/" + paths[local]}
+			urls[i] = u.String()
+			local++
+		}
+		w.Header().Set("Content-Type", "audio/x-mpegurl")
+		if err := utils.WriteM3U(w, entries, urls); err != nil {
+			log.Println(err)
+		}
+	})
+	return m
+}
+
+// SendEntries asks Kodi to play entries served by PlaylistHandler on
+// host:port. The M3U playlist is used if entries have remote urls, titles
+// or durations, else files are sent as a Kodi playlist.
+func SendEntries(scheme, host string, entries []utils.PlaylistEntry, port int) <-chan int {
+	if utils.NeedsM3U(entries) {
+		return utils.SendPlaylistFile(scheme, host, utils.PLAYLIST_PATH, port)
+	}
+	return utils.SendList(scheme, host, MediaPaths(utils.LocalFiles(entries)), port)
+}
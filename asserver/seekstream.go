@@ -0,0 +1,173 @@
+package asserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/sdbbs/idok/utils"
+)
+
+// Seekable is a stream that can be started again at any offset, as a
+// transcoder started at the time that matches the offset. Size is the
+// length given to the clients, Open(offset) should give the Size-offset
+// bytes left.
+type Seekable interface {
+	Size() int64
+	Open(offset int64) (io.ReadCloser, error)
+}
+
+// runs of a seekable stream kept open: Kodi reads the end of the stream
+// to get its duration, then plays it from the start
+const maxSeekRuns = 2
+
+// a request up to that distance after the bytes buffered by a run waits
+// for the run instead of starting a new one
+const seekReuse = 2 << 20
+
+var errPastEnd = errors.New("past the end of the stream")
+
+// seekRun is the stream opened at base
+type seekRun struct {
+	base   int64
+	source io.ReadCloser
+	buffer *streamBuffer
+}
+
+// seekStream serves a Seekable. A client reading from an offset is served
+// by the run that buffered it or is about to, else by a new run opened at
+// that offset. The runs don't give the same bytes at the same offsets
+// (transcoders restarted at a time), but clients read the timestamps of
+// the stream to seek and a client that goes on reading stays on its run.
+type seekStream struct {
+	source     Seekable
+	bufferSize int64
+	onDisk     bool
+
+	mu sync.Mutex
+	// most recent first
+	runs []*seekRun
+}
+
+func newSeekStream(source Seekable, bufferSize int64, onDisk bool) *seekStream {
+	return &seekStream{source: source, bufferSize: bufferSize, onDisk: onDisk}
+}
+
+// start opens the stream at its beginning
+func (s *seekStream) start() (*streamBuffer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, err := s.open(0)
+	if err != nil {
+		return nil, err
+	}
+	return run.buffer, nil
+}
+
+// open starts a run at offset, the oldest run is closed if there are too
+// many, s.mu is held
+func (s *seekStream) open(offset int64) (*seekRun, error) {
+	source, err := s.source.Open(offset)
+	if err != nil {
+		return nil, err
+	}
+	buffer, err := newStreamBuffer(source, s.bufferSize, s.onDisk)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	run := &seekRun{base: offset, source: source, buffer: buffer}
+	s.runs = append([]*seekRun{run}, s.runs...)
+	if len(s.runs) > maxSeekRuns {
+		old := s.runs[maxSeekRuns]
+		s.runs = s.runs[:maxSeekRuns]
+		old.buffer.Close()
+		old.source.Close()
+	}
+	return run, nil
+}
+
+// reader returns a reader of the stream from offset, errPastEnd if the run
+// that reached offset ended before
+func (s *seekStream) reader(offset int64) (*streamReader, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, run := range s.runs {
+		start, end, total := run.buffer.window()
+		pos := offset - run.base
+		if pos < start || pos > end+seekReuse || run.buffer.failed() {
+			continue
+		}
+		if total >= 0 && pos >= total {
+			return nil, errPastEnd
+		}
+		copy(s.runs[1:i+1], s.runs[:i])
+		s.runs[0] = run
+		return run.buffer.NewReader(pos), nil
+	}
+
+	if verbose {
+		log.Println("Starting the stream again at byte", offset)
+	}
+	run, err := s.open(offset)
+	if err != nil {
+		return nil, err
+	}
+	return run.buffer.NewReader(0), nil
+}
+
+// seekHandler serves s, a Range request starts it at any offset
+func seekHandler(name string, s *seekStream) http.Handler {
+	contentType := utils.MediaType(name)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := s.source.Size()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		offset, last, status := int64(0), size-1, http.StatusOK
+		if header := r.Header.Get("Range"); header != "" {
+			if first, l, ok := parseRange(header, size); ok {
+				offset, status = first, http.StatusPartialContent
+				if l >= 0 && l < last {
+					last = l
+				}
+			}
+		}
+
+		var reader *streamReader
+		var err error
+		if offset >= size {
+			err = errPastEnd
+		} else if r.Method != "HEAD" {
+			reader, err = s.reader(offset)
+		}
+		if err == errPastEnd {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if status == http.StatusPartialContent {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, last, size))
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(last-offset+1, 10))
+		if verbose {
+			log.Println("Serving", name, "to", r.RemoteAddr, "from", offset, r.Header.Get("Range"))
+		}
+		w.WriteHeader(status)
+		if reader == nil {
+			return
+		}
+		defer reader.Close()
+		sendStream(w, r, io.LimitReader(reader, last-offset+1))
+	})
+}
@@ -0,0 +1,152 @@
+package asserver
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fake transcoder: the stream opened at offset gives the bytes of
+// testStream from offset, up to end
+type fakeSeekable struct {
+	size, end int64
+
+	mu     sync.Mutex
+	opened []int64
+	closed int
+}
+
+func (f *fakeSeekable) Size() int64 {
+	return f.size
+}
+
+func (f *fakeSeekable) Open(offset int64) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opened = append(f.opened, offset)
+	return &fakeRun{f: f, offset: offset}, nil
+}
+
+func (f *fakeSeekable) opens() []int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int64{}, f.opened...)
+}
+
+type fakeRun struct {
+	f      *fakeSeekable
+	offset int64
+}
+
+func (r *fakeRun) Read(p []byte) (int, error) {
+	if r.offset >= r.f.end {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.f.end-r.offset {
+		p = p[:r.f.end-r.offset]
+	}
+	for i := range p {
+		p[i] = byte((r.offset + int64(i)) % 251)
+	}
+	r.offset += int64(len(p))
+	return len(p), nil
+}
+
+func (r *fakeRun) Close() error {
+	r.f.mu.Lock()
+	r.f.closed++
+	r.f.mu.Unlock()
+	return nil
+}
+
+func get(t *testing.T, url, ranges string) (*http.Response, []byte) {
+	req, _ := http.NewRequest("GET", url, nil)
+	if ranges != "" {
+		req.Header.Set("Range", "bytes="+ranges)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the body ends before Content-Length if the stream ends first
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp, body
+}
+
+func checkBody(t *testing.T, what string, body []byte, offset int64) {
+	for i, c := range body {
+		if c != byte((offset+int64(i))%251) {
+			t.Errorf("%s: byte %d is %d, not the byte at offset %d", what, i, c, offset+int64(i))
+			return
+		}
+	}
+}
+
+func TestSeekStream(t *testing.T) {
+	const size = 100 << 20
+	f := &fakeSeekable{size: size, end: 90 << 20}
+	s := newSeekStream(f, 64*1024, false)
+	if _, err := s.start(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(seekHandler("out.ts", s))
+	defer server.Close()
+
+	// the start is served by the first run
+	resp, body := get(t, server.URL, "0-99999")
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 100000 ||
+		resp.Header.Get("Content-Range") != fmt.Sprintf("bytes 0-99999/%d", size) {
+		t.Errorf("start: status %d, %d bytes, Content-Range %q", resp.StatusCode, len(body), resp.Header.Get("Content-Range"))
+	}
+	checkBody(t, "start", body, 0)
+
+	// the end of the stream (duration probing), then the start again
+	resp, body = get(t, server.URL, fmt.Sprintf("%d-", f.end-1000))
+	if resp.StatusCode != http.StatusPartialContent || len(body) != 1000 {
+		t.Errorf("end: status %d, %d bytes", resp.StatusCode, len(body))
+	}
+	checkBody(t, "end", body, f.end-1000)
+	resp, body = get(t, server.URL, "100000-199999")
+	checkBody(t, "start again", body, 100000)
+	if opens := f.opens(); len(opens) != 2 || opens[0] != 0 || opens[1] != f.end-1000 {
+		t.Errorf("streams opened at %v, want [0 %d]", opens, f.end-1000)
+	}
+
+	// the run that reached the end ended
+	resp, _ = get(t, server.URL, fmt.Sprintf("%d-", f.end))
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != fmt.Sprintf("bytes */%d", size) {
+		t.Errorf("after the end: status %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	resp, _ = get(t, server.URL, fmt.Sprintf("%d-", size))
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("after the size: status %d", resp.StatusCode)
+	}
+
+	// a seek starts a new run, the oldest one is closed
+	resp, body = get(t, server.URL, "50000000-50009999")
+	checkBody(t, "seek", body, 50000000)
+	if opens := f.opens(); len(opens) != 3 || opens[2] != 50000000 {
+		t.Errorf("streams opened at %v", opens)
+	}
+	f.mu.Lock()
+	closed := f.closed
+	f.mu.Unlock()
+	if closed != 1 {
+		t.Errorf("%d streams closed, want the oldest one", closed)
+	}
+
+	// HEAD doesn't start a run
+	resp, err := http.Head(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != size || len(f.opens()) != 3 {
+		t.Errorf("HEAD: status %d, length %d, %d streams opened", resp.StatusCode, resp.ContentLength, len(f.opens()))
+	}
+}
@@ -0,0 +1,294 @@
+package asserver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdbbs/idok/utils"
+)
+
+// stdin buffer settings (-stdin_bufsize, -stdin_buffile)
+var (
+	stdinBufferSize   int64 = DEFAULT_STDIN_BUFFER
+	stdinBufferOnDisk bool
+)
+
+func SetStdinBuffer(size int64, onDisk bool) {
+	stdinBufferSize, stdinBufferOnDisk = size, onDisk
+	if verbose {
+		log.Println(" asserver stdin buffer: ", size, "bytes, on disk:", onDisk)
+	}
+}
+
+// pre-roll before Kodi is asked to play stdin (-stdin_preroll,
+// -stdin_prerolltime)
+var (
+	stdinPrerollSize int64
+	stdinPrerollTime time.Duration
+)
+
+func SetStdinPreroll(size int64, duration time.Duration) {
+	stdinPrerollSize, stdinPrerollTime = size, duration
+	if verbose {
+		log.Println(" asserver stdin pre-roll: ", size, "bytes,", duration)
+	}
+}
+
+// stream served as stdin, os.Stdin unless a transcoder replaces it
+var stdinSource io.Reader = os.Stdin
+
+// SetStdinSource serves source instead of stdin (see transcode.Start).
+func SetStdinSource(source io.Reader) {
+	stdinSource = source
+}
+
+// seekable stream served as stdin, if set
+var stdinSeekable Seekable
+
+// SetStdinSeekable serves source instead of stdin, clients can seek in it
+// (see transcode.Seekable).
+func SetStdinSeekable(source Seekable) {
+	stdinSeekable = source
+}
+
+var stdinOnce sync.Once
+var stdin *streamBuffer
+var stdinSeek *seekStream
+var stdinErr error
+
+// stdinBuffer starts to read stdin in the ring buffer, once. With a
+// seekable stream, it's the buffer of the stream opened at its start.
+func stdinBuffer() (*streamBuffer, error) {
+	stdinOnce.Do(func() {
+		if stdinSeekable != nil {
+			stdinSeek = newSeekStream(stdinSeekable, stdinBufferSize, stdinBufferOnDisk)
+			stdin, stdinErr = stdinSeek.start()
+			return
+		}
+		stdin, stdinErr = newStreamBuffer(stdinSource, stdinBufferSize, stdinBufferOnDisk)
+	})
+	return stdin, stdinErr
+}
+
+// WaitStdin starts to read stdin into its buffer and blocks until the
+// first bytes arrive and the pre-roll is buffered (see SetStdinPreroll),
+// so that Kodi doesn't give up on a stream that takes time to start.
+func WaitStdin() error {
+	buffer, err := stdinBuffer()
+	if err != nil {
+		return err
+	}
+	if stdinPrerollSize > buffer.size {
+		log.Printf("Pre-roll limited to the stdin buffer size, %s", formatSize(buffer.size))
+	}
+	log.Println("Waiting for stdin data")
+	start := time.Now()
+	err = buffer.preroll(stdinPrerollSize, stdinPrerollTime, func(buffered int64, elapsed time.Duration) {
+		log.Printf("Pre-roll: %s of %s buffered, %v of %v", formatSize(buffered), formatSize(stdinPrerollSize),
+			elapsed.Truncate(time.Second), stdinPrerollTime)
+	})
+	if err != nil {
+		return err
+	}
+	_, end, _ := buffer.window()
+	log.Printf("Stdin ready: %s buffered in %v", formatSize(end), time.Since(start).Truncate(time.Millisecond))
+	return nil
+}
+
+// name of the stdin stream when its format is not recognized
+const DEFAULT_STDIN_NAME = "out.mp4"
+
+// maximum wait for the bytes needed to sniff the format of stdin
+const sniffTimeout = 3 * time.Second
+
+// StdinName returns the name under which stdin is served: name if set
+// (-stdin_outnm), else "out" with the extension of the format sniffed from
+// the first bytes of stdin (see utils.SniffExtension), DEFAULT_STDIN_NAME if
+// it's unknown. Call it once WaitStdin returned.
+func StdinName(name string) string {
+	if name != "" {
+		return name
+	}
+	buffer, err := stdinBuffer()
+	if err != nil {
+		utils.Fatal(err)
+	}
+	data, err := buffer.head(utils.SNIFF_SIZE, sniffTimeout)
+	if err != nil {
+		utils.Fatal(err)
+	}
+	ext := utils.SniffExtension(data)
+	if ext == "" {
+		log.Printf("Unknown stdin format, served as %s (set the name with -stdin_outnm)", DEFAULT_STDIN_NAME)
+		return DEFAULT_STDIN_NAME
+	}
+	name = "out" + ext
+	log.Printf("Stdin format detected: %s, served as %s", utils.MediaType(name), name)
+	return name
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// parseRange parses a single "bytes=first-last" range, last is -1 if
+// open. Suffix ranges ("bytes=-n") need the total size, -1 if unknown.
+func parseRange(header string, total int64) (first, last int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, false
+	}
+	spec := strings.SplitN(strings.TrimSpace(header[len("bytes="):]), "-", 2)
+	if len(spec) != 2 {
+		return 0, 0, false
+	}
+	var err error
+	if spec[0] == "" {
+		n, err := strconv.ParseInt(spec[1], 10, 64)
+		if err != nil || total < 0 {
+			return 0, 0, false
+		}
+		if n > total {
+			n = total
+		}
+		return total - n, total - 1, true
+	}
+	if first, err = strconv.ParseInt(spec[0], 10, 64); err != nil || first < 0 {
+		return 0, 0, false
+	}
+	last = -1
+	if spec[1] != "" {
+		if last, err = strconv.ParseInt(spec[1], 10, 64); err != nil || last < first {
+			return 0, 0, false
+		}
+	}
+	return first, last, true
+}
+
+// StdinHandler streams stdin, whatever the path, to any number of
+// clients. Stdin is read once in a ring buffer (see SetStdinBuffer): each
+// client reads it from the start, if still buffered, or from the offset of
+// its Range request. A seekable stream (see SetStdinSeekable) is started
+// again at the offset of a Range request. name is the fake file name of
+// the stream (see StdinName), its extension gives the Content-Type that
+// helps Kodi to pick a demuxer.
+func StdinHandler(name string) http.Handler {
+	if stdinSeekable != nil {
+		if _, err := stdinBuffer(); err != nil {
+			utils.Fatal(err)
+		}
+		return seekHandler(name, stdinSeek)
+	}
+	return bufferHandler(name, stdinBuffer)
+}
+
+// bufferHandler serves the stream buffered by the buffer returned by get
+func bufferHandler(name string, get func() (*streamBuffer, error)) http.Handler {
+	contentType := utils.MediaType(name)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buffer, err := get()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		start, end, total := buffer.window()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		offset, last, status := start, int64(-1), http.StatusOK
+		if header := r.Header.Get("Range"); header != "" {
+			first, l, ok := parseRange(header, total)
+			if ok && (first < start || first > end+buffer.size || (total >= 0 && first >= total)) {
+				size := "*"
+				if total >= 0 {
+					size = strconv.FormatInt(total, 10)
+				}
+				w.Header().Set("Content-Range", "bytes */"+size)
+				http.Error(w, fmt.Sprintf("bytes %d-%d buffered", start, end), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if ok {
+				offset, last, status = first, l, http.StatusPartialContent
+			}
+		}
+		if total >= 0 && (last < 0 || last >= total) {
+			last = total - 1
+		}
+		if status == http.StatusPartialContent {
+			size := "*"
+			if total >= 0 {
+				size = strconv.FormatInt(total, 10)
+			}
+			// the end of a stream that goes on is unknown
+			end := int64(math.MaxInt64 - 1)
+			if last >= 0 {
+				end = last
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", offset, end, size))
+		}
+		if last >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(last-offset+1, 10))
+		}
+		if verbose {
+			log.Println("Serving stdin as", name, "to", r.RemoteAddr, "from", offset, r.Header.Get("Range"))
+		} else if offset > 0 && status == http.StatusOK {
+			log.Println("Beginning of stdin no longer buffered, serving from byte", offset)
+		}
+		w.WriteHeader(status)
+		if r.Method == "HEAD" {
+			return
+		}
+
+		reader := buffer.NewReader(offset)
+		defer reader.Close()
+		var source io.Reader = reader
+		if last >= 0 {
+			source = io.LimitReader(reader, last-offset+1)
+		}
+		sendStream(w, r, source)
+	})
+}
+
+// sendStream copies source to the client as it comes
+func sendStream(w http.ResponseWriter, r *http.Request, source io.Reader) {
+	flusher, _ := w.(http.Flusher)
+	data := make([]byte, streamChunk)
+	for {
+		n, err := source.Read(data)
+		if n > 0 {
+			if _, werr := w.Write(data[:n]); werr != nil {
+				if verbose {
+					log.Println("Stdin stream interrupted:", werr)
+				}
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		// a seekable stream closes the runs no longer read
+		if err == io.EOF || err == errBufferClosed {
+			if verbose {
+				log.Println("End of stdin for", r.RemoteAddr)
+			}
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
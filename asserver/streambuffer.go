@@ -0,0 +1,380 @@
+package asserver
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// default size of the stdin buffer (-stdin_bufsize)
+const DEFAULT_STDIN_BUFFER = 32 << 20
+
+// size of the reads from the source
+const streamChunk = 32 * 1024
+
+var errOverwritten = errors.New("stream data no longer buffered")
+var errBufferClosed = errors.New("stream buffer closed")
+
+// storage of the ring, flat, offsets are in [0, size)
+type ringStore interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+type memStore []byte
+
+func (m memStore) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, m[off:]), nil
+}
+
+func (m memStore) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+func (m memStore) Close() error {
+	return nil
+}
+
+// file removed when closed
+type fileStore struct {
+	*os.File
+}
+
+func (f fileStore) Close() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// streamBuffer reads a stream (stdin) in a goroutine and keeps its last
+// bytes in a ring, so that several clients can read the stream and seek
+// back in the buffered window. The source is read only as fast as the
+// slowest client reads: bytes that a client still has to read are never
+// overwritten. Without client, the position of the last one is kept for
+// it to come back.
+type streamBuffer struct {
+	store ringStore
+	size  int64
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// bytes read from the source so far
+	written int64
+	// bytes being copied into the ring after written
+	pending int64
+	// source error, io.EOF at the end
+	err error
+	// offsets of the readers
+	readers map[*streamReader]bool
+	// lowest offset to keep when there is no reader
+	keep int64
+	// the store is closed with the last reader
+	closed bool
+}
+
+// newStreamBuffer buffers size bytes of source, in memory or in a
+// temporary file if onDisk is true, and starts to read source.
+func newStreamBuffer(source io.Reader, size int64, onDisk bool) (*streamBuffer, error) {
+	var store ringStore = make(memStore, size)
+	if onDisk {
+		f, err := ioutil.TempFile("", "idok-stdin-")
+		if err != nil {
+			return nil, err
+		}
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+		store = fileStore{f}
+	}
+	b := &streamBuffer{store: store, size: size, readers: map[*streamReader]bool{}}
+	b.cond = sync.NewCond(&b.mu)
+	go b.fill(source)
+	return b, nil
+}
+
+// low returns the lowest offset a reader may still ask, b.mu is held
+func (b *streamBuffer) low() int64 {
+	if len(b.readers) == 0 {
+		return b.keep
+	}
+	low := b.written
+	for r := range b.readers {
+		if r.offset < low {
+			low = r.offset
+		}
+	}
+	return low
+}
+
+// first returns the first offset still in the ring, b.mu is held
+func (b *streamBuffer) first() int64 {
+	return b.written + b.pending - b.size
+}
+
+// fill copies source into the ring
+func (b *streamBuffer) fill(source io.Reader) {
+	data := make([]byte, streamChunk)
+	for {
+		// room left before the bytes still needed
+		b.mu.Lock()
+		for b.low()+b.size-b.written <= 0 && !b.closed {
+			b.cond.Wait()
+		}
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		room := b.low() + b.size - b.written
+		b.mu.Unlock()
+
+		if room > int64(len(data)) {
+			room = int64(len(data))
+		}
+		n, err := source.Read(data[:room])
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return
+		}
+		written := b.written
+		b.pending = int64(n)
+		b.mu.Unlock()
+		if n > 0 {
+			if werr := b.writeAt(data[:n], written); werr != nil && err == nil {
+				err = werr
+			}
+		}
+
+		b.mu.Lock()
+		b.written += int64(n)
+		b.pending = 0
+		closed := b.closed
+		if err != nil && !closed {
+			b.err = err
+		}
+		b.cond.Broadcast()
+		b.mu.Unlock()
+		if err != nil {
+			if err != io.EOF && !closed {
+				log.Println("Stream source:", err)
+			}
+			return
+		}
+	}
+}
+
+// Close ends the stream for its readers, the store is released once they
+// are closed. The source should be closed too for fill to return.
+func (b *streamBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	if b.err == nil {
+		b.err = errBufferClosed
+	}
+	b.cond.Broadcast()
+	if len(b.readers) == 0 {
+		return b.store.Close()
+	}
+	return nil
+}
+
+// writeAt writes p at the stream offset off, wrapping at the end of the ring
+func (b *streamBuffer) writeAt(p []byte, off int64) error {
+	for len(p) > 0 {
+		pos := off % b.size
+		n := int64(len(p))
+		if pos+n > b.size {
+			n = b.size - pos
+		}
+		if _, err := b.store.WriteAt(p[:n], pos); err != nil {
+			return err
+		}
+		p, off = p[n:], off+n
+	}
+	return nil
+}
+
+// window returns the stream offsets still buffered, and the total size of
+// the stream if the source has ended, -1 else
+func (b *streamBuffer) window() (start, end, total int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	start = b.first()
+	if start < 0 {
+		start = 0
+	}
+	total = -1
+	if b.err == io.EOF {
+		total = b.written
+	}
+	return start, b.written, total
+}
+
+// failed tells if the source ended with an error
+func (b *streamBuffer) failed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err != nil && b.err != io.EOF
+}
+
+// preroll waits for the first byte of the stream, then until size more
+// bytes are buffered and duration has elapsed, or the stream has ended.
+// size is limited to the buffer size. progress, if not nil, is called every
+// second with the buffered bytes and the time since the first byte.
+func (b *streamBuffer) preroll(size int64, duration time.Duration, progress func(buffered int64, elapsed time.Duration)) error {
+	if size > b.size {
+		size = b.size
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	var first time.Time
+	lastReport := time.Now()
+	for ; ; <-ticker.C {
+		b.mu.Lock()
+		written, err := b.written, b.err
+		b.mu.Unlock()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if written == 0 && err == nil {
+			continue
+		}
+		if first.IsZero() {
+			first = time.Now()
+		}
+		elapsed := time.Since(first)
+		if err == io.EOF || (written >= size && elapsed >= duration) {
+			return nil
+		}
+		if progress != nil && time.Since(lastReport) >= time.Second {
+			progress(written, elapsed)
+			lastReport = time.Now()
+		}
+	}
+}
+
+// head waits for the first n bytes of the stream, its end or timeout, and
+// returns the bytes buffered. nil if the start of the stream is no longer
+// buffered.
+func (b *streamBuffer) head(n int64, timeout time.Duration) ([]byte, error) {
+	if n > b.size {
+		n = b.size
+	}
+	expired := false
+	timer := time.AfterFunc(timeout, func() {
+		b.mu.Lock()
+		expired = true
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.written < n && b.err == nil && !expired {
+		b.cond.Wait()
+	}
+	if b.first() > 0 {
+		return nil, nil
+	}
+	if n > b.written {
+		n = b.written
+	}
+	// the lock keeps the readers from letting fill overwrite the start
+	data := make([]byte, n)
+	if _, err := b.store.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// NewReader returns a reader of the stream from offset, the offset should
+// be in the buffered window.
+func (b *streamBuffer) NewReader(offset int64) *streamReader {
+	r := &streamReader{buffer: b, offset: offset}
+	b.mu.Lock()
+	b.readers[r] = true
+	b.mu.Unlock()
+	return r
+}
+
+// streamReader reads a streamBuffer from its offset, Read blocks until the
+// source gives more bytes.
+type streamReader struct {
+	buffer *streamBuffer
+	offset int64
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	b := r.buffer
+	b.mu.Lock()
+	for r.offset >= b.written && b.err == nil {
+		b.cond.Wait()
+	}
+	if r.offset < b.first() {
+		b.mu.Unlock()
+		return 0, errOverwritten
+	}
+	if r.offset >= b.written {
+		err := b.err
+		b.mu.Unlock()
+		return 0, err
+	}
+	if avail := b.written - r.offset; int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	b.mu.Unlock()
+
+	// the bytes between offset and written can't be overwritten while
+	// this reader is registered
+	n := 0
+	for n < len(p) {
+		pos := (r.offset + int64(n)) % b.size
+		chunk := p[n:]
+		if pos+int64(len(chunk)) > b.size {
+			chunk = chunk[:b.size-pos]
+		}
+		m, err := b.store.ReadAt(chunk, pos)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	b.mu.Lock()
+	r.offset += int64(n)
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	return n, nil
+}
+
+// Close unregisters the reader, the buffer keeps its position if it was
+// the last one.
+func (r *streamReader) Close() error {
+	b := r.buffer
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.readers[r] {
+		return nil
+	}
+	delete(b.readers, r)
+	if len(b.readers) == 0 {
+		b.keep = r.offset
+		if b.closed {
+			return b.store.Close()
+		}
+	}
+	b.cond.Broadcast()
+	return nil
+}
@@ -0,0 +1,214 @@
+package asserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stream of n bytes whose values tell their offset
+func testStream(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestStreamBufferReaders(t *testing.T) {
+	for _, onDisk := range []bool{false, true} {
+		data := testStream(100000)
+		// smaller than the stream, the ring wraps
+		b, err := newStreamBuffer(bytes.NewReader(data), 4096, onDisk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// two readers from the start, the source waits for the slowest
+		r1, r2 := b.NewReader(0), b.NewReader(0)
+		done := make(chan []byte)
+		go func() {
+			got, _ := ioutil.ReadAll(r2)
+			done <- got
+		}()
+		got, err := ioutil.ReadAll(r1)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("on disk %v: first reader got %d bytes, %v", onDisk, len(got), err)
+		}
+		if got := <-done; !bytes.Equal(got, data) {
+			t.Errorf("on disk %v: second reader got %d bytes", onDisk, len(got))
+		}
+		r1.Close()
+		r2.Close()
+
+		start, end, total := b.window()
+		if start != 100000-4096 || end != 100000 || total != 100000 {
+			t.Errorf("window %d-%d of %d", start, end, total)
+		}
+		// overwritten bytes
+		r := b.NewReader(0)
+		if _, err := r.Read(make([]byte, 10)); err != errOverwritten {
+			t.Errorf("read of an overwritten offset: %v", err)
+		}
+		r.Close()
+		b.store.Close()
+	}
+}
+
+func TestStreamBufferKeepsLastPosition(t *testing.T) {
+	source, w := io.Pipe()
+	defer w.Close()
+	b, _ := newStreamBuffer(source, 1000, false)
+	go w.Write(testStream(3000))
+
+	// the reader leaves after 500 bytes, the source stops 1000 bytes later
+	r := b.NewReader(0)
+	io.ReadFull(r, make([]byte, 500))
+	r.Close()
+	time.Sleep(50 * time.Millisecond)
+	start, end, _ := b.window()
+	if start > 500 || end != 1500 {
+		t.Errorf("window %d-%d, want the last position 500 kept", start, end)
+	}
+
+	// it comes back at its position
+	r = b.NewReader(500)
+	defer r.Close()
+	got := make([]byte, 10)
+	io.ReadFull(r, got)
+	if !bytes.Equal(got, testStream(510)[500:]) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestBufferHandlerRange(t *testing.T) {
+	data := testStream(10000)
+	b, _ := newStreamBuffer(bytes.NewReader(data), 1<<20, false)
+	for _, _, total := b.window(); total < 0; _, _, total = b.window() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s := httptest.NewServer(bufferHandler("out.mkv", func() (*streamBuffer, error) { return b, nil }))
+	defer s.Close()
+
+	for _, c := range []struct {
+		rng    string
+		status int
+		from   int
+		to     int
+		crange string
+	}{
+		{"", 200, 0, 10000, ""},
+		{"bytes=0-", 206, 0, 10000, "bytes 0-9999/10000"},
+		{"bytes=100-199", 206, 100, 200, "bytes 100-199/10000"},
+		{"bytes=9000-", 206, 9000, 10000, "bytes 9000-9999/10000"},
+		{"bytes=-10", 206, 9990, 10000, "bytes 9990-9999/10000"},
+		{"bytes=20000-", 416, 0, 0, "bytes */10000"},
+	} {
+		req, _ := http.NewRequest("GET", s.URL+"/out.mkv", nil)
+		if c.rng != "" {
+			req.Header.Set("Range", c.rng)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || resp.Header.Get("Content-Range") != c.crange {
+			t.Errorf("%q: status %d, Content-Range %q", c.rng, resp.StatusCode, resp.Header.Get("Content-Range"))
+			continue
+		}
+		if c.status != 416 && !bytes.Equal(body, data[c.from:c.to]) {
+			t.Errorf("%q: got %d bytes, want %d-%d", c.rng, len(body), c.from, c.to)
+		}
+		if ct := resp.Header.Get("Content-Type"); c.status != 416 && ct != "video/x-matroska" {
+			t.Errorf("%q: Content-Type %q", c.rng, ct)
+		}
+	}
+}
+
+// the end of a stream that goes on is unknown
+func TestBufferHandlerLiveRange(t *testing.T) {
+	source, w := io.Pipe()
+	defer w.Close()
+	b, _ := newStreamBuffer(source, 1<<20, false)
+	go w.Write(testStream(5000))
+	s := httptest.NewServer(bufferHandler("out.ts", func() (*streamBuffer, error) { return b, nil }))
+	defer s.Close()
+
+	req, _ := http.NewRequest("GET", s.URL+"/out.ts", nil)
+	req.Header.Set("Range", "bytes=1000-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	want := fmt.Sprintf("bytes 1000-%d/*", int64(1<<63-2))
+	if resp.StatusCode != 206 || resp.Header.Get("Content-Range") != want {
+		t.Fatalf("status %d, Content-Range %q", resp.StatusCode, resp.Header.Get("Content-Range"))
+	}
+	got := make([]byte, 4000)
+	if _, err := io.ReadFull(resp.Body, got); err != nil || !bytes.Equal(got, testStream(5000)[1000:]) {
+		t.Errorf("got %v", err)
+	}
+	// end of the stream, for the handler to return
+	w.Close()
+}
+
+func TestStreamBufferPreroll(t *testing.T) {
+	// a redirected file ends before the pre-roll
+	b, _ := newStreamBuffer(bytes.NewReader(testStream(100)), 1000, false)
+	if err := b.preroll(500, 0, nil); err != nil {
+		t.Errorf("short stream: %v", err)
+	}
+
+	// a slow encoder, 100 bytes each 50ms
+	source, w := io.Pipe()
+	defer w.Close()
+	go func() {
+		for i := 0; i < 20; i++ {
+			time.Sleep(50 * time.Millisecond)
+			w.Write(testStream(100))
+		}
+	}()
+	b, _ = newStreamBuffer(source, 1000, false)
+	started := time.Now()
+	// limited to the buffer size
+	if err := b.preroll(5000, 300*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, end, _ := b.window()
+	if end < 1000 || time.Since(started) < 350*time.Millisecond {
+		t.Errorf("pre-roll ended after %v with %d bytes", time.Since(started), end)
+	}
+}
+
+func TestStreamBufferHead(t *testing.T) {
+	data := testStream(10000)
+	b, _ := newStreamBuffer(bytes.NewReader(data), 8192, false)
+	head, err := b.head(4096, time.Second)
+	if err != nil || !bytes.Equal(head, data[:4096]) {
+		t.Errorf("got %d bytes, error %v, want the first 4096 bytes", len(head), err)
+	}
+
+	// shorter than asked
+	b, _ = newStreamBuffer(bytes.NewReader(data[:100]), 8192, false)
+	if head, _ = b.head(4096, time.Second); !bytes.Equal(head, data[:100]) {
+		t.Errorf("got %d bytes, want the 100 bytes of the stream", len(head))
+	}
+
+	// a slow source
+	source, w := io.Pipe()
+	defer w.Close()
+	go w.Write(data[:10])
+	b, _ = newStreamBuffer(source, 8192, false)
+	started := time.Now()
+	if head, _ = b.head(4096, 200*time.Millisecond); !bytes.Equal(head, data[:10]) || time.Since(started) > time.Second {
+		t.Errorf("got %d bytes after %v, want the 10 bytes written", len(head), time.Since(started))
+	}
+}
@@ -0,0 +1,284 @@
+package ctl
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sdbbs/idok/kodi"
+)
+
+// Exit codes returned by Run.
+const (
+	EXIT_OK          = 0
+	EXIT_USAGE       = 1
+	EXIT_UNREACHABLE = 2
+	EXIT_NOPLAYER    = 3
+	EXIT_FAILED      = 4
+)
+
+var commands = map[string]func(c *kodi.Client, args []string) int{
+	"pause":    pause,
+	"resume":   resume,
+	"toggle":   toggle,
+	"stop":     stop,
+	"seek":     seek,
+	"volume":   volume,
+	"next":     next,
+	"previous": previous,
+	"status":   status,
+}
+
+var usages = map[string]string{
+	"pause":    "pause",
+	"resume":   "resume",
+	"toggle":   "toggle (pause or resume)",
+	"stop":     "stop",
+	"seek":     "seek +30s|-1m|01:12:00",
+	"volume":   "volume 40|+5|-5|mute",
+	"next":     "next",
+	"previous": "previous",
+	"status":   "status",
+}
+
+// ordered command list for usage
+var names = []string{"pause", "resume", "toggle", "stop", "seek", "volume", "next", "previous", "status"}
+
+// IsCommand returns true if name is a remote control command.
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Usage prints commands list.
+func Usage() {
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "\t%s\n", usages[name])
+	}
+}
+
+// Run executes the command args[0] with arguments args[1:] and returns the
+// exit code.
+func Run(c *kodi.Client, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Missing command, commands are:")
+		Usage()
+		return EXIT_USAGE
+	}
+	run, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q, commands are:\n", args[0])
+		Usage()
+		return EXIT_USAGE
+	}
+	return run(c, args[1:])
+}
+
+// return the active player id or the exit code to return
+func activePlayer(c *kodi.Client) (int, int) {
+	players, err := c.PlayerGetActivePlayers()
+	if err != nil {
+		return 0, failed(err)
+	}
+	if len(players) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing is playing")
+		return 0, EXIT_NOPLAYER
+	}
+	return players[0].PlayerId, EXIT_OK
+}
+
+func failed(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return EXIT_FAILED
+}
+
+func noArgs(name string, args []string) bool {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", usages[name])
+		return false
+	}
+	return true
+}
+
+func playPause(c *kodi.Client, name string, args []string, play interface{}) int {
+	if !noArgs(name, args) {
+		return EXIT_USAGE
+	}
+	playerid, code := activePlayer(c)
+	if code != EXIT_OK {
+		return code
+	}
+	if _, err := c.PlayerPlayPause(playerid, play); err != nil {
+		return failed(err)
+	}
+	return EXIT_OK
+}
+
+func pause(c *kodi.Client, args []string) int {
+	return playPause(c, "pause", args, false)
+}
+
+func resume(c *kodi.Client, args []string) int {
+	return playPause(c, "resume", args, true)
+}
+
+func toggle(c *kodi.Client, args []string) int {
+	return playPause(c, "toggle", args, "toggle")
+}
+
+func stop(c *kodi.Client, args []string) int {
+	if !noArgs("stop", args) {
+		return EXIT_USAGE
+	}
+	players, err := c.PlayerGetActivePlayers()
+	if err != nil {
+		return failed(err)
+	}
+	if len(players) == 0 {
+		fmt.Fprintln(os.Stderr, "Nothing is playing")
+		return EXIT_NOPLAYER
+	}
+	for _, player := range players {
+		if err := c.PlayerStop(player.PlayerId); err != nil {
+			return failed(err)
+		}
+	}
+	return EXIT_OK
+}
+
+func seek(c *kodi.Client, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", usages["seek"])
+		return EXIT_USAGE
+	}
+	offset, relative, err := ParseSeek(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return EXIT_USAGE
+	}
+	playerid, code := activePlayer(c)
+	if code != EXIT_OK {
+		return code
+	}
+	if relative {
+		err = c.PlayerSeekRelative(playerid, int(offset.Seconds()))
+	} else {
+		err = c.PlayerSeekTime(playerid, kodi.NewTime(offset))
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return EXIT_OK
+}
+
+func volume(c *kodi.Client, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n", usages["volume"])
+		return EXIT_USAGE
+	}
+	arg := args[0]
+
+	if arg == "mute" {
+		muted, err := c.ApplicationSetMute("toggle")
+		if err != nil {
+			return failed(err)
+		}
+		fmt.Println("muted:", muted)
+		return EXIT_OK
+	}
+
+	value, err := strconv.Atoi(arg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad volume %q, should be a number\n", arg)
+		return EXIT_USAGE
+	}
+	if strings.HasPrefix(arg, "+") || strings.HasPrefix(arg, "-") {
+		props, err := c.ApplicationGetProperties("volume")
+		if err != nil {
+			return failed(err)
+		}
+		value += props.Volume
+	}
+	if value < 0 {
+		value = 0
+	}
+	if value > 100 {
+		value = 100
+	}
+
+	newvolume, err := c.ApplicationSetVolume(value)
+	if err != nil {
+		return failed(err)
+	}
+	fmt.Println("volume:", newvolume)
+	return EXIT_OK
+}
+
+func goTo(c *kodi.Client, name string, args []string) int {
+	if !noArgs(name, args) {
+		return EXIT_USAGE
+	}
+	playerid, code := activePlayer(c)
+	if code != EXIT_OK {
+		return code
+	}
+	if err := c.PlayerGoTo(playerid, name); err != nil {
+		return failed(err)
+	}
+	return EXIT_OK
+}
+
+func next(c *kodi.Client, args []string) int {
+	return goTo(c, "next", args)
+}
+
+func previous(c *kodi.Client, args []string) int {
+	return goTo(c, "previous", args)
+}
+
+// print player status, one "key: value" per line
+func status(c *kodi.Client, args []string) int {
+	if !noArgs("status", args) {
+		return EXIT_USAGE
+	}
+	players, err := c.PlayerGetActivePlayers()
+	if err != nil {
+		return failed(err)
+	}
+	if len(players) == 0 {
+		fmt.Println("state: stopped")
+		return EXIT_NOPLAYER
+	}
+	player := players[0]
+
+	props, err := c.PlayerGetProperties(player.PlayerId, "speed", "time", "totaltime", "percentage")
+	if err != nil {
+		return failed(err)
+	}
+	item, err := c.PlayerGetItem(player.PlayerId)
+	if err != nil {
+		return failed(err)
+	}
+	app, err := c.ApplicationGetProperties("volume", "muted")
+	if err != nil {
+		return failed(err)
+	}
+
+	state := "playing"
+	if props.Speed == 0 {
+		state = "paused"
+	}
+	title := item.Title
+	if title == "" {
+		title = item.Label
+	}
+	fmt.Println("state:", state)
+	fmt.Println("player:", player.Type)
+	fmt.Println("title:", title)
+	fmt.Println("file:", item.File)
+	fmt.Printf("time: %s / %s (%.1f%%)\n", props.Time, props.TotalTime, props.Percentage)
+	fmt.Println("volume:", app.Volume)
+	fmt.Println("muted:", app.Muted)
+	return EXIT_OK
+}
@@ -0,0 +1,6 @@
+// Ctl package gives remote control commands (pause, seek, volume...) to
+// drive the Kodi/XBMC player from scripts.
+//
+// Each command returns an exit code (see EXIT_* constants) so that
+// scripts can check the result.
+package ctl
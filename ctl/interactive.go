@@ -0,0 +1,306 @@
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdbbs/idok/kodi"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/terminal"
+)
+
+// keys handled in interactive mode
+const (
+	KEY_UP = iota + 256
+	KEY_DOWN
+	KEY_RIGHT
+	KEY_LEFT
+)
+
+// seek steps in seconds for left/right and up/down arrows
+const (
+	SEEK_SHORT = 10
+	SEEK_LONG  = 60
+)
+
+// volume step for +/- keys
+const VOLUME_STEP = 5
+
+// how long a message stays on the progress line
+const MESSAGE_DELAY = 3 * time.Second
+
+// InteractiveHelp is the key list printed when interactive mode starts.
+const InteractiveHelp = "space: pause/resume, left/right: seek 10s, up/down: seek 1m, +/-: volume, s: subtitles, a: audio track, q: stop and quit"
+
+// interactive session state
+type remote struct {
+	client *kodi.Client
+	tty    *os.File
+	quit   func()
+
+	// message shown on the progress line until msgtime
+	lock    sync.Mutex
+	message string
+	msgtime time.Time
+	// last progress line, redrawn after the log lines
+	line string
+	// log output while the terminal is raw
+	logout io.Writer
+}
+
+// Interactive puts the terminal in raw mode, reads keys to control the
+// player and shows a progress line. If stdin is not a terminal (-stdin
+// mode) the controlling terminal is used. quit is called when the user
+// presses "q" after the player is stopped. The returned function restores
+// the terminal and should be called before exiting.
+func Interactive(c *kodi.Client, quit func()) (func(), error) {
+	tty := os.Stdin
+	if !terminal.IsTerminal(int(tty.Fd())) {
+		var err error
+		tty, err = os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil || !terminal.IsTerminal(int(tty.Fd())) {
+			return nil, errors.New("interactive mode needs a terminal")
+		}
+	}
+
+	state, err := terminal.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	r := &remote{client: c, tty: tty, quit: quit, logout: log.Writer()}
+	log.SetOutput(r)
+	var once sync.Once
+	restore := func() {
+		once.Do(func() {
+			r.lock.Lock()
+			log.SetOutput(r.logout)
+			fmt.Print("\r\033[K")
+			terminal.Restore(int(tty.Fd()), state)
+			r.lock.Unlock()
+		})
+	}
+
+	fmt.Print(InteractiveHelp + "\r\n")
+	go r.readKeys()
+	go r.showProgress()
+	return restore, nil
+}
+
+// read keys from terminal and run actions
+func (r *remote) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := r.tty.Read(buf)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			r.handleKey(key)
+		}
+	}
+}
+
+// convert bytes read from terminal to keys, arrows escape sequences
+// are converted to KEY_* values
+func parseKeys(b []byte) []int {
+	keys := []int{}
+	for i := 0; i < len(b); i++ {
+		if b[i] == 0x1b && i+2 < len(b) && b[i+1] == '[' {
+			switch b[i+2] {
+			case 'A':
+				keys = append(keys, KEY_UP)
+			case 'B':
+				keys = append(keys, KEY_DOWN)
+			case 'C':
+				keys = append(keys, KEY_RIGHT)
+			case 'D':
+				keys = append(keys, KEY_LEFT)
+			}
+			i += 2
+			continue
+		}
+		keys = append(keys, int(b[i]))
+	}
+	return keys
+}
+
+func (r *remote) handleKey(key int) {
+	switch key {
+	case 'q', 'Q', 3: // 3 is CTRL+C in raw mode
+		r.stop()
+	case ' ':
+		r.withPlayer(func(playerid int) error {
+			speed, err := r.client.PlayerPlayPause(playerid, "toggle")
+			if err == nil && speed == 0 {
+				r.setMessage("paused")
+			} else if err == nil {
+				r.setMessage("playing")
+			}
+			return err
+		})
+	case KEY_RIGHT:
+		r.seek(SEEK_SHORT)
+	case KEY_LEFT:
+		r.seek(-SEEK_SHORT)
+	case KEY_UP:
+		r.seek(SEEK_LONG)
+	case KEY_DOWN:
+		r.seek(-SEEK_LONG)
+	case '+', '=':
+		r.volume(VOLUME_STEP)
+	case '-', '_':
+		r.volume(-VOLUME_STEP)
+	case 's', 'S':
+		r.withPlayer(func(playerid int) error {
+			if err := r.client.PlayerSetSubtitle(playerid, "next", true); err != nil {
+				return err
+			}
+			props, err := r.client.PlayerGetProperties(playerid, "currentsubtitle", "subtitleenabled")
+			if err == nil && props.CurrentSubtitle != nil && props.SubtitleEnabled {
+				r.setMessage("subtitle: " + props.CurrentSubtitle.String())
+			} else if err == nil {
+				r.setMessage("subtitle: off")
+			}
+			return err
+		})
+	case 'a', 'A':
+		r.withPlayer(func(playerid int) error {
+			if err := r.client.PlayerSetAudioStream(playerid, "next"); err != nil {
+				return err
+			}
+			props, err := r.client.PlayerGetProperties(playerid, "currentaudiostream")
+			if err == nil && props.CurrentAudioStream != nil {
+				r.setMessage("audio: " + props.CurrentAudioStream.String())
+			}
+			return err
+		})
+	}
+}
+
+// call f with the active player id, errors are shown on the progress line
+func (r *remote) withPlayer(f func(playerid int) error) {
+	players, err := r.client.PlayerGetActivePlayers()
+	if err != nil {
+		r.setMessage(err.Error())
+		return
+	}
+	if len(players) == 0 {
+		r.setMessage("nothing is playing")
+		return
+	}
+	if err := f(players[0].PlayerId); err != nil {
+		r.setMessage(err.Error())
+	}
+}
+
+func (r *remote) seek(seconds int) {
+	r.withPlayer(func(playerid int) error {
+		err := r.client.PlayerSeekRelative(playerid, seconds)
+		if err == nil {
+			r.setMessage(fmt.Sprintf("seek %+ds", seconds))
+		}
+		return err
+	})
+}
+
+func (r *remote) volume(step int) {
+	props, err := r.client.ApplicationGetProperties("volume")
+	if err != nil {
+		r.setMessage(err.Error())
+		return
+	}
+	value := props.Volume + step
+	if value < 0 {
+		value = 0
+	}
+	if value > 100 {
+		value = 100
+	}
+	value, err = r.client.ApplicationSetVolume(value)
+	if err != nil {
+		r.setMessage(err.Error())
+		return
+	}
+	r.setMessage(fmt.Sprintf("volume %d", value))
+}
+
+// stop players and quit
+func (r *remote) stop() {
+	players, err := r.client.PlayerGetActivePlayers()
+	if err != nil {
+		log.Println(err)
+	}
+	for _, player := range players {
+		if err := r.client.PlayerStop(player.PlayerId); err != nil {
+			log.Println(err)
+		}
+	}
+	r.quit()
+}
+
+func (r *remote) setMessage(message string) {
+	line := r.progressLine()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.message = message
+	r.msgtime = time.Now().Add(MESSAGE_DELAY)
+	r.printProgress(line)
+}
+
+// print progress line each second
+func (r *remote) showProgress() {
+	for _ = range time.Tick(time.Second) {
+		line := r.progressLine()
+		r.lock.Lock()
+		r.printProgress(line)
+		r.lock.Unlock()
+	}
+}
+
+// progressLine asks Kodi the player state, without the lock: the calls
+// may take time.
+func (r *remote) progressLine() string {
+	players, err := r.client.PlayerGetActivePlayers()
+	if err != nil || len(players) == 0 {
+		return "waiting for Kodi..."
+	}
+	props, err := r.client.PlayerGetProperties(players[0].PlayerId, "speed", "time", "totaltime", "percentage")
+	if err != nil {
+		return "waiting for Kodi..."
+	}
+	state := "playing"
+	if props.Speed == 0 {
+		state = "paused"
+	}
+	return fmt.Sprintf("[%s] %s / %s (%.0f%%)", state, props.Time, props.TotalTime, props.Percentage)
+}
+
+// Write writes log lines above the progress line: the raw terminal needs
+// "\r\n" to start a line.
+func (r *remote) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fmt.Print("\r\033[K")
+	if _, err := io.WriteString(r.logout, strings.Replace(string(p), "\n", "\r\n", -1)); err != nil {
+		return 0, err
+	}
+	if r.line != "" {
+		r.printProgress(r.line)
+	}
+	return len(p), nil
+}
+
+// print the progress line with the current message, lock must be held
+func (r *remote) printProgress(line string) {
+	r.line = line
+	if time.Now().Before(r.msgtime) {
+		line += " - " + r.message
+	}
+	fmt.Print("\r\033[K" + line)
+}
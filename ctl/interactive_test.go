@@ -0,0 +1,18 @@
+package ctl
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestLogOutput(t *testing.T) {
+	var out bytes.Buffer
+	r := &remote{logout: &out}
+	logger := log.New(r, "", 0)
+	logger.Println("first line\nsecond line")
+	logger.Print("third line")
+	if want := "first line\r\nsecond line\r\nthird line\r\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,51 @@
+package ctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseSeek parses seek argument. "+30s", "-1m30s" or "+90" (seconds) are
+// relative, "01:12:00", "12:00" or "1h12m" are absolute positions.
+func ParseSeek(arg string) (offset time.Duration, relative bool, err error) {
+	value := arg
+	sign := time.Duration(1)
+	if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+		relative = true
+		if value[0] == '-' {
+			sign = -1
+		}
+		value = value[1:]
+	}
+
+	if strings.Contains(value, ":") {
+		offset, err = parseClock(value)
+	} else if seconds, e := strconv.Atoi(value); e == nil {
+		offset = time.Duration(seconds) * time.Second
+	} else {
+		offset, err = time.ParseDuration(value)
+	}
+	if err != nil || offset < 0 {
+		return 0, false, fmt.Errorf("Bad seek value %q, use +30s, -1m, 01:12:00...", arg)
+	}
+	return sign * offset, relative, nil
+}
+
+// parse [[hh:]mm:]ss
+func parseClock(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("too many fields in %q", value)
+	}
+	var d time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad number %q", part)
+		}
+		d = d*60 + time.Duration(n)
+	}
+	return d * time.Second, nil
+}
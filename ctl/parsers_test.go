@@ -0,0 +1,42 @@
+package ctl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSeek(t *testing.T) {
+	tests := []struct {
+		arg      string
+		offset   time.Duration
+		relative bool
+	}{
+		{"+30s", 30 * time.Second, true},
+		{"-1m30s", -90 * time.Second, true},
+		{"+90", 90 * time.Second, true},
+		{"-10", -10 * time.Second, true},
+		{"+01:30", 90 * time.Second, true},
+		{"01:12:00", 72 * time.Minute, false},
+		{"12:00", 12 * time.Minute, false},
+		{"1:2:3", time.Hour + 2*time.Minute + 3*time.Second, false},
+		{"45", 45 * time.Second, false},
+		{"1h12m", 72 * time.Minute, false},
+		{"0", 0, false},
+	}
+	for _, test := range tests {
+		offset, relative, err := ParseSeek(test.arg)
+		if err != nil {
+			t.Errorf("%q: %v", test.arg, err)
+			continue
+		}
+		if offset != test.offset || relative != test.relative {
+			t.Errorf("%q: got %v, %v, want %v, %v", test.arg, offset, relative, test.offset, test.relative)
+		}
+	}
+
+	for _, arg := range []string{"", "+", "abc", "50%", "1:2:3:4", "12:-5", "12:xx", "--30s", "+-30s", "-5m-3s", "1.5"} {
+		if offset, _, err := ParseSeek(arg); err == nil {
+			t.Errorf("%q: no error, got %v", arg, offset)
+		}
+	}
+}
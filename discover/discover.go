@@ -0,0 +1,235 @@
+package discover
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sdbbs/idok/kodi"
+)
+
+var verbose = false
+
+func SetVerbose(inbool bool) {
+	verbose = inbool
+	if verbose {
+		log.Println(" discover verbose: ", verbose)
+	}
+}
+
+// Host is a Kodi found on the network.
+type Host struct {
+	// name announced by Kodi
+	Name string
+
+	// ip and jsonrpc port
+	Address string
+	Port    int
+
+	// Kodi version and jsonrpc API version
+	Version    string
+	APIVersion string
+
+	// "mdns" or "ssdp"
+	Source string
+}
+
+// Discoverer sends mDNS and SSDP queries and probes answers.
+type Discoverer struct {
+	// multicast addresses to query (can be unicast addresses for tests)
+	MDNSAddr string
+	SSDPAddr string
+
+	// time to wait for answers
+	Timeout time.Duration
+
+	// jsonrpc ports to probe on hosts found with SSDP, that doesn't give
+	// the jsonrpc port
+	Ports []int
+
+	// jsonrpc credentials
+	User     string
+	Password string
+}
+
+// candidate host to probe
+type candidate struct {
+	name    string
+	address string
+	ports   []int
+	source  string
+}
+
+// New returns a Discoverer with default addresses and timeout. port is
+// probed first on hosts found with SSDP, then Kodi default ports.
+func New(port int, user, password string) *Discoverer {
+	ports := []int{port}
+	for _, p := range []int{8080, 80} {
+		if p != port {
+			ports = append(ports, p)
+		}
+	}
+	return &Discoverer{
+		MDNSAddr: "224.0.0.251:5353",
+		SSDPAddr: "239.255.255.250:1900",
+		Timeout:  2 * time.Second,
+		Ports:    ports,
+		User:     user,
+		Password: password,
+	}
+}
+
+// Run queries the network and returns reachable Kodi hosts, sorted by name.
+// An error is returned only if no query can be sent.
+func (d *Discoverer) Run() ([]Host, error) {
+	var (
+		wg         sync.WaitGroup
+		lock       sync.Mutex
+		candidates []candidate
+		errs       []error
+	)
+	for _, browse := range []func() ([]candidate, error){d.browseMDNS, d.browseSSDP} {
+		wg.Add(1)
+		go func(browse func() ([]candidate, error)) {
+			defer wg.Done()
+			found, err := browse()
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			candidates = append(candidates, found...)
+		}(browse)
+	}
+	wg.Wait()
+	if len(errs) == 2 {
+		return nil, errs[0]
+	}
+	if verbose {
+		for _, err := range errs {
+			log.Println(" discover: ", err)
+		}
+	}
+
+	hosts := d.probe(candidates)
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Name != hosts[j].Name {
+			return hosts[i].Name < hosts[j].Name
+		}
+		return hosts[i].Address < hosts[j].Address
+	})
+	return hosts, nil
+}
+
+// probe candidates with JSONRPC.Version, a host found twice (mDNS and
+// SSDP) is returned once
+func (d *Discoverer) probe(candidates []candidate) []Host {
+	var (
+		wg    sync.WaitGroup
+		lock  sync.Mutex
+		hosts = map[string]Host{}
+	)
+	for _, c := range candidates {
+		wg.Add(1)
+		go func(c candidate) {
+			defer wg.Done()
+			for _, port := range c.ports {
+				host, err := d.probeHost(c, port)
+				if err != nil {
+					if verbose {
+						log.Println(" discover: probe", c.address, port, err)
+					}
+					continue
+				}
+				key := net.JoinHostPort(host.Address, fmt.Sprint(host.Port))
+				lock.Lock()
+				// mDNS names are better than UPnP ones
+				if _, found := hosts[key]; !found || host.Source == "mdns" {
+					hosts[key] = *host
+				}
+				lock.Unlock()
+				return
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	list := []Host{}
+	for _, host := range hosts {
+		list = append(list, host)
+	}
+	return list
+}
+
+func (d *Discoverer) probeHost(c candidate, port int) (*Host, error) {
+	client := kodi.NewClient(c.address, port, d.User, d.Password)
+	client.HTTP = &http.Client{Timeout: d.Timeout}
+	apiversion, err := client.JSONRPCVersion()
+	if err != nil {
+		return nil, err
+	}
+	host := &Host{
+		Name:       c.name,
+		Address:    c.address,
+		Port:       port,
+		APIVersion: apiversion.String(),
+		Source:     c.source,
+	}
+	if props, err := client.ApplicationGetProperties("name", "version"); err == nil {
+		host.Version = fmt.Sprintf("%d.%d", props.Version.Major, props.Version.Minor)
+		if host.Name == "" {
+			host.Name = props.Name
+		}
+	}
+	return host, nil
+}
+
+// send query to addr and call answer for each received packet until
+// timeout
+func (d *Discoverer) query(addr string, query []byte, answer func(packet []byte, from *net.UDPAddr)) error {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	// answers are sent back to our port (unicast)
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteToUDP(query, raddr); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(d.Timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return nil
+			}
+			return err
+		}
+		packet := make([]byte, n)
+		copy(packet, buf[:n])
+		answer(packet, from)
+	}
+}
+
+// Print writes hosts as a table.
+func Print(w io.Writer, hosts []Host) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tADDRESS\tPORT\tVERSION\tAPI\tFOUND BY")
+	for _, host := range hosts {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", host.Name, host.Address, host.Port, host.Version, host.APIVersion, host.Source)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,198 @@
+package discover
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fake Kodi answering jsonrpc and UPnP description
+func fakeKodi(t *testing.T) (*httptest.Server, int) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/description.xml" {
+			fmt.Fprint(w, `<?xml version="1.0"?><root xmlns="urn:schemas-upnp-org:device-1-0">`+
+				`<device><friendlyName>Kodi UPnP</friendlyName></device></root>`)
+			return
+		}
+		req := struct {
+			Id     int64
+			Method string
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.Method {
+		case "JSONRPC.Version":
+			fmt.Fprintf(w, `{"id":%d,"jsonrpc":"2.0","result":{"version":{"major":12,"minor":4,"patch":0}}}`, req.Id)
+		case "Application.GetProperties":
+			fmt.Fprintf(w, `{"id":%d,"jsonrpc":"2.0","result":{"name":"Kodi","version":{"major":20,"minor":2}}}`, req.Id)
+		default:
+			fmt.Fprintf(w, `{"id":%d,"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found."}}`, req.Id)
+		}
+	}))
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	var p int
+	fmt.Sscan(port, &p)
+	return s, p
+}
+
+// start an UDP responder on localhost, answer returns the packet to send
+// back for a query
+func fakeResponder(t *testing.T, answer func(query []byte) []byte) (string, func()) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 9000)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if resp := answer(buf[:n]); resp != nil {
+				conn.WriteToUDP(resp, from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func appendRecord(msg []byte, name string, rtype uint16, rdata []byte) []byte {
+	msg = appendName(msg, name)
+	msg = appendUint16(msg, rtype)
+	msg = appendUint16(msg, CLASS_IN)
+	msg = append(msg, 0, 0, 0x11, 0x94) // ttl
+	msg = appendUint16(msg, uint16(len(rdata)))
+	return append(msg, rdata...)
+}
+
+// mDNS answer announcing "Kodi (living)" on 127.0.0.1:port
+func mdnsAnswer(port int) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400)
+	binary.BigEndian.PutUint16(msg[6:], 1)  // answers
+	binary.BigEndian.PutUint16(msg[10:], 2) // additionals
+	instance := "Kodi (living)." + MDNS_SERVICE
+	msg = appendRecord(msg, MDNS_SERVICE, TYPE_PTR, appendName(nil, instance))
+	srv := []byte{0, 0, 0, 0, byte(port >> 8), byte(port)}
+	msg = appendRecord(msg, instance, TYPE_SRV, appendName(srv, "livingroom.local."))
+	return appendRecord(msg, "livingroom.local.", TYPE_A, []byte{127, 0, 0, 1})
+}
+
+func TestDiscover(t *testing.T) {
+	kodi, port := fakeKodi(t)
+	defer kodi.Close()
+
+	mdnsaddr, closemdns := fakeResponder(t, func(query []byte) []byte {
+		if string(query) != string(mdnsQuery(MDNS_SERVICE)) {
+			t.Errorf("bad mDNS query %q", query)
+			return nil
+		}
+		return mdnsAnswer(port)
+	})
+	defer closemdns()
+
+	ssdpaddr, closessdp := fakeResponder(t, func(query []byte) []byte {
+		if !strings.Contains(string(query), "ST: "+SSDP_TARGET) {
+			t.Errorf("bad SSDP query %q", query)
+			return nil
+		}
+		return []byte("HTTP/1.1 200 OK\r\nST: " + SSDP_TARGET + "\r\n" +
+			"LOCATION: " + kodi.URL + "/description.xml\r\n\r\n")
+	})
+	defer closessdp()
+
+	d := New(port, "", "")
+	d.MDNSAddr, d.SSDPAddr = mdnsaddr, ssdpaddr
+	d.Timeout = 300 * time.Millisecond
+	hosts, err := d.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// found twice, mDNS name is kept
+	if len(hosts) != 1 {
+		t.Fatalf("want 1 host, got %+v", hosts)
+	}
+	want := Host{
+		Name:       "Kodi (living)",
+		Address:    "127.0.0.1",
+		Port:       port,
+		Version:    "20.2",
+		APIVersion: "12.4.0",
+		Source:     "mdns",
+	}
+	if hosts[0] != want {
+		t.Errorf("got %+v, want %+v", hosts[0], want)
+	}
+}
+
+func TestDiscoverSSDP(t *testing.T) {
+	kodi, port := fakeKodi(t)
+	defer kodi.Close()
+
+	// mDNS responder that doesn't answer
+	mdnsaddr, closemdns := fakeResponder(t, func([]byte) []byte { return nil })
+	defer closemdns()
+	ssdpaddr, closessdp := fakeResponder(t, func([]byte) []byte {
+		return []byte("HTTP/1.1 200 OK\r\nLOCATION: " + kodi.URL + "/description.xml\r\n\r\n")
+	})
+	defer closessdp()
+
+	// the jsonrpc port is found in d.Ports
+	d := New(1, "", "")
+	d.MDNSAddr, d.SSDPAddr = mdnsaddr, ssdpaddr
+	d.Ports = []int{1, port}
+	d.Timeout = 300 * time.Millisecond
+	hosts, err := d.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Name != "Kodi UPnP" || hosts[0].Port != port || hosts[0].Source != "ssdp" {
+		t.Errorf("got %+v", hosts)
+	}
+}
+
+func TestNoAnswer(t *testing.T) {
+	addr, closeresponder := fakeResponder(t, func([]byte) []byte { return nil })
+	defer closeresponder()
+
+	d := New(8080, "", "")
+	d.MDNSAddr, d.SSDPAddr = addr, addr
+	d.Timeout = 100 * time.Millisecond
+	hosts, err := d.Run()
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("want no host, got %v %v", hosts, err)
+	}
+}
+
+func TestParseMessageCompression(t *testing.T) {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	msg = appendRecord(msg, MDNS_SERVICE, TYPE_PTR, nil)
+	// PTR target "Kodi" + pointer to the record name at offset 12
+	rdata := append([]byte{4, 'K', 'o', 'd', 'i'}, 0xc0, 12)
+	msg = msg[:len(msg)-2]
+	msg = appendUint16(msg, uint16(len(rdata)))
+	msg = append(msg, rdata...)
+
+	records, err := parseMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].target != "Kodi."+MDNS_SERVICE {
+		t.Errorf("got %+v", records)
+	}
+
+	// pointer loop
+	loop := append(make([]byte, 12), 0xc0, 12)
+	binary.BigEndian.PutUint16(loop[4:], 1)
+	if _, err := parseMessage(loop); err == nil {
+		t.Error("want error on pointer loop")
+	}
+}
@@ -0,0 +1,6 @@
+// Discover package looks for Kodi/XBMC media centers on the local network.
+//
+// Kodi announces its JSON-RPC HTTP server with Zeroconf (mDNS service
+// _xbmc-jsonrpc-h._tcp) and its UPnP renderer with SSDP. Each found host
+// is probed with JSONRPC.Version to check that it can be reached.
+package discover
@@ -0,0 +1,237 @@
+package discover
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// service announced by Kodi for its jsonrpc HTTP server
+const MDNS_SERVICE = "_xbmc-jsonrpc-h._tcp.local."
+
+// DNS record types
+const (
+	TYPE_A   = 1
+	TYPE_PTR = 12
+	TYPE_TXT = 16
+	TYPE_SRV = 33
+)
+
+const CLASS_IN = 1
+
+// bit of question class asking for unicast answer
+const CLASS_UNICAST = 0x8000
+
+// DNS resource record, only fields of used types are set.
+type record struct {
+	name  string
+	rtype uint16
+
+	// PTR and SRV target name
+	target string
+
+	// SRV port
+	port uint16
+
+	// A address
+	ip net.IP
+}
+
+var errBadMessage = errors.New("discover: bad DNS message")
+
+// browse Kodi services with mDNS
+func (d *Discoverer) browseMDNS() ([]candidate, error) {
+	records := []record{}
+	// address records of senders, used for answers without address
+	fallbacks := []record{}
+	err := d.query(d.MDNSAddr, mdnsQuery(MDNS_SERVICE), func(packet []byte, from *net.UDPAddr) {
+		found, err := parseMessage(packet)
+		if err != nil {
+			return
+		}
+		records = append(records, found...)
+		for _, r := range found {
+			if r.rtype == TYPE_SRV {
+				fallbacks = append(fallbacks, record{name: r.target, rtype: TYPE_A, ip: from.IP})
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, fallbacks...)
+	return mdnsCandidates(records), nil
+}
+
+// resolve PTR -> SRV -> A records of MDNS_SERVICE
+func mdnsCandidates(records []record) []candidate {
+	srv := map[string]record{}
+	addresses := map[string]net.IP{}
+	for _, r := range records {
+		switch r.rtype {
+		case TYPE_SRV:
+			srv[strings.ToLower(r.name)] = r
+		case TYPE_A:
+			// first record wins, fallbacks come last
+			if _, found := addresses[strings.ToLower(r.name)]; !found {
+				addresses[strings.ToLower(r.name)] = r.ip
+			}
+		}
+	}
+
+	candidates := []candidate{}
+	seen := map[string]bool{}
+	for _, r := range records {
+		if r.rtype != TYPE_PTR || !strings.EqualFold(r.name, MDNS_SERVICE) || seen[r.target] {
+			continue
+		}
+		seen[r.target] = true
+		s, found := srv[strings.ToLower(r.target)]
+		if !found {
+			continue
+		}
+		ip, found := addresses[strings.ToLower(s.target)]
+		if !found {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			name:    instanceName(r.target),
+			address: ip.String(),
+			ports:   []int{int(s.port)},
+			source:  "mdns",
+		})
+	}
+	return candidates
+}
+
+// "Kodi (living)._xbmc-jsonrpc-h._tcp.local." -> "Kodi (living)"
+func instanceName(name string) string {
+	if i := strings.Index(strings.ToLower(name), "."+MDNS_SERVICE); i > 0 {
+		return strings.Replace(name[:i], `\.`, ".", -1)
+	}
+	return name
+}
+
+// mdnsQuery returns a DNS query of PTR records of service.
+func mdnsQuery(service string) []byte {
+	msg := make([]byte, 12)
+	// id 0, flags 0, one question
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	msg = appendName(msg, service)
+	msg = appendUint16(msg, TYPE_PTR)
+	msg = appendUint16(msg, CLASS_IN|CLASS_UNICAST)
+	return msg
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// append name as DNS labels, without compression
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// parseMessage returns answer, authority and additional records of msg.
+func parseMessage(msg []byte) ([]record, error) {
+	if len(msg) < 12 {
+		return nil, errBadMessage
+	}
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+
+	records := []record{}
+	for i := 0; i < rrcount; i++ {
+		name, next, err := readName(msg, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return nil, errBadMessage
+		}
+		r := record{name: name, rtype: binary.BigEndian.Uint16(msg[off:])}
+		rdlength := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlength > len(msg) {
+			return nil, errBadMessage
+		}
+		rdata := msg[off : off+rdlength]
+
+		switch r.rtype {
+		case TYPE_PTR:
+			r.target, _, err = readName(msg, off)
+		case TYPE_SRV:
+			if rdlength < 7 {
+				return nil, errBadMessage
+			}
+			r.port = binary.BigEndian.Uint16(rdata[4:])
+			r.target, _, err = readName(msg, off+6)
+		case TYPE_A:
+			if rdlength != 4 {
+				return nil, errBadMessage
+			}
+			r.ip = net.IP(append([]byte{}, rdata...))
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+		off += rdlength
+	}
+	return records, nil
+}
+
+// read the (maybe compressed) name at off, return it and the offset after
+// the name
+func readName(msg []byte, off int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	// compression pointers must go backward, limit jumps to avoid loops
+	for jumps := 0; jumps < 64; {
+		if off >= len(msg) {
+			return "", 0, errBadMessage
+		}
+		length := int(msg[off])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case length&0xc0 == 0xc0:
+			if off+1 >= len(msg) {
+				return "", 0, errBadMessage
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+length > len(msg) {
+				return "", 0, errBadMessage
+			}
+			label := string(msg[off+1 : off+1+length])
+			labels = append(labels, strings.Replace(label, ".", `\.`, -1))
+			off += 1 + length
+		}
+	}
+	return "", 0, errBadMessage
+}
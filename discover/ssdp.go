@@ -0,0 +1,79 @@
+package discover
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// UPnP device type searched with SSDP
+const SSDP_TARGET = "urn:schemas-upnp-org:device:MediaRenderer:1"
+
+// ssdpQuery returns the M-SEARCH request sent to addr.
+func ssdpQuery(addr string) []byte {
+	return []byte(fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: %s\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: 1\r\n"+
+		"ST: %s\r\n\r\n", addr, SSDP_TARGET))
+}
+
+// browse UPnP media renderers with SSDP, each one is a candidate on every
+// d.Ports
+func (d *Discoverer) browseSSDP() ([]candidate, error) {
+	locations := []string{}
+	seen := map[string]bool{}
+	err := d.query(d.SSDPAddr, ssdpQuery(d.SSDPAddr), func(packet []byte, from *net.UDPAddr) {
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(packet)), nil)
+		if err != nil {
+			return
+		}
+		resp.Body.Close()
+		location := resp.Header.Get("Location")
+		if location != "" && !seen[location] {
+			seen[location] = true
+			locations = append(locations, location)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []candidate{}
+	for _, location := range locations {
+		u, err := url.Parse(location)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			name:    d.friendlyName(location),
+			address: u.Hostname(),
+			ports:   d.Ports,
+			source:  "ssdp",
+		})
+	}
+	return candidates, nil
+}
+
+// friendly name of the UPnP device description at location
+func (d *Discoverer) friendlyName(location string) string {
+	client := &http.Client{Timeout: d.Timeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	description := struct {
+		Device struct {
+			FriendlyName string `xml:"friendlyName"`
+		} `xml:"device"`
+	}{}
+	if err := xml.NewDecoder(resp.Body).Decode(&description); err != nil {
+		return ""
+	}
+	return description.Device.FriendlyName
+}
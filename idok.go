@@ -5,14 +5,15 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
-	"net/http"
-	"bytes"
-	"io/ioutil" //  Go 1.16 -- Q1 2021 -- ioutil deprecation: ioutil.ReadAll() => io.ReadAll()):
 
 	"github.com/sdbbs/idok/asserver"
+	"github.com/sdbbs/idok/ctl"
+	"github.com/sdbbs/idok/discover"
+	"github.com/sdbbs/idok/kodi"
+	"github.com/sdbbs/idok/transcode"
 	"github.com/sdbbs/idok/tunnel"
 	"github.com/sdbbs/idok/utils"
 )
@@ -25,28 +26,48 @@ var (
 
 func main() {
 
+	// configuration options, utils.LoadConfig uses them only if they are given
+	flag.String("target", "", "xbmc/kodi ip (raspbmc address, ip or hostname)")
+	flag.String("login", "", "jsonrpc login (configured in xbmc settings)")
+	flag.String("password", "", "jsonrpc password (configured in xbmc settings)")
+	flag.Bool("ssh", false, "use SSH Tunnelling (need ssh user and password)")
+	flag.Bool("nossh", false, "force to not use SSH tunnel - usefull to override configuration file")
+	flag.Int("port", 8080, "local port (ignored if you use ssh option)")
+	flag.String("sshuser", "pi", "ssh login")
+	flag.String("sshpass", "", "ssh password")
+	flag.Int("sshport", 22, "target ssh port")
+	flag.String("agentidentity", "", "ssh-agent identity to use only: key comment, SHA256 fingerprint or public key file")
+	flag.String("identity", "", "private key files to use only, comma separated")
+	flag.String("proxyjump", "", "ssh jump hosts to reach the target, comma separated [user@]host[:port]")
+	flag.String("remoteport", "", "port or port range (eg. 10000-10100) to open on the target with -ssh, chosen by the ssh server if empty")
+	flag.String("ffmpeg", "ffmpeg", "ffmpeg executable used by -transcode")
+	flag.String("hostkeycheck", "accept-new", "ssh host key checking against ~/.ssh/known_hosts: strict, accept-new or off")
+	flag.String("profile", "", "profile (section of the configuration file) to use")
+	flag.Int("targetport", 80, "XBMC/Kodi jsonrpc port")
+	flag.Int("notifyport", 9090, "XBMC/Kodi TCP jsonrpc port, used to know when media stops")
+	flag.Bool("disable-check-release", false, "disable release check")
+
 	// flags
 	var (
-		xbmcaddr        = flag.String("target", "", "xbmc/kodi ip (raspbmc address, ip or hostname)")
-		username        = flag.String("login", "", "jsonrpc login (configured in xbmc settings)")
-		password        = flag.String("password", "", "jsonrpc password (configured in xbmc settings)")
-		viassh          = flag.Bool("ssh", false, "use SSH Tunnelling (need ssh user and password)")
-		nossh           = flag.Bool("nossh", false, "force to not use SSH tunnel - usefull to override configuration file")
-		port            = flag.Int("port", 8080, "local port (ignored if you use ssh option)")
-		sshuser         = flag.String("sshuser", "pi", "ssh login")
-		sshpassword     = flag.String("sshpass", "", "ssh password")
-		sshport         = flag.Int("sshport", 22, "target ssh port")
-		version         = flag.Bool("version", false, fmt.Sprintf("Print the current version (%s)", VERSION))
-		xbmcport        = flag.Int("targetport", 80, "XBMC/Kodi jsonrpc port")
-		stdin           = flag.Bool("stdin", false, "read file from stdin to stream")
-		stdin_outnm     = flag.String("stdin_outnm", "out.mp4", "fake name of the stdin stream in the output url")
-		stdin_nokodicmd = flag.Bool("stdin_nokodicmd", false, "If set/true, then no Kodi command is sent (tests stdin server)")
-		confexample     = flag.Bool("conf-example", false, "print a configuration file example to STDOUT")
-		disablecheck    = flag.Bool("disable-check-release", false, "disable release check")
-		checknew        = flag.Bool("check-release", false, "check for new release")
-		verbose         = flag.Bool("verbose", false, "bit more verbose log output")
-		sendtokodiplay  = flag.Bool("sendtokodiplay", false, "Send the argument (URL) to SendToKodi addon in Kodi and play")
-		sendtokodiadd   = flag.Bool("sendtokodiadd", false, "Send the argument (URL) to SendToKodi addon in Kodi and add to video (id 1) playlist")
+		version           = flag.Bool("version", false, fmt.Sprintf("Print the current version (%s)", VERSION))
+		stdin             = flag.Bool("stdin", false, "read file from stdin to stream")
+		stdin_outnm       = flag.String("stdin_outnm", "", "fake name of the stdin stream in the output url, its extension gives the format (sniffed from the stream if empty)")
+		stdin_nokodicmd   = flag.Bool("stdin_nokodicmd", false, "If set/true, then no Kodi command is sent (tests stdin server)")
+		stdin_bufsize     = flag.String("stdin_bufsize", "32M", "size of the stdin buffer that clients and range requests read from (K, M or G suffix)")
+		stdin_buffile     = flag.Bool("stdin_buffile", false, "keep the stdin buffer in a temporary file instead of memory")
+		stdin_preroll     = flag.String("stdin_preroll", "0", "stdin bytes to buffer before Kodi plays the stream (K, M or G suffix)")
+		stdin_prerolltime = flag.Duration("stdin_prerolltime", 0, "time to buffer stdin before Kodi plays the stream (eg. 3s)")
+		confexample       = flag.Bool("conf-example", false, "print a configuration file example to STDOUT")
+		checknew          = flag.Bool("check-release", false, "check for new release")
+		verbose           = flag.Bool("verbose", false, "bit more verbose log output")
+		sendtokodiplay    = flag.Bool("sendtokodiplay", false, "Send the argument (URL) to SendToKodi addon in Kodi and play")
+		sendtokodiadd     = flag.Bool("sendtokodiadd", false, "Send the argument (URL) to SendToKodi addon in Kodi and add to video (id 1) playlist")
+		recursive         = flag.Bool("recursive", false, "serve media files of subdirectories when a directory is given")
+		shuffle           = flag.Bool("shuffle", false, "shuffle the playlist when several files are given")
+		repeat            = flag.String("repeat", "", "playlist repeat mode: off, one or all")
+		interactive       = flag.Bool("interactive", false, "control the player with the keyboard while streaming ("+ctl.InteractiveHelp+")")
+		transcodeprofile  = flag.String("transcode", "", "convert the file with ffmpeg and stream the result, profile: "+strings.Join(transcode.ProfileNames(), ", "))
+		transcode_seek    = flag.Bool("transcode_seek", true, "let Kodi seek in the -transcode stream, ffmpeg is started again where Kodi seeks (MPEG-TS stream)")
 	)
 
 	flag.Usage = utils.Usage
@@ -54,8 +75,22 @@ func main() {
 	flag.Parse()
 
 	utils.SetVerbose(*verbose)
+	kodi.SetVerbose(*verbose)
+	discover.SetVerbose(*verbose)
 	asserver.SetVerbose(*verbose)
+	tunnel.SetVerbose(*verbose)
+	transcode.SetVerbose(*verbose)
 	asserver.SetNoKodiCmd(*stdin_nokodicmd)
+	if size, err := utils.ParseSize(*stdin_bufsize); err != nil || size <= 0 {
+		utils.Fatalf("invalid -stdin_bufsize %q", *stdin_bufsize)
+	} else {
+		asserver.SetStdinBuffer(size, *stdin_buffile)
+	}
+	if size, err := utils.ParseSize(*stdin_preroll); err != nil || size < 0 {
+		utils.Fatalf("invalid -stdin_preroll %q", *stdin_preroll)
+	} else {
+		asserver.SetStdinPreroll(size, *stdin_prerolltime)
+	}
 
 	// print the current version
 	if *version {
@@ -70,37 +105,27 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Set new configuration from options
-	conf := &utils.Config{
-		Target:       *xbmcaddr,
-		Targetport:   *xbmcport,
-		Localport:    *port,
-		User:         *username,
-		Password:     *password,
-		Sshuser:      *sshuser,
-		Sshpassword:  *sshpassword,
-		Sshport:      *sshport,
-		Ssh:          *viassh,
-		ReleaseCheck: *disablecheck,
-	}
-
-	// check if conf file exists and override options
-	if filename, found := utils.CheckLocalConfigFiles(); found {
-		utils.LoadLocalConfig(filename, conf)
+	// Build configuration: defaults, configuration files, environment and options
+	conf, err := utils.LoadConfig()
+	if err != nil {
+		utils.Fatal(err)
 	}
 
 	if *verbose {
 		log.Println("Configuration settings are:")
-		log.Println("Target       : ", *xbmcaddr)
-		log.Println("Targetport   : ", *xbmcport)
-		log.Println("Localport    : ", *port)
-		log.Println("User         : ", *username)
-		log.Println("Password     : ", *password)
-		log.Println("Sshuser      : ", *sshuser)
-		log.Println("Sshpassword  : ", *sshpassword)
-		log.Println("Sshport      : ", *sshport)
-		log.Println("Ssh          : ", *viassh)
-		log.Println("ReleaseCheck : ", *disablecheck)
+		utils.PrintConfig(os.Stderr, conf)
+	}
+
+	// print configuration and where each value comes from
+	if flag.Arg(0) == "config" {
+		if _, err := os.Stat(flag.Arg(0)); os.IsNotExist(err) {
+			if flag.Arg(1) != "show" || flag.NArg() > 2 {
+				fmt.Println("\033[33mUsage: idok [options] config show\033[0m")
+				os.Exit(1)
+			}
+			utils.PrintConfig(os.Stdout, conf)
+			os.Exit(0)
+		}
 	}
 
 	// Release check
@@ -133,15 +158,82 @@ func main() {
 		}
 	}
 
+	if *repeat != "" && *repeat != "off" && *repeat != "one" && *repeat != "all" {
+		fmt.Println("\033[33mRepeat mode should be off, one or all\033[0m")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if _, ok := transcode.Profiles[*transcodeprofile]; *transcodeprofile != "" && !ok {
+		fmt.Printf("\033[33mTranscoding profile should be %s\033[0m\n", strings.Join(transcode.ProfileNames(), ", "))
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// list media centers of the network
+	if flag.Arg(0) == "discover" {
+		if _, err := os.Stat(flag.Arg(0)); os.IsNotExist(err) {
+			hosts, err := discover.New(conf.Targetport, conf.User, conf.Password).Run()
+			if err != nil {
+				utils.Fatal(err)
+			}
+			if len(hosts) == 0 {
+				fmt.Println("No Kodi/XBMC found on the network")
+				os.Exit(3)
+			}
+			discover.Print(os.Stdout, hosts)
+			os.Exit(0)
+		}
+	}
+
+	// no target, try to find one on the network
+	if conf.Target == "" {
+		log.Println("No target given, looking for Kodi/XBMC on the network...")
+		hosts, err := discover.New(conf.Targetport, conf.User, conf.Password).Run()
+		if err != nil {
+			log.Println(err)
+		}
+		if len(hosts) == 1 {
+			log.Printf("Using %s (%s:%d)\n", hosts[0].Name, hosts[0].Address, hosts[0].Port)
+			conf.Target, conf.Targetport = hosts[0].Address, hosts[0].Port
+		} else if len(hosts) > 1 {
+			fmt.Println("\033[33mSeveral Kodi/XBMC found, choose one with -target (and -targetport):\033[0m")
+			discover.Print(os.Stdout, hosts)
+			os.Exit(1)
+		}
+	}
+
 	if conf.Target == "" {
 		fmt.Println("\033[33mYou must provide the xbmc server address\033[0m")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	// ssh host aliases of ~/.ssh/config, resolved before jsonrpc uses the target
+	var sshhost *tunnel.Host
+	if conf.Ssh {
+		sshhost, err = tunnel.ApplySSHConfig(conf)
+		if err != nil {
+			utils.Fatal(err)
+		}
+	}
+
 	utils.SetTarget(conf)
 
-	// note: method is mostly Player.Open, via utils/const.go: BODY and YOUTUBEAPI
+	// ssh connection, through the jump hosts if any
+	var sshclient *tunnel.Conn
+	if conf.Ssh {
+		sshclient, err = tunnel.Open(conf, sshhost)
+		if err != nil {
+			utils.Fatal(err)
+		}
+		// jsonrpc and notifications go through ssh too, only the ssh port
+		// of Kodi needs to be reachable
+		utils.Kodi.HTTP = tunnel.HTTPClient(sshclient)
+		utils.Kodi.Dial = tunnel.LoopbackDialer(sshclient)
+	}
+
+	// note: method is mostly Player.Open, via kodi.Client.PlayerOpen
 	// in xbmc/xbmc/interfaces/json-rpc/JSONServiceDescription.cpp:  { "Player.Open", CPlayerOperations::Open },
 	// xbmc-master/xbmc/interfaces/json-rpc/PlayerOperations.cpp:JSONRPC_STATUS CPlayerOperations::Open(const std::string &method, ITransportLayer *transport, IClient *client, const CVariant &parameterObject, CVariant &result)
     //    "id":1,"jsonrpc":"2.0",
@@ -151,44 +243,60 @@ func main() {
 	if *verbose{
 		log.Println("Checking if XMBC/Kodi is online, by asking it for it jsonrpc version")
 	}
-	resp, err := http.Post(utils.GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(
-		`{"id":1, "jsonrpc":"2.0","method":"JSONRPC.Version"}` ))
-	if *verbose{
-		log.Println("jsonrpc err: ", err, ", Response:")
-		log.Println("  Status           :", resp.Status           )
-		log.Println("  StatusCode       :", resp.StatusCode       )
-		log.Println("  Proto            :", resp.Proto            )
-		log.Println("  ProtoMajor       :", resp.ProtoMajor       )
-		log.Println("  ProtoMinor       :", resp.ProtoMinor       )
-		log.Println("  Header           :", resp.Header           )
-		//log.Println("  Body             :", resp.Body             )
-		log.Println("  ContentLength    :", resp.ContentLength    )
-		log.Println("  TransferEncoding :", resp.TransferEncoding )
-		log.Println("  Close            :", resp.Close            )
-		log.Println("  Uncompressed     :", resp.Uncompressed     )
-		log.Println("  Trailer          :", resp.Trailer          )
-		log.Println("  Request          :", resp.Request          )
-		log.Println("  TLS              :", resp.TLS              )
-	}
-
-	if ( (err != nil) || (resp.StatusCode != http.StatusOK) ) { // http.StatusOK = 200
+	jsonrpcversion, err := utils.Kodi.JSONRPCVersion()
+	if err != nil {
+		if *verbose{
+			log.Println("jsonrpc err: ", err)
+		}
 		fmt.Println("\nSorrie me lad, old Koddie cannot be reached.")
 		fmt.Println("Probably best to exit now, ei?\n")
 		os.Exit(2)
 	} else {
 		if *verbose{
+			log.Println("Jsonrpc version: ", jsonrpcversion)
+		}
+	}
+
+	// remote control commands: idok [options] [ctl] command [args]
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] == "ctl" {
+			os.Exit(ctl.Run(utils.Kodi, args[1:]))
+		}
+		// a local file with the same name as a command is served
+		if _, err := os.Stat(args[0]); os.IsNotExist(err) && ctl.IsCommand(args[0]) {
+			os.Exit(ctl.Run(utils.Kodi, args))
+		}
+	}
+
+	var entries []utils.PlaylistEntry
 
-			bodyBytes, err := ioutil.ReadAll(resp.Body)
+	// convert the file with ffmpeg, its output is streamed as stdin
+	if *transcodeprofile != "" {
+		if *stdin || flag.NArg() != 1 {
+			fmt.Println("\033[33mYou must provide one local file to transcode\033[0m")
+			flag.Usage()
+			os.Exit(2)
+		}
+		transcode.SetFFmpeg(conf.Ffmpeg)
+		utils.AtExit(transcode.StopAll)
+		var seekable *transcode.Seekable
+		if *transcode_seek {
+			if seekable, err = transcode.NewSeekable(*transcodeprofile, flag.Arg(0)); err != nil {
+				log.Println(err, "- the stream won't be seekable")
+			}
+		}
+		if seekable != nil {
+			asserver.SetStdinSeekable(seekable)
+		} else {
+			transcoder, err := transcode.Start(*transcodeprofile, flag.Arg(0))
 			if err != nil {
-				log.Fatal(err)
+				utils.Fatal(err)
 			}
-			bodyString := string(bodyBytes)
-			log.Println("Jsonrpc version: ", bodyString)
+			asserver.SetStdinSource(transcoder)
 		}
+		*stdin = true
 	}
 
-	var dir, file string
-
 	// we don't use stdin, so we should check if scheme is file, youtube or other...
 	if !*stdin {
 		if len(flag.Args()) < 1 {
@@ -233,42 +341,50 @@ func main() {
 			os.Exit(0)
 		}
 
-		// find the good path
-		toserve := flag.Arg(0)
-		dir = "."
-		toserve, _ = filepath.Abs(toserve)
-		file = filepath.Base(toserve)
-		dir = filepath.Dir(toserve)
+		// find the files to serve, directories and playlists are expanded
+		entries, err = utils.CollectEntries(flag.Args(), *recursive)
+		if err != nil {
+			utils.Fatal(err)
+		}
+		utils.SetPlaylistOptions(*shuffle, *repeat)
 
 	}
 
-	if conf.Ssh && !*nossh {
-		config := tunnel.NewConfig(*sshuser, *sshpassword)
+	// keyboard remote control while streaming
+	if *interactive {
+		restore, err := ctl.Interactive(utils.Kodi, func() { utils.Exit(0) })
+		if err != nil {
+			utils.Fatal(err)
+		}
+		utils.AtExit(restore)
+	}
+
+	if conf.Ssh {
 		// serve ssh tunnel !
 		if !*stdin {
 			if *verbose{
-				log.Println("Running SshHTTPForward", config, file, dir)
+				log.Println("Running SshHTTPForward", sshhost.Address(), entries)
 			}
-			tunnel.SshHTTPForward(config, file, dir)
+			tunnel.SshHTTPForward(sshclient, entries)
 		} else {
 			if *verbose{
-				log.Println("Running SshForwardStdin", config)
+				log.Println("Running SshForwardStdin", sshhost.Address())
 			}
-			tunnel.SshForwardStdin(config)
+			tunnel.SshForwardStdin(sshclient, *stdin_outnm)
 		}
 	} else {
 		// serve local port !
 		if !*stdin {
 			if *verbose{
-				log.Println("Running HttpServe", file, dir, *port)
+				log.Println("Running HttpServe", entries, conf.Localport)
 			}
-			asserver.HttpServe(file, dir, *port)
+			asserver.HttpServe(entries, conf.Localport)
 		} else {
 			if *verbose{
-				log.Println("Running TCPServeStdin", *port)
+				log.Println("Running TCPServeStdin", conf.Localport)
 			}
-			//asserver.TCPServeStdin(*port)
-			asserver.HTTPServeStdin(*port, *stdin_outnm)
+			//asserver.TCPServeStdin(conf.Localport)
+			asserver.HTTPServeStdin(conf.Localport, *stdin_outnm)
 		}
 	}
 }
@@ -0,0 +1,33 @@
+package kodi
+
+// Properties returned by Application.GetProperties, only asked ones are set.
+type ApplicationProperties struct {
+	Volume  int    `json:"volume"`
+	Muted   bool   `json:"muted"`
+	Name    string `json:"name"`
+	Version struct {
+		Major int `json:"major"`
+		Minor int `json:"minor"`
+	} `json:"version"`
+}
+
+// Application.GetProperties
+func (c *Client) ApplicationGetProperties(properties ...string) (*ApplicationProperties, error) {
+	props := &ApplicationProperties{}
+	err := c.Call("Application.GetProperties", map[string]interface{}{"properties": properties}, props)
+	return props, err
+}
+
+// Application.SetVolume, volume is 0 to 100. Returns the new volume.
+func (c *Client) ApplicationSetVolume(volume int) (int, error) {
+	var newvolume int
+	err := c.Call("Application.SetVolume", map[string]interface{}{"volume": volume}, &newvolume)
+	return newvolume, err
+}
+
+// Application.SetMute, mute is true, false or "toggle". Returns the new state.
+func (c *Client) ApplicationSetMute(mute interface{}) (bool, error) {
+	var muted bool
+	err := c.Call("Application.SetMute", map[string]interface{}{"mute": mute}, &muted)
+	return muted, err
+}
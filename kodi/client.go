@@ -0,0 +1,144 @@
+package kodi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+var verbose = false
+
+func SetVerbose(inbool bool) {
+	verbose = inbool
+	if verbose {
+		log.Println(" kodi verbose: ", verbose)
+	}
+}
+
+// Client sends JSON-RPC requests to Kodi/XBMC over HTTP.
+type Client struct {
+	// url to jsonrpc, eg. http://192.168.0.10:80/jsonrpc
+	Url string
+
+	// Kodi username and password (configured in Kodi settings)
+	User     string
+	Password string
+
+	// HTTP client used to post requests, http.DefaultClient if nil
+	HTTP *http.Client
+
+	// dials the notification connection (see Listen), net.Dial with a
+	// timeout if nil
+	Dial func(network, addr string) (net.Conn, error)
+
+	// last used request id
+	lastid int64
+}
+
+// JSON-RPC error object returned by Kodi.
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("kodi: %s (code %d): %s", e.Message, e.Code, e.Data)
+	}
+	return fmt.Sprintf("kodi: %s (code %d)", e.Message, e.Code)
+}
+
+type request struct {
+	Jsonrpc string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	Id      int64       `json:"id"`
+}
+
+type response struct {
+	Id      int64           `json:"id"`
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+}
+
+// NewClient returns a client for the Kodi jsonrpc server on host:port.
+func NewClient(host string, port int, user, password string) *Client {
+	return &Client{
+		Url:      fmt.Sprintf("http://%s:%d/jsonrpc", host, port),
+		User:     user,
+		Password: password,
+	}
+}
+
+// Call sends method with params to Kodi and decodes the result in result.
+// params and result may be nil. A JSON-RPC error is returned as *Error.
+func (c *Client) Call(method string, params, result interface{}) error {
+	req := request{
+		Jsonrpc: "2.0",
+		Method:  method,
+		Params:  params,
+		Id:      atomic.AddInt64(&c.lastid, 1),
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		log.Println(" kodi request: ", string(body))
+	}
+
+	httpreq, err := http.NewRequest("POST", c.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpreq.Header.Set("Content-Type", "application/json")
+	if c.User != "" {
+		httpreq.SetBasicAuth(c.User, c.Password)
+	}
+
+	httpclient := c.HTTP
+	if httpclient == nil {
+		httpclient = http.DefaultClient
+	}
+	r, err := httpclient.Do(httpreq)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		log.Println(" kodi response: ", string(content))
+	}
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("kodi: %s returned HTTP status %s", method, r.Status)
+	}
+
+	resp := response{}
+	if err := json.Unmarshal(content, &resp); err != nil {
+		return fmt.Errorf("kodi: bad response to %s: %v", method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if resp.Id != req.Id {
+		return fmt.Errorf("kodi: response id %d doesn't match request id %d", resp.Id, req.Id)
+	}
+	if result == nil {
+		return nil
+	}
+	if len(resp.Result) == 0 {
+		return errors.New("kodi: empty result for " + method)
+	}
+	return json.Unmarshal(resp.Result, result)
+}
@@ -0,0 +1,143 @@
+package kodi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeKodi answers each request with answer, called with the decoded
+// request, and records the requests
+type fakeKodi struct {
+	answer   func(req request) string
+	requests []*http.Request
+}
+
+func (k *fakeKodi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.requests = append(k.requests, r)
+	req := request{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, k.answer(req))
+}
+
+// client returns a client of a fake Kodi server, closed by the returned
+// function
+func client(k *fakeKodi, user, password string) (*Client, func()) {
+	server := httptest.NewServer(k)
+	addr := strings.TrimPrefix(server.URL, "http://")
+	i := strings.LastIndex(addr, ":")
+	port, _ := strconv.Atoi(addr[i+1:])
+	return NewClient(addr[:i], port, user, password), server.Close
+}
+
+func TestCall(t *testing.T) {
+	k := &fakeKodi{answer: func(req request) string {
+		if req.Method != "JSONRPC.Version" || req.Jsonrpc != "2.0" {
+			return fmt.Sprintf(`{"id":%d,"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found."}}`, req.Id)
+		}
+		return fmt.Sprintf(`{"id":%d,"jsonrpc":"2.0","result":{"version":{"major":12,"minor":4,"patch":0}}}`, req.Id)
+	}}
+	c, stop := client(k, "", "")
+	defer stop()
+
+	result := struct {
+		Version struct{ Major, Minor int }
+	}{}
+	if err := c.Call("JSONRPC.Version", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Version.Major != 12 || result.Version.Minor != 4 {
+		t.Errorf("got version %+v", result.Version)
+	}
+	// no result wanted
+	if err := c.Call("JSONRPC.Version", nil, nil); err != nil {
+		t.Error(err)
+	}
+	if ct := k.requests[0].Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type %q", ct)
+	}
+	if _, _, ok := k.requests[0].BasicAuth(); ok {
+		t.Error("basic auth sent without user")
+	}
+}
+
+func TestCallBasicAuth(t *testing.T) {
+	k := &fakeKodi{answer: func(req request) string {
+		return fmt.Sprintf(`{"id":%d,"jsonrpc":"2.0","result":"pong"}`, req.Id)
+	}}
+	c, stop := client(k, "kodi", "s3cret:pass")
+	defer stop()
+
+	if err := c.JSONRPCPing(); err != nil {
+		t.Fatal(err)
+	}
+	user, password, ok := k.requests[0].BasicAuth()
+	if !ok || user != "kodi" || password != "s3cret:pass" {
+		t.Errorf("basic auth %q %q %v", user, password, ok)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(req request) string
+		want   string
+	}{
+		{"id mismatch", func(req request) string {
+			return fmt.Sprintf(`{"id":%d,"jsonrpc":"2.0","result":"pong"}`, req.Id+1)
+		}, "doesn't match request id"},
+		{"bad json", func(req request) string {
+			return `{"id":`
+		}, "bad response"},
+		{"empty result", func(req request) string {
+			return fmt.Sprintf(`{"id":%d,"jsonrpc":"2.0"}`, req.Id)
+		}, "empty result"},
+	}
+	for _, test := range tests {
+		c, stop := client(&fakeKodi{answer: test.answer}, "", "")
+		var result string
+		err := c.Call("JSONRPC.Ping", nil, &result)
+		stop()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+
+	// HTTP error, eg. wrong credentials
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	c := &Client{Url: server.URL + "/jsonrpc"}
+	if err := c.JSONRPCPing(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("HTTP error: got %v", err)
+	}
+}
+
+func TestCallKodiError(t *testing.T) {
+	k := &fakeKodi{answer: func(req request) string {
+		return fmt.Sprintf(`{"id":%d,"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params.","data":{"method":"Player.Open"}}}`, req.Id)
+	}}
+	c, stop := client(k, "", "")
+	defer stop()
+
+	err := c.Call("Player.Open", map[string]interface{}{"item": nil}, nil)
+	kerr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("got %T %v, want *Error", err, err)
+	}
+	if kerr.Code != -32602 || kerr.Message != "Invalid params." || string(kerr.Data) != `{"method":"Player.Open"}` {
+		t.Errorf("got %+v", kerr)
+	}
+	if !strings.Contains(kerr.Error(), "Invalid params. (code -32602)") {
+		t.Errorf("message %q", kerr.Error())
+	}
+}
@@ -0,0 +1,6 @@
+// Kodi package is a small JSON-RPC client for Kodi/XBMC.
+//
+// Each Kodi namespace used by idok (Player, Playlist, Application, GUI and
+// JSONRPC) has typed methods on Client, named after the JSON-RPC method
+// they call (e.g. PlayerOpen for "Player.Open").
+package kodi
@@ -0,0 +1,15 @@
+package kodi
+
+// GUI.ShowNotification, displaytime is in milliseconds (0 for Kodi default).
+func (c *Client) GUIShowNotification(title, message string, displaytime int) error {
+	params := map[string]interface{}{"title": title, "message": message}
+	if displaytime > 0 {
+		params["displaytime"] = displaytime
+	}
+	return c.Call("GUI.ShowNotification", params, nil)
+}
+
+// GUI.ActivateWindow
+func (c *Client) GUIActivateWindow(window string) error {
+	return c.Call("GUI.ActivateWindow", map[string]interface{}{"window": window}, nil)
+}
@@ -0,0 +1,28 @@
+package kodi
+
+import "fmt"
+
+// JSON-RPC API version returned by JSONRPC.Version.
+type Version struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// JSONRPC.Version
+func (c *Client) JSONRPCVersion() (*Version, error) {
+	result := struct {
+		Version Version `json:"version"`
+	}{}
+	err := c.Call("JSONRPC.Version", nil, &result)
+	return &result.Version, err
+}
+
+// JSONRPC.Ping
+func (c *Client) JSONRPCPing() error {
+	return c.Call("JSONRPC.Ping", nil, nil)
+}
@@ -0,0 +1,86 @@
+package kodi
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Notification pushed by Kodi on its TCP jsonrpc port (eg. Player.OnStop).
+type Notification struct {
+	Method string `json:"method"`
+	Params struct {
+		Sender string          `json:"sender"`
+		Data   json.RawMessage `json:"data"`
+	} `json:"params"`
+}
+
+// Listener receives Kodi notifications. C is closed when the connection
+// is lost or when Close is called.
+type Listener struct {
+	C    <-chan Notification
+	conn net.Conn
+	done chan bool
+}
+
+// Listen connects to the Kodi TCP jsonrpc port (9090 by default) on the
+// client host and starts to read notifications.
+func (c *Client) Listen(port int) (*Listener, error) {
+	u, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	dial := c.Dial
+	if dial == nil {
+		dial = func(network, addr string) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 5*time.Second)
+		}
+	}
+	conn, err := dial("tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	notifications := make(chan Notification)
+	l := &Listener{C: notifications, conn: conn, done: make(chan bool)}
+	go func() {
+		defer close(notifications)
+		decoder := json.NewDecoder(conn)
+		for {
+			n := Notification{}
+			if err := decoder.Decode(&n); err != nil {
+				if verbose {
+					log.Println(" kodi listener: ", err)
+				}
+				return
+			}
+			// responses to requests have no method
+			if n.Method == "" {
+				continue
+			}
+			if verbose {
+				log.Println(" kodi notification: ", n.Method, string(n.Params.Data))
+			}
+			select {
+			case notifications <- n:
+			case <-l.done:
+				return
+			}
+		}
+	}()
+
+	return l, nil
+}
+
+// Close the notification connection.
+func (l *Listener) Close() error {
+	close(l.done)
+	return l.conn.Close()
+}
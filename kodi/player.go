@@ -0,0 +1,213 @@
+package kodi
+
+import (
+	"fmt"
+	"time"
+)
+
+// Item is a media to open or to add in a playlist.
+type Item struct {
+	// file path or url (http://, plugin://...)
+	File string `json:"file,omitempty"`
+
+	// playlist to open and position to start from (Player.Open only)
+	PlaylistId *int `json:"playlistid,omitempty"`
+	Position   *int `json:"position,omitempty"`
+}
+
+// PlaylistItem returns an Item that opens the playlist id from position.
+func PlaylistItem(id, position int) Item {
+	return Item{PlaylistId: &id, Position: &position}
+}
+
+// Player as returned by Player.GetActivePlayers.
+type ActivePlayer struct {
+	PlayerId int    `json:"playerid"`
+	Type     string `json:"type"`
+}
+
+// Global.Time type.
+type Time struct {
+	Hours        int `json:"hours"`
+	Minutes      int `json:"minutes"`
+	Seconds      int `json:"seconds"`
+	Milliseconds int `json:"milliseconds"`
+}
+
+// NewTime converts a duration to Kodi time.
+func NewTime(d time.Duration) Time {
+	return Time{
+		Hours:        int(d / time.Hour),
+		Minutes:      int(d % time.Hour / time.Minute),
+		Seconds:      int(d % time.Minute / time.Second),
+		Milliseconds: int(d % time.Second / time.Millisecond),
+	}
+}
+
+// Duration converts Kodi time to duration.
+func (t Time) Duration() time.Duration {
+	return time.Duration(t.Hours)*time.Hour +
+		time.Duration(t.Minutes)*time.Minute +
+		time.Duration(t.Seconds)*time.Second +
+		time.Duration(t.Milliseconds)*time.Millisecond
+}
+
+func (t Time) String() string {
+	return fmt.Sprintf("%02d:%02d:%02d", t.Hours, t.Minutes, t.Seconds)
+}
+
+// Audio or subtitle stream of the played media.
+type Stream struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name"`
+	Language string `json:"language"`
+}
+
+func (s Stream) String() string {
+	switch {
+	case s.Name != "" && s.Language != "":
+		return s.Name + " (" + s.Language + ")"
+	case s.Name != "":
+		return s.Name
+	case s.Language != "":
+		return s.Language
+	}
+	return fmt.Sprintf("#%d", s.Index)
+}
+
+// Properties returned by Player.GetProperties, only asked ones are set.
+type PlayerProperties struct {
+	Speed              int     `json:"speed"`
+	Time               Time    `json:"time"`
+	TotalTime          Time    `json:"totaltime"`
+	Percentage         float64 `json:"percentage"`
+	PlaylistId         int     `json:"playlistid"`
+	Position           int     `json:"position"`
+	Repeat             string  `json:"repeat"`
+	Shuffled           bool    `json:"shuffled"`
+	SubtitleEnabled    bool    `json:"subtitleenabled"`
+	CurrentSubtitle    *Stream `json:"currentsubtitle"`
+	CurrentAudioStream *Stream `json:"currentaudiostream"`
+}
+
+// Options of Player.Open.
+type OpenOptions struct {
+	Shuffled bool `json:"shuffled"`
+	// "off", "one" or "all"
+	Repeat string `json:"repeat,omitempty"`
+}
+
+// Player.Open
+func (c *Client) PlayerOpen(item Item) error {
+	return c.Call("Player.Open", map[string]interface{}{"item": item}, nil)
+}
+
+// Player.Open with shuffle and repeat options (for playlists).
+func (c *Client) PlayerOpenWithOptions(item Item, options OpenOptions) error {
+	return c.Call("Player.Open", map[string]interface{}{
+		"item":    item,
+		"options": options,
+	}, nil)
+}
+
+// Player.GetActivePlayers
+func (c *Client) PlayerGetActivePlayers() ([]ActivePlayer, error) {
+	players := []ActivePlayer{}
+	err := c.Call("Player.GetActivePlayers", nil, &players)
+	return players, err
+}
+
+// Player.Stop
+func (c *Client) PlayerStop(playerid int) error {
+	return c.Call("Player.Stop", map[string]interface{}{"playerid": playerid}, nil)
+}
+
+// Player.PlayPause, play is true, false or "toggle". Returns the new
+// speed (0 is paused).
+func (c *Client) PlayerPlayPause(playerid int, play interface{}) (int, error) {
+	speed := struct {
+		Speed int `json:"speed"`
+	}{}
+	err := c.Call("Player.PlayPause", map[string]interface{}{
+		"playerid": playerid,
+		"play":     play,
+	}, &speed)
+	return speed.Speed, err
+}
+
+// Player.GetItem, returns the item currently played.
+func (c *Client) PlayerGetItem(playerid int) (*ListItem, error) {
+	result := struct {
+		Item ListItem `json:"item"`
+	}{}
+	err := c.Call("Player.GetItem", map[string]interface{}{
+		"playerid":   playerid,
+		"properties": []string{"title", "file"},
+	}, &result)
+	return &result.Item, err
+}
+
+// Player.GetProperties
+func (c *Client) PlayerGetProperties(playerid int, properties ...string) (*PlayerProperties, error) {
+	props := &PlayerProperties{}
+	err := c.Call("Player.GetProperties", map[string]interface{}{
+		"playerid":   playerid,
+		"properties": properties,
+	}, props)
+	return props, err
+}
+
+// Player.Seek to an absolute time.
+func (c *Client) PlayerSeekTime(playerid int, t Time) error {
+	return c.Call("Player.Seek", map[string]interface{}{
+		"playerid": playerid,
+		"value":    map[string]interface{}{"time": t},
+	}, nil)
+}
+
+// Player.Seek forward (or backward if seconds is negative).
+func (c *Client) PlayerSeekRelative(playerid int, seconds int) error {
+	return c.Call("Player.Seek", map[string]interface{}{
+		"playerid": playerid,
+		"value":    map[string]interface{}{"seconds": seconds},
+	}, nil)
+}
+
+// Player.GoTo, to is "next", "previous" or a playlist position.
+func (c *Client) PlayerGoTo(playerid int, to interface{}) error {
+	return c.Call("Player.GoTo", map[string]interface{}{"playerid": playerid, "to": to}, nil)
+}
+
+// Player.SetSubtitle, subtitle is "next", "previous", "off", "on" or a
+// stream index.
+func (c *Client) PlayerSetSubtitle(playerid int, subtitle interface{}, enable bool) error {
+	return c.Call("Player.SetSubtitle", map[string]interface{}{
+		"playerid": playerid,
+		"subtitle": subtitle,
+		"enable":   enable,
+	}, nil)
+}
+
+// Player.SetAudioStream, stream is "next", "previous" or a stream index.
+func (c *Client) PlayerSetAudioStream(playerid int, stream interface{}) error {
+	return c.Call("Player.SetAudioStream", map[string]interface{}{
+		"playerid": playerid,
+		"stream":   stream,
+	}, nil)
+}
+
+// Player.SetShuffle
+func (c *Client) PlayerSetShuffle(playerid int, shuffle bool) error {
+	return c.Call("Player.SetShuffle", map[string]interface{}{
+		"playerid": playerid,
+		"shuffle":  shuffle,
+	}, nil)
+}
+
+// Player.SetRepeat, repeat is "off", "one" or "all".
+func (c *Client) PlayerSetRepeat(playerid int, repeat string) error {
+	return c.Call("Player.SetRepeat", map[string]interface{}{
+		"playerid": playerid,
+		"repeat":   repeat,
+	}, nil)
+}
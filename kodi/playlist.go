@@ -0,0 +1,41 @@
+package kodi
+
+// Playlist ids.
+const (
+	PLAYLIST_MUSIC   = 0
+	PLAYLIST_VIDEO   = 1
+	PLAYLIST_PICTURE = 2
+)
+
+// Item returned by Playlist.GetItems and Player.GetItem.
+type ListItem struct {
+	Label string `json:"label"`
+	Title string `json:"title"`
+	File  string `json:"file"`
+	Type  string `json:"type"`
+}
+
+// Playlist.Add
+func (c *Client) PlaylistAdd(playlistid int, item Item) error {
+	return c.Call("Playlist.Add", map[string]interface{}{
+		"playlistid": playlistid,
+		"item":       item,
+	}, nil)
+}
+
+// Playlist.Clear
+func (c *Client) PlaylistClear(playlistid int) error {
+	return c.Call("Playlist.Clear", map[string]interface{}{"playlistid": playlistid}, nil)
+}
+
+// Playlist.GetItems
+func (c *Client) PlaylistGetItems(playlistid int) ([]ListItem, error) {
+	result := struct {
+		Items []ListItem `json:"items"`
+	}{}
+	err := c.Call("Playlist.GetItems", map[string]interface{}{
+		"playlistid": playlistid,
+		"properties": []string{"file"},
+	}, &result)
+	return result.Items, err
+}
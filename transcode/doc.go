@@ -0,0 +1,9 @@
+// Transcode package runs ffmpeg to convert media files that Kodi/XBMC
+// can't play into a stream that idok serves as it serves stdin.
+//
+// A profile (see Profiles) gives the ffmpeg options: remux only, encode to
+// H.264 and AAC, or keep the audio only.
+//
+// A Seekable stream lets Kodi seek: ffmpeg is started again at the time
+// that matches the byte offset Kodi asks for.
+package transcode
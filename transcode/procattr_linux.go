@@ -0,0 +1,12 @@
+package transcode
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setParentDeath has ffmpeg killed if idok dies without StopAll (SIGKILL,
+// crash)
+func setParentDeath(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package transcode
+
+import "os/exec"
+
+// setParentDeath does nothing, only Linux can kill ffmpeg with its parent
+func setParentDeath(cmd *exec.Cmd) {}
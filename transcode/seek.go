@@ -0,0 +1,179 @@
+package transcode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// format of the seekable streams: Kodi finds its way in MPEG-TS from any
+// byte, and seeks with its timestamps
+const SEEK_FORMAT = "mpegts"
+
+// MPEG-TS null packet, the padding of the streams that end before their
+// size
+var nullPacket = append([]byte{0x47, 0x1f, 0xff, 0x10}, bytes.Repeat([]byte{0xff}, 184)...)
+
+var durationLine = regexp.MustCompile(`Duration: (\d+):(\d\d):(\d\d(?:\.\d+)?)`)
+
+// Duration returns the duration of the media file, given by ffmpeg.
+func Duration(file string) (time.Duration, error) {
+	// ffmpeg complains that there is no output, after printing the input
+	output, _ := exec.Command(ffmpeg, "-nostdin", "-hide_banner", "-i", file).CombinedOutput()
+	m := durationLine.FindSubmatch(output)
+	if m == nil {
+		return 0, fmt.Errorf("unknown duration of %s", file)
+	}
+	hours, _ := strconv.Atoi(string(m[1]))
+	minutes, _ := strconv.Atoi(string(m[2]))
+	seconds, _ := strconv.ParseFloat(string(m[3]), 64)
+	d := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second))
+	if d <= 0 {
+		return 0, fmt.Errorf("unknown duration of %s", file)
+	}
+	return d, nil
+}
+
+// Seekable is a file converted from any time. It's seen as a stream of
+// Size bytes whose offsets are mapped to times linearly: Open(offset)
+// runs ffmpeg from the matching time. The timestamps of the file are kept,
+// so that Kodi finds the time it seeks to whatever the offset it reads.
+// Size is an upper bound estimated from the profile ByteRate, the output
+// of ffmpeg is padded with null packets up to it (cut if it's longer).
+type Seekable struct {
+	file     string
+	profile  Profile
+	duration time.Duration
+	size     int64
+}
+
+// NewSeekable prepares the conversion of file with profile, ffmpeg runs
+// when the stream is opened.
+func NewSeekable(profile, file string) (*Seekable, error) {
+	p, err := findProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := Duration(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := probe(file, p); err != nil {
+		return nil, err
+	}
+
+	rate := p.ByteRate
+	if rate == 0 {
+		rate = int64(float64(stat.Size()) / duration.Seconds() * 2)
+	}
+	s := &Seekable{file: file, profile: p, duration: duration, size: int64(duration.Seconds() * float64(rate))}
+	log.Printf("Transcoding %s (%s), seekable, %v", file, profile, duration.Truncate(time.Second))
+	return s, nil
+}
+
+// probe converts the first second of file to SEEK_FORMAT, it fails if a
+// codec of the profile can't be put in it (VP9 with copy-remux...)
+func probe(file string, p Profile) error {
+	options := append(append([]string{}, p.Args...), "-t", "1")
+	t, err := start(file, nil, options, SEEK_FORMAT)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, t); err != nil {
+		return fmt.Errorf("%s can't be converted to %s: %v", file, SEEK_FORMAT, err)
+	}
+	return nil
+}
+
+// Size returns the length of the stream.
+func (s *Seekable) Size() int64 {
+	return s.size
+}
+
+// Time returns the time of file at the offset of the stream.
+func (s *Seekable) Time(offset int64) time.Duration {
+	return time.Duration(float64(s.duration) * float64(offset) / float64(s.size))
+}
+
+// Open runs ffmpeg from the time of offset, the reader gives the Size-offset
+// bytes left in the stream.
+func (s *Seekable) Open(offset int64) (io.ReadCloser, error) {
+	at := s.Time(offset)
+	input := []string{"-copyts"}
+	if at > 0 {
+		input = append([]string{"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64)}, input...)
+		log.Printf("Transcoding from %v", at.Truncate(time.Second))
+	}
+	t, err := start(s.file, input, s.profile.Args, SEEK_FORMAT)
+	if err != nil {
+		return nil, err
+	}
+	return &sizedReader{Transcoder: t, remaining: s.size - offset}, nil
+}
+
+// sizedReader reads remaining bytes of a transcoder: null packets follow
+// the output of ffmpeg if it ends before, ffmpeg is stopped if it gives
+// more.
+type sizedReader struct {
+	*Transcoder
+	remaining int64
+	// ffmpeg has ended, pad is the offset in the null packet
+	ended bool
+	pad   int
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		if !r.ended {
+			r.ended = true
+			if n, _ := r.Transcoder.Read(make([]byte, 1)); n > 0 {
+				log.Println("Transcoded stream longer than its estimated size, its end is cut")
+			}
+			r.Transcoder.Stop()
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	if !r.ended {
+		n, err := r.Transcoder.Read(p)
+		r.remaining -= int64(n)
+		if err != io.EOF {
+			return n, err
+		}
+		// stopped, not at its end
+		select {
+		case <-r.Transcoder.stopped:
+			return n, io.EOF
+		default:
+		}
+		if verbose {
+			log.Printf("ffmpeg ended %d bytes before the estimated size, padding", r.remaining)
+		}
+		r.ended = true
+		return n, nil
+	}
+
+	// ffmpeg writes whole packets, the padding is aligned
+	n := 0
+	for n < len(p) {
+		c := copy(p[n:], nullPacket[r.pad:])
+		n += c
+		r.pad = (r.pad + c) % len(nullPacket)
+	}
+	r.remaining -= int64(n)
+	return n, nil
+}
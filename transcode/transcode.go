@@ -0,0 +1,223 @@
+package transcode
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var verbose = false
+
+func SetVerbose(inbool bool) {
+	verbose = inbool
+	if verbose {
+		log.Println(" transcode verbose: ", verbose)
+	}
+}
+
+// ffmpeg executable, name looked for in $PATH or path (-ffmpeg)
+var ffmpeg = "ffmpeg"
+
+func SetFFmpeg(path string) {
+	ffmpeg = path
+	if verbose {
+		log.Println(" transcode ffmpeg: ", ffmpeg)
+	}
+}
+
+// Profile is a set of ffmpeg output options.
+type Profile struct {
+	Description string
+	Args        []string
+
+	// output format of a stream read once, it can be written to a pipe
+	// and Kodi plays it while it's received
+	Format string
+
+	// bytes per second given to a seekable stream (see Seekable), an
+	// upper bound of the output rate with the MPEG-TS overhead. 0 for
+	// twice the rate of the source file.
+	ByteRate int64
+}
+
+// Profiles are the -transcode presets, by name.
+var Profiles = map[string]Profile{
+	"copy-remux": {
+		"keep video and audio as is, change the container",
+		[]string{"-map", "0:v?", "-map", "0:a?", "-c", "copy"},
+		"matroska", 0,
+	},
+	"h264-aac": {
+		"encode video to H.264 (8 Mb/s at most) and audio to stereo AAC",
+		[]string{"-map", "0:v:0?", "-map", "0:a?", "-c:v", "libx264", "-preset", "veryfast", "-crf", "22",
+			"-maxrate", "8M", "-bufsize", "16M", "-pix_fmt", "yuv420p", "-c:a", "aac", "-ac", "2", "-b:a", "192k"},
+		"matroska", (8000000 + 192000) / 8 * 5 / 4,
+	},
+	"audio-only": {
+		"drop the video, encode the first audio track to stereo AAC",
+		[]string{"-map", "0:a:0", "-vn", "-c:a", "aac", "-ac", "2", "-b:a", "192k"},
+		"adts", 192000 / 8 * 3 / 2,
+	},
+}
+
+// findProfile returns the profile called name
+func findProfile(name string) (Profile, error) {
+	p, ok := Profiles[name]
+	if !ok {
+		return p, fmt.Errorf("unknown transcoding profile %q (%s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return p, nil
+}
+
+// ProfileNames returns the names of the profiles, sorted.
+func ProfileNames() []string {
+	names := []string{}
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// running transcoders, for StopAll
+var running = struct {
+	sync.Mutex
+	transcoders map[*Transcoder]bool
+}{transcoders: map[*Transcoder]bool{}}
+
+// Transcoder is a running ffmpeg, reading it gives the converted stream.
+type Transcoder struct {
+	cmd    *exec.Cmd
+	output io.ReadCloser
+
+	waitOnce sync.Once
+	// closed once ffmpeg has exited, err is then its exit status
+	done chan struct{}
+	err  error
+
+	stopOnce sync.Once
+	// closed by Stop
+	stopped chan struct{}
+}
+
+// Start runs ffmpeg to convert file with profile. Its messages go to
+// stderr, it doesn't read the terminal.
+func Start(profile, file string) (*Transcoder, error) {
+	p, err := findProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	t, err := start(file, nil, p.Args, p.Format)
+	if err == nil {
+		log.Printf("Transcoding %s (%s)", file, profile)
+	}
+	return t, err
+}
+
+// start runs ffmpeg on file with the input and output options
+func start(file string, input, options []string, format string) (*Transcoder, error) {
+	args := append([]string{"-nostdin", "-hide_banner", "-loglevel", "error"}, input...)
+	args = append(append(args, "-i", file), options...)
+	args = append(args, "-f", format, "-")
+	cmd := exec.Command(ffmpeg, args...)
+	cmd.Stderr = os.Stderr
+	setParentDeath(cmd)
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		log.Println("Running", ffmpeg, strings.Join(args, " "))
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to run ffmpeg: %v", err)
+	}
+
+	t := &Transcoder{cmd: cmd, output: output, done: make(chan struct{}), stopped: make(chan struct{})}
+	running.Lock()
+	running.transcoders[t] = true
+	running.Unlock()
+	return t, nil
+}
+
+// Read reads the output of ffmpeg. At its end, the error is io.EOF if
+// ffmpeg succeeded or was stopped, its exit status else.
+func (t *Transcoder) Read(p []byte) (int, error) {
+	n, err := t.output.Read(p)
+	select {
+	case <-t.stopped:
+		if err != nil {
+			return n, io.EOF
+		}
+	default:
+	}
+	if err == io.EOF {
+		if werr := t.Wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Wait waits for ffmpeg to exit and returns its exit status.
+func (t *Transcoder) Wait() error {
+	t.waitOnce.Do(func() {
+		go t.wait()
+	})
+	<-t.done
+	return t.err
+}
+
+func (t *Transcoder) wait() {
+	if err := t.cmd.Wait(); err != nil {
+		t.err = fmt.Errorf("ffmpeg: %v", err)
+	}
+	running.Lock()
+	delete(running.transcoders, t)
+	running.Unlock()
+	close(t.done)
+}
+
+// Stop kills ffmpeg if it still runs and waits for it.
+func (t *Transcoder) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stopped)
+	})
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	if verbose {
+		log.Println("Stopping ffmpeg")
+	}
+	t.cmd.Process.Kill()
+	t.Wait()
+}
+
+// Close stops ffmpeg, for a Transcoder to be an io.ReadCloser.
+func (t *Transcoder) Close() error {
+	t.Stop()
+	return nil
+}
+
+// StopAll stops the running transcoders, when idok exits.
+func StopAll() {
+	running.Lock()
+	transcoders := []*Transcoder{}
+	for t := range running.transcoders {
+		transcoders = append(transcoders, t)
+	}
+	running.Unlock()
+	for _, t := range transcoders {
+		t.Stop()
+	}
+}
@@ -0,0 +1,278 @@
+package transcode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary stands in for ffmpeg when TRANSCODE_STUB is set: "echo"
+// writes its arguments to stdout (or prints the input as "ffmpeg -i file"
+// does without output), "packets" writes $TRANSCODE_STUB_PACKETS MPEG-TS
+// packets, "fail" exits with 1, "nompegts" fails to write MPEG-TS as
+// ffmpeg with VP9 and echoes else, "hang" writes a few bytes and waits to
+// be killed.
+func TestMain(m *testing.M) {
+	mode := os.Getenv("TRANSCODE_STUB")
+	if (mode == "echo" || mode == "packets" || mode == "nompegts") && os.Args[len(os.Args)-1] != "-" {
+		fmt.Fprintln(os.Stderr, "  Duration: 00:10:00.50, start: 0.000000, bitrate: 800 kb/s")
+		fmt.Fprintln(os.Stderr, "At least one output file must be specified")
+		os.Exit(1)
+	}
+	switch mode {
+	case "":
+		os.Exit(m.Run())
+	case "nompegts":
+		if strings.HasSuffix(strings.Join(os.Args, " "), "-f mpegts -") {
+			fmt.Fprintln(os.Stderr, "stub: Could not find tag for codec vp9 in stream #0, codec not currently supported in container")
+			os.Exit(1)
+		}
+		fmt.Print(strings.Join(os.Args[1:], " "))
+	case "echo":
+		fmt.Print(strings.Join(os.Args[1:], " "))
+	case "packets":
+		n, _ := strconv.Atoi(os.Getenv("TRANSCODE_STUB_PACKETS"))
+		packet := append([]byte{0x47, 0x01, 0x00, 0x10}, bytes.Repeat([]byte{0xaa}, 184)...)
+		os.Stdout.Write(bytes.Repeat(packet, n))
+	case "fail":
+		fmt.Fprintln(os.Stderr, "stub: invalid data found when processing input")
+		os.Exit(1)
+	case "hang":
+		fmt.Print("header")
+		time.Sleep(time.Minute)
+	}
+	os.Exit(0)
+}
+
+func stub(t *testing.T, mode string) {
+	os.Setenv("TRANSCODE_STUB", mode)
+	SetFFmpeg(os.Args[0])
+}
+
+func TestStart(t *testing.T) {
+	stub(t, "echo")
+	defer os.Unsetenv("TRANSCODE_STUB")
+
+	tr, err := Start("h264-aac", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := string(output)
+	if !strings.HasPrefix(args, "-nostdin ") || !strings.Contains(args, "-i transcode_test.go") ||
+		!strings.Contains(args, "-c:v libx264") || !strings.HasSuffix(args, "-f matroska -") {
+		t.Errorf("ffmpeg arguments: %s", args)
+	}
+}
+
+func TestStartErrors(t *testing.T) {
+	stub(t, "echo")
+	defer os.Unsetenv("TRANSCODE_STUB")
+
+	if _, err := Start("vp9", "transcode_test.go"); err == nil || !strings.Contains(err.Error(), "copy-remux") {
+		t.Errorf("unknown profile: got %v", err)
+	}
+	if _, err := Start("copy-remux", "missing.avi"); err == nil {
+		t.Error("missing file accepted")
+	}
+	SetFFmpeg("/nonexistent/ffmpeg")
+	if _, err := Start("copy-remux", "transcode_test.go"); err == nil {
+		t.Error("missing ffmpeg accepted")
+	}
+}
+
+func TestFailure(t *testing.T) {
+	stub(t, "fail")
+	defer os.Unsetenv("TRANSCODE_STUB")
+
+	tr, err := Start("audio-only", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(tr); err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Errorf("got %v, want the exit status of ffmpeg", err)
+	}
+}
+
+func TestStop(t *testing.T) {
+	stub(t, "hang")
+	defer os.Unsetenv("TRANSCODE_STUB")
+
+	tr, err := Start("copy-remux", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 6)
+	if _, err := tr.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan bool)
+	go func() {
+		tr.Stop()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ffmpeg not killed")
+	}
+	if tr.Wait() == nil {
+		t.Error("killed ffmpeg exited successfully")
+	}
+	if _, err := tr.Read(data); err != io.EOF {
+		t.Errorf("read after Stop: got %v, want io.EOF", err)
+	}
+	// stopping again does nothing
+	tr.Stop()
+}
+
+func TestSeekable(t *testing.T) {
+	stub(t, "echo")
+	defer os.Unsetenv("TRANSCODE_STUB")
+
+	s, err := NewSeekable("h264-aac", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != int64(600.5*float64(Profiles["h264-aac"].ByteRate)) {
+		t.Errorf("size %d for 600.5s", s.Size())
+	}
+	for _, test := range []struct {
+		offset int64
+		args   string
+	}{
+		{0, "-loglevel error -copyts -i transcode_test.go"},
+		{s.Size() / 2, "-ss 300.250 -copyts -i transcode_test.go"},
+	} {
+		tr, err := s.Open(test.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the arguments, before the padding
+		output, _ := ioutil.ReadAll(io.LimitReader(tr, 1024))
+		tr.Close()
+		if args := string(output); !strings.Contains(args, test.args) || !strings.Contains(args, "-f mpegts -\x47") {
+			t.Errorf("offset %d: ffmpeg arguments %s", test.offset, args)
+		}
+	}
+
+	// twice the rate of the file
+	stat, _ := os.Stat("transcode_test.go")
+	s, err = NewSeekable("copy-remux", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := float64(s.Size()) / 600.5; rate < float64(stat.Size())/600.5*2-1 || rate > float64(stat.Size())/600.5*2+1 {
+		t.Errorf("copy-remux: %.1f bytes per second for a file of %d bytes", rate, stat.Size())
+	}
+
+	stub(t, "fail")
+	if _, err := NewSeekable("copy-remux", "transcode_test.go"); err == nil {
+		t.Error("file without duration accepted")
+	}
+
+	// idok then streams the file once, in the format of the profile
+	stub(t, "nompegts")
+	if _, err := NewSeekable("copy-remux", "transcode_test.go"); err == nil || !strings.Contains(err.Error(), "mpegts") {
+		t.Errorf("codec without MPEG-TS: got %v", err)
+	}
+	tr, err := Start("copy-remux", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := ioutil.ReadAll(tr)
+	if err != nil || !strings.HasSuffix(string(output), "-f matroska -") {
+		t.Errorf("not seekable: got %q, %v", output, err)
+	}
+}
+
+func TestSeekableSize(t *testing.T) {
+	stub(t, "packets")
+	defer os.Unsetenv("TRANSCODE_STUB")
+	defer os.Unsetenv("TRANSCODE_STUB_PACKETS")
+
+	// a packet per second
+	Profiles["test"] = Profile{"test", nil, "mpegts", 188}
+	defer delete(Profiles, "test")
+	s, err := NewSeekable("test", "transcode_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != 600*188+94 {
+		t.Fatalf("size %d for 600.5s", s.Size())
+	}
+
+	for _, test := range []struct {
+		name    string
+		packets int
+		offset  int64
+	}{
+		{"fewer bytes", 100, 0},
+		{"fewer bytes from the middle", 100, s.Size() / 2},
+		{"as many bytes", 601, 0},
+		{"more bytes", 1000, 0},
+		{"more bytes from the middle", 1000, s.Size() / 2},
+	} {
+		os.Setenv("TRANSCODE_STUB_PACKETS", strconv.Itoa(test.packets))
+		tr, err := s.Open(test.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output, err := ioutil.ReadAll(tr)
+		tr.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if int64(len(output)) != s.Size()-test.offset {
+			t.Errorf("%s: %d bytes, want %d", test.name, len(output), s.Size()-test.offset)
+			continue
+		}
+		for i := 0; i < len(output); i += 188 {
+			packet := output[i:]
+			if len(packet) > 188 {
+				packet = packet[:188]
+			}
+			want := nullPacket[:len(packet)]
+			if i < test.packets*188 {
+				want = append([]byte{0x47, 0x01, 0x00, 0x10}, bytes.Repeat([]byte{0xaa}, 184)...)[:len(packet)]
+			}
+			if !bytes.Equal(packet, want) {
+				t.Errorf("%s: bad packet at %d: % x", test.name, i, packet[:4])
+				break
+			}
+		}
+	}
+}
+
+func TestStopAll(t *testing.T) {
+	stub(t, "hang")
+	defer os.Unsetenv("TRANSCODE_STUB")
+
+	transcoders := []*Transcoder{}
+	for i := 0; i < 2; i++ {
+		tr, err := Start("copy-remux", "transcode_test.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		transcoders = append(transcoders, tr)
+	}
+	StopAll()
+	for _, tr := range transcoders {
+		select {
+		case <-tr.done:
+		default:
+			t.Error("ffmpeg still running")
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/agent"
+)
+
+// environment variable giving the ssh-agent socket
+const AGENT_SOCKET_ENV = "SSH_AUTH_SOCK"
+
+// AgentSigners returns the signers of the ssh-agent listening on
+// $SSH_AUTH_SOCK. If identity is not empty, only the keys it matches
+// are kept: identity is a key comment, a SHA256 fingerprint or a
+// public key file (the ".pub" file of a private key works too).
+func AgentSigners(identity string) ([]ssh.Signer, error) {
+	socket := os.Getenv(AGENT_SOCKET_ENV)
+	if socket == "" {
+		return nil, errors.New("no ssh-agent, " + AGENT_SOCKET_ENV + " is not set")
+	}
+
+	// the connection stays open, the agent signs on each authentication
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	client := agent.NewClient(conn)
+
+	keys, err := client.List()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	signers, err := client.Signers()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if identity == "" {
+		return signers, nil
+	}
+
+	wanted, err := identityKey(identity)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the comments are in the key list, signers are matched by public key
+	kept := []ssh.Signer{}
+	for _, k := range keys {
+		match := k.Comment == identity || Fingerprint(k) == identity
+		if wanted != nil {
+			match = bytes.Equal(k.Marshal(), wanted.Marshal())
+		}
+		if !match {
+			continue
+		}
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), k.Marshal()) {
+				if verbose {
+					log.Printf("Using agent identity %s (%s)", k.Comment, Fingerprint(k))
+				}
+				kept = append(kept, s)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		conn.Close()
+		return nil, fmt.Errorf("ssh-agent has no identity matching %q", identity)
+	}
+	return kept, nil
+}
+
+// identityKey reads the public key of identity if it's a file path, it
+// returns nil if identity isn't a file
+func identityKey(identity string) (ssh.PublicKey, error) {
+	file := identity
+	if !strings.HasSuffix(file, ".pub") {
+		if _, err := os.Stat(file + ".pub"); err == nil {
+			file += ".pub"
+		}
+	}
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return key, nil
+}
+
+// identitySigners keeps the signers of the identity files, the public key
+// is read from the ".pub" file next to the private key
+func identitySigners(signers []ssh.Signer, files []string) []ssh.Signer {
+	kept := []ssh.Signer{}
+	for _, file := range files {
+		key, err := identityKey(file)
+		if err != nil || key == nil {
+			continue
+		}
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+				kept = append(kept, s)
+			}
+		}
+	}
+	return kept
+}
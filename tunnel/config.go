@@ -2,43 +2,110 @@ package tunnel
 
 import (
 	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/utils"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
 )
 
-// NewConfig returns a ssh.Config pointer with 3 auth method if possible, rsa key pair,
-// dsa keypair and user/pass
-func NewConfig(username, password string) *ssh.ClientConfig {
+// default key pairs, in the order OpenSSH tries them
+var defaultIdentities = []struct {
+	name, file string
+}{
+	{"RSA", "id_rsa"},
+	{"ECDSA", "id_ecdsa"},
+	{"Ed25519", "id_ed25519"},
+	{"DSA", "id_dsa"},
+}
+
+// NewConfig returns a ssh.Config pointer with 3 auth method if possible: public keys
+// (ssh-agent keys first, then the IdentityFile keys of host and the default key
+// pairs), password and keyboard-interactive, with the host password if any or
+// else asked on the terminal. Host keys are checked against known_hosts with
+// the conf.Hostkeycheck mode
+func NewConfig(conf *utils.Config, host *Host) (*ssh.ClientConfig, error) {
 	u, _ := user.Current()
 	home := u.HomeDir
-	id_rsa_priv := filepath.Join(home, ".ssh", "id_rsa")
-	id_dsa_priv := filepath.Join(home, ".ssh", "id_dsa")
 
 	auth := []ssh.AuthMethod{}
 
-	// Try to parse keypair
-	if _, err := os.Stat(id_rsa_priv); err == nil {
-		if keypair, err := parseSSHKeys(id_rsa_priv); err == nil {
-			log.Println("Added RSA key")
-			auth = append(auth, ssh.PublicKeys(keypair))
+	// ssh only tries the first public key method, so every key goes in the same one
+	signers := []ssh.Signer{}
+
+	// ssh-agent keys
+	agentsigners, err := AgentSigners(conf.Agentidentity)
+	if err == nil {
+		if host.IdentitiesOnly && conf.Agentidentity == "" {
+			agentsigners = identitySigners(agentsigners, host.Identities())
 		}
+		log.Printf("Added %d ssh-agent key(s)", len(agentsigners))
+		signers = append(signers, agentsigners...)
+	} else if conf.Agentidentity != "" {
+		return nil, err
+	} else if verbose {
+		log.Println("Not using ssh-agent:", err)
 	}
-	if _, err := os.Stat(id_dsa_priv); err == nil {
-		if keypair, err := parseSSHKeys(id_dsa_priv); err == nil {
-			log.Println("Added DSA key")
-			auth = append(auth, ssh.PublicKeys(keypair))
+
+	// Try to parse keypair, unless the agent identity is forced
+	if conf.Agentidentity == "" {
+		for _, keyfile := range host.Identities() {
+			if _, err := os.Stat(keyfile); err != nil {
+				if verbose {
+					log.Println("Identity file", keyfile, "not found")
+				}
+				continue
+			}
+			if keypair, err := parseSSHKeys(keyfile, agentsigners); err == nil {
+				log.Println("Added key", keyfile)
+				signers = append(signers, keypair)
+			}
 		}
+		for _, id := range defaultIdentities {
+			keyfile := filepath.Join(home, ".ssh", id.file)
+			if host.IdentitiesOnly || contains(host.Identities(), keyfile) {
+				continue
+			}
+			if _, err := os.Stat(keyfile); err != nil {
+				continue
+			}
+			if keypair, err := parseSSHKeys(keyfile, agentsigners); err == nil {
+				log.Printf("Added %s key", id.name)
+				signers = append(signers, keypair)
+			}
+		}
+	}
+
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
 	}
 
-	// add password method
-	auth = append(auth, ssh.Password(password))
+	// add password and keyboard-interactive methods, the password is asked
+	// on the terminal if not given
+	if host.Password != "" || canPrompt() {
+		auth = append(auth, ssh.PasswordCallback(passwordPrompt(host)))
+		auth = append(auth, ssh.KeyboardInteractive(keyboardInteractive(host)))
+	}
+
+	knownhosts, err := LoadKnownHosts(conf.Hostkeycheck, DefaultKnownHostsFile(), GLOBAL_KNOWN_HOSTS)
+	if err != nil {
+		return nil, err
+	}
 
 	// and set config
 	return &ssh.ClientConfig{
-		User: username,
-		Auth: auth,
-	}
+		User:              host.User,
+		Auth:              auth,
+		HostKeyCallback:   knownhosts.HostKeyCallback(),
+		HostKeyAlgorithms: knownhosts.HostKeyAlgorithms(host.Address()),
+	}, nil
+}
 
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
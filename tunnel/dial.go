@@ -0,0 +1,164 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/utils"
+)
+
+// jumpHost resolves a ProxyJump entry, [user@]host[:port] where host may be
+// a ~/.ssh/config alias. The user defaults to the local one, as OpenSSH.
+func (h *Host) jumpHost(spec string) (*Host, error) {
+	login := ""
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		login, spec = spec[:i], spec[i+1:]
+	}
+	alias, port := spec, ""
+	if host, p, err := net.SplitHostPort(spec); err == nil {
+		alias, port = host, p
+	}
+	if alias == "" {
+		return nil, fmt.Errorf("bad jump host %q", spec)
+	}
+
+	local := ""
+	if u, err := user.Current(); err == nil {
+		local = u.Username
+	}
+	sshconf := h.sshconf
+	if sshconf == nil {
+		sshconf = &SSHConfig{}
+	}
+	jump, err := sshconf.Resolve(alias, local, 22)
+	if err != nil {
+		return nil, err
+	}
+	if login != "" {
+		jump.User = login
+	}
+	if port != "" {
+		if jump.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("bad port in jump host %q", spec)
+		}
+	}
+	return jump, nil
+}
+
+// hop is a host on the way to the Kodi host, with its ssh configuration
+type hop struct {
+	host   *Host
+	config *ssh.ClientConfig
+}
+
+// route returns the jump hosts of host, then host, with their ssh
+// configuration. Built once, keys and passphrases are not read again when
+// the connection is established again.
+func route(conf *utils.Config, host *Host) ([]hop, error) {
+	hosts := []*Host{}
+	for _, spec := range host.ProxyJump {
+		jump, err := host.jumpHost(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, jump)
+	}
+	hosts = append(hosts, host)
+
+	hops := make([]hop, len(hosts))
+	for i, h := range hosts {
+		config, err := NewConfig(conf, h)
+		if err != nil {
+			return nil, err
+		}
+		hops[i] = hop{h, config}
+	}
+	return hops, nil
+}
+
+// Dial opens the ssh connection to host. With jump hosts, each hop is
+// dialled through the connection to the previous one, with its own user,
+// keys and host key check, and the returned client is the one of the last
+// hop, host itself.
+func Dial(conf *utils.Config, host *Host) (*ssh.Client, error) {
+	hops, err := route(conf, host)
+	if err != nil {
+		return nil, err
+	}
+	return dialRoute(hops)
+}
+
+// dialRoute connects to the last of hops through the others
+func dialRoute(hops []hop) (*ssh.Client, error) {
+	var client *ssh.Client
+	for i, hop := range hops {
+		if client == nil {
+			if verbose {
+				log.Printf("Connecting to %s@%s", hop.host.User, hop.host.Address())
+			}
+			c, err := ssh.Dial("tcp", hop.host.Address(), hop.config)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", hop.host.Address(), err)
+			}
+			client = c
+			continue
+		}
+
+		log.Printf("Connecting to %s@%s through %s", hop.host.User, hop.host.Address(), hops[i-1].host.Address())
+		conn, err := client.Dial("tcp", hop.host.Address())
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("%s: %v", hop.host.Address(), err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.host.Address(), hop.config)
+		if err != nil {
+			conn.Close()
+			client.Close()
+			return nil, fmt.Errorf("%s: %v", hop.host.Address(), err)
+		}
+		// the previous hop lives as long as the connection through it
+		prev := client
+		client = ssh.NewClient(c, chans, reqs)
+		go func(client *ssh.Client) {
+			client.Wait()
+			prev.Close()
+		}(client)
+	}
+	return client, nil
+}
+
+// dialer is an ssh.Client or a Conn
+type dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// LoopbackDialer returns a dial function that connects to the port of addr
+// on the loopback interface of the ssh server, the Kodi host itself. Kodi
+// may listen on 127.0.0.1 only, and nothing leaves the Kodi host unencrypted.
+func LoopbackDialer(client dialer) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return client.Dial(network, net.JoinHostPort("127.0.0.1", port))
+	}
+}
+
+// HTTPClient returns an http client whose connections go through the ssh
+// connection to the loopback interface of the Kodi host. Connections are not
+// kept alive, a reconnection would leave them dead in the pool.
+func HTTPClient(client dialer) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Dial:              LoopbackDialer(client),
+			DisableKeepAlives: true,
+		},
+	}
+}
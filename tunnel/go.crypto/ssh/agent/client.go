@@ -24,7 +24,7 @@ import (
 	"math/big"
 	"sync"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
 )
 
 // Agent represents the capabilities of an ssh-agent.
@@ -318,9 +318,34 @@ func (c *client) List() ([]*Key, error) {
 // Sign has the agent sign the data using a protocol 2 key as defined
 // in [PROTOCOL.agent] section 2.6.2.
 func (c *client) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return c.signWithFlags(key, data, 0)
+}
+
+// signature flags of RSA keys, see draft-miller-ssh-agent section 4.5.1.
+const (
+	signatureFlagRsaSha256 = 2
+	signatureFlagRsaSha512 = 4
+)
+
+// signatureFlags returns the sign request flags giving the signature
+// algorithm of an RSA key.
+func signatureFlags(algorithm string) (uint32, error) {
+	switch algorithm {
+	case ssh.KeyAlgoRSA:
+		return 0, nil
+	case ssh.SigAlgoRSASHA2256:
+		return signatureFlagRsaSha256, nil
+	case ssh.SigAlgoRSASHA2512:
+		return signatureFlagRsaSha512, nil
+	}
+	return 0, fmt.Errorf("agent: unsupported signature algorithm %s", algorithm)
+}
+
+func (c *client) signWithFlags(key ssh.PublicKey, data []byte, flags uint32) (*ssh.Signature, error) {
 	req := ssh.Marshal(signRequestAgentMsg{
 		KeyBlob: key.Marshal(),
 		Data:    data,
+		Flags:   flags,
 	})
 
 	msg, err := c.call(req)
@@ -561,3 +586,29 @@ func (s *agentKeyringSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature,
 	// The agent has its own entropy source, so the rand argument is ignored.
 	return s.agent.Sign(s.pub, data)
 }
+
+// SignWithAlgorithm asks the agent for a SHA-2 signature of an RSA key.
+func (s *agentKeyringSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	if algorithm == s.pub.Type() {
+		return s.Sign(rand, data)
+	}
+	flags, err := signatureFlags(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.agent.signWithFlags(s.pub, data, flags)
+	if err != nil {
+		return nil, err
+	}
+	// old agents ignore the flags
+	if sig.Format != algorithm {
+		return nil, fmt.Errorf("agent: got %s signature, asked for %s", sig.Format, algorithm)
+	}
+	return sig, nil
+}
+
+// flagsSigner is implemented by agents that sign with the flags of a sign
+// request.
+type flagsSigner interface {
+	signWithFlags(key ssh.PublicKey, data []byte, flags uint32) (*ssh.Signature, error)
+}
@@ -12,7 +12,7 @@ import (
 	"fmt"
 	"sync"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
 )
 
 type privKey struct {
@@ -152,6 +152,10 @@ func (r *keyring) Add(priv interface{}, cert *ssh.Certificate, comment string) e
 
 // Sign returns a signature for the data.
 func (r *keyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return r.signWithFlags(key, data, 0)
+}
+
+func (r *keyring) signWithFlags(key ssh.PublicKey, data []byte, flags uint32) (*ssh.Signature, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if r.locked {
@@ -161,7 +165,18 @@ func (r *keyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
 	wanted := key.Marshal()
 	for _, k := range r.keys {
 		if bytes.Equal(k.signer.PublicKey().Marshal(), wanted) {
-			return k.signer.Sign(rand.Reader, data)
+			if flags == 0 {
+				return k.signer.Sign(rand.Reader, data)
+			}
+			as, ok := k.signer.(ssh.AlgorithmSigner)
+			if !ok {
+				return nil, errors.New("agent: signature flags for a key without signature algorithms")
+			}
+			algorithm := ssh.SigAlgoRSASHA2256
+			if flags&signatureFlagRsaSha512 != 0 {
+				algorithm = ssh.SigAlgoRSASHA2512
+			}
+			return as.SignWithAlgorithm(rand.Reader, data, algorithm)
 		}
 	}
 	return nil, errors.New("not found")
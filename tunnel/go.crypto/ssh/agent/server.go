@@ -12,7 +12,7 @@ import (
 	"log"
 	"math/big"
 
-	"golang.org/x/crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
 )
 
 // Server wraps an Agent and uses it to implement the agent side of
@@ -116,7 +116,13 @@ func (s *server) processRequest(data []byte) (interface{}, error) {
 			Blob:   req.KeyBlob,
 		}
 
-		sig, err := s.agent.Sign(k, req.Data) //  TODO(hanwen): flags.
+		var sig *ssh.Signature
+		var err error
+		if fs, ok := s.agent.(flagsSigner); ok && req.Flags != 0 {
+			sig, err = fs.signWithFlags(k, req.Data, req.Flags)
+		} else {
+			sig, err = s.agent.Sign(k, req.Data)
+		}
 		if err != nil {
 			return nil, err
 		}
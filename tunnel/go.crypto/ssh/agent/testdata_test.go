@@ -12,8 +12,8 @@ import (
 	"crypto/rand"
 	"fmt"
 
-	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/testdata"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/testdata"
 )
 
 var (
@@ -6,6 +6,7 @@ package ssh
 
 import (
 	"bytes"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,13 @@ const (
 	CertAlgoECDSA256v01 = "ecdsa-sha2-nistp256-cert-v01@openssh.com"
 	CertAlgoECDSA384v01 = "ecdsa-sha2-nistp384-cert-v01@openssh.com"
 	CertAlgoECDSA521v01 = "ecdsa-sha2-nistp521-cert-v01@openssh.com"
+	CertAlgoED25519v01  = "ssh-ed25519-cert-v01@openssh.com"
+
+	// CertAlgoRSASHA2256v01 and CertAlgoRSASHA2512v01 are not certificate
+	// types but algorithm names for RSA certificates signing with
+	// SigAlgoRSASHA2256 or SigAlgoRSASHA2512.
+	CertAlgoRSASHA2256v01 = "rsa-sha2-256-cert-v01@openssh.com"
+	CertAlgoRSASHA2512v01 = "rsa-sha2-512-cert-v01@openssh.com"
 )
 
 // Certificate types distinguish between host and user
@@ -200,6 +208,9 @@ func NewCertSigner(cert *Certificate, signer Signer) (Signer, error) {
 		return nil, errors.New("ssh: signer and cert have different public key")
 	}
 
+	if _, ok := signer.(AlgorithmSigner); ok {
+		return &openSSHCertAlgorithmSigner{openSSHCertSigner{cert, signer}}, nil
+	}
 	return &openSSHCertSigner{cert, signer}, nil
 }
 
@@ -207,6 +218,14 @@ func (s *openSSHCertSigner) Sign(rand io.Reader, data []byte) (*Signature, error
 	return s.signer.Sign(rand, data)
 }
 
+type openSSHCertAlgorithmSigner struct {
+	openSSHCertSigner
+}
+
+func (s *openSSHCertAlgorithmSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error) {
+	return s.signer.(AlgorithmSigner).SignWithAlgorithm(rand, data, algorithm)
+}
+
 func (s *openSSHCertSigner) PublicKey() PublicKey {
 	return s.pub
 }
@@ -360,7 +379,14 @@ func (c *Certificate) SignCert(rand io.Reader, authority Signer) error {
 	}
 	c.SignatureKey = authority.PublicKey()
 
-	sig, err := authority.Sign(rand, c.bytesForSigning())
+	// RSA authorities sign with SHA-2, OpenSSH refuses SHA-1 signatures
+	var sig *Signature
+	var err error
+	if as, ok := authority.(AlgorithmSigner); ok {
+		sig, err = as.SignWithAlgorithm(rand, c.bytesForSigning(), algorithmsForKey(c.SignatureKey)[0])
+	} else {
+		sig, err = authority.Sign(rand, c.bytesForSigning())
+	}
 	if err != nil {
 		return err
 	}
@@ -374,6 +400,89 @@ var certAlgoNames = map[string]string{
 	KeyAlgoECDSA256: CertAlgoECDSA256v01,
 	KeyAlgoECDSA384: CertAlgoECDSA384v01,
 	KeyAlgoECDSA521: CertAlgoECDSA521v01,
+	KeyAlgoED25519:  CertAlgoED25519v01,
+}
+
+// signatureAlgoForCert maps the RSA signature algorithms to their
+// certificate algorithm names.
+var signatureAlgoForCert = map[string]string{
+	SigAlgoRSASHA2256: CertAlgoRSASHA2256v01,
+	SigAlgoRSASHA2512: CertAlgoRSASHA2512v01,
+}
+
+// underlyingAlgo returns the signature algorithm of a public key
+// algorithm: certificate algorithms give the algorithm of their key.
+func underlyingAlgo(algo string) string {
+	for sigAlgo, certAlgo := range signatureAlgoForCert {
+		if certAlgo == algo {
+			return sigAlgo
+		}
+	}
+	for privAlgo, certAlgo := range certAlgoNames {
+		if certAlgo == algo {
+			return privAlgo
+		}
+	}
+	return algo
+}
+
+// keyTypeForAlgo returns the key type (PublicKey.Type()) used by a
+// public key algorithm, e.g. KeyAlgoRSA for SigAlgoRSASHA2512.
+func keyTypeForAlgo(algo string) string {
+	switch algo {
+	case SigAlgoRSASHA2256, SigAlgoRSASHA2512:
+		return KeyAlgoRSA
+	case CertAlgoRSASHA2256v01, CertAlgoRSASHA2512v01:
+		return CertAlgoRSAv01
+	}
+	return algo
+}
+
+// algorithmsForKeyType returns the public key algorithms a key of keyType
+// can use, in preference order.
+func algorithmsForKeyType(keyType string) []string {
+	switch keyType {
+	case KeyAlgoRSA:
+		return []string{SigAlgoRSASHA2512, SigAlgoRSASHA2256, KeyAlgoRSA}
+	case CertAlgoRSAv01:
+		return []string{CertAlgoRSASHA2512v01, CertAlgoRSASHA2256v01, CertAlgoRSAv01}
+	}
+	return []string{keyType}
+}
+
+// algorithmsForKey is like algorithmsForKeyType, without the RSA signature
+// algorithms whose PKCS #1 v1.5 encoded digest doesn't fit in the key
+// (SHA-512 needs at least 752 bits).
+func algorithmsForKey(pub PublicKey) []string {
+	algos := algorithmsForKeyType(pub.Type())
+	if len(algos) == 1 {
+		return algos
+	}
+	if cert, ok := pub.(*Certificate); ok {
+		pub = cert.Key
+	}
+	rsaKey, ok := pub.(*rsaPublicKey)
+	if !ok {
+		// agent keys only know their wire format
+		parsed, err := ParsePublicKey(pub.Marshal())
+		if err != nil {
+			return algos
+		}
+		if rsaKey, ok = parsed.(*rsaPublicKey); !ok {
+			return algos
+		}
+	}
+	size := (*rsa.PublicKey)(rsaKey).Size()
+
+	var fit []string
+	for _, algo := range algos {
+		hash, _ := rsaHash(underlyingAlgo(algo))
+		// 19 bytes of DigestInfo prefix at most, 11 bytes of padding
+		if size >= 19+hash.Size()+11 {
+			fit = append(fit, algo)
+		}
+	}
+	return fit
 }
 
 // certToPrivAlgo returns the underlying algorithm for a certificate algorithm.
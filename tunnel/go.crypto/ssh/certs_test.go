@@ -131,15 +131,15 @@ func TestHostKeyCert(t *testing.T) {
 		defer c1.Close()
 		defer c2.Close()
 
+		errc := make(chan error)
+
 		go func() {
 			conf := ServerConfig{
 				NoClientAuth: true,
 			}
 			conf.AddHostKey(certSigner)
 			_, _, _, err := NewServerConn(c1, &conf)
-			if err != nil {
-				t.Fatalf("NewServerConn: %v", err)
-			}
+			errc <- err
 		}()
 
 		config := &ClientConfig{
@@ -152,5 +152,9 @@ func TestHostKeyCert(t *testing.T) {
 		if (err == nil) != succeed {
 			t.Fatalf("NewClientConn(%q): %v", name, err)
 		}
+		// the server fails too when the client rejects its key
+		if err := <-errc; (err == nil) != succeed {
+			t.Fatalf("NewServerConn(%q): %v", name, err)
+		}
 	}
 }
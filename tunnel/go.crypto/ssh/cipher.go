@@ -14,6 +14,9 @@ import (
 	"fmt"
 	"hash"
 	"io"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/poly1305"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/internal/chacha20"
 )
 
 const (
@@ -109,10 +112,12 @@ var cipherModes = map[string]*streamCipherMode{
 	// RFC4345 introduces improved versions of Arcfour.
 	"arcfour": {16, 0, 0, newRC4},
 
-	// AES-GCM is not a stream cipher, so it is constructed with a
-	// special case. If we add any more non-stream ciphers, we
-	// should invest a cleaner way to do this.
+	// AES-GCM and ChaCha20-Poly1305 are not stream ciphers, so they are
+	// constructed with a special case in newPacketCipher.
 	gcmCipherID: {16, 12, 0, nil},
+
+	// The 64 bytes key is split in the payload key and the length key.
+	chacha20Poly1305ID: {64, 0, 0, nil},
 }
 
 // prefixLen is the length of the packet prefix that contains the packet length
@@ -342,3 +347,124 @@ func (c *gcmCipher) readPacket(seqNum uint32, r io.Reader) ([]byte, error) {
 	plain = plain[1 : length-uint32(padding)]
 	return plain, nil
 }
+
+// chacha20Poly1305Cipher implements the chacha20-poly1305@openssh.com
+// cipher, see PROTOCOL.chacha20poly1305 in the OpenSSH sources. The packet
+// length is encrypted with its own key, the rest of the packet with the
+// main key, and the Poly1305 tag covers both.
+type chacha20Poly1305Cipher struct {
+	lengthKey  [32]byte
+	contentKey [32]byte
+	buf        []byte
+}
+
+func newChaCha20Cipher(key []byte) (packetCipher, error) {
+	if len(key) != 64 {
+		return nil, errors.New("ssh: chacha20-poly1305 needs a 64 byte key")
+	}
+	c := &chacha20Poly1305Cipher{
+		buf: make([]byte, 256),
+	}
+	copy(c.contentKey[:], key[:32])
+	copy(c.lengthKey[:], key[32:])
+	return c, nil
+}
+
+// chacha20Nonce is the sequence number as a 64 bit big endian integer.
+func chacha20Nonce(seqNum uint32) *[8]byte {
+	var nonce [8]byte
+	binary.BigEndian.PutUint32(nonce[4:], seqNum)
+	return &nonce
+}
+
+// polyKey returns the Poly1305 key of a packet, the first block of the
+// content key stream.
+func (c *chacha20Poly1305Cipher) polyKey(nonce *[8]byte) *[32]byte {
+	var polyKey [32]byte
+	chacha20.XORKeyStream(polyKey[:], polyKey[:], nonce, 0, &c.contentKey)
+	return &polyKey
+}
+
+func (c *chacha20Poly1305Cipher) readPacket(seqNum uint32, r io.Reader) ([]byte, error) {
+	nonce := chacha20Nonce(seqNum)
+
+	if _, err := io.ReadFull(r, c.buf[:4]); err != nil {
+		return nil, err
+	}
+	var lenBytes [4]byte
+	chacha20.XORKeyStream(lenBytes[:], c.buf[:4], nonce, 0, &c.lengthKey)
+	length := binary.BigEndian.Uint32(lenBytes[:])
+	if length > maxPacket {
+		return nil, errors.New("ssh: invalid packet length, packet too large")
+	}
+
+	contentEnd := 4 + int(length)
+	packetEnd := contentEnd + poly1305.TagSize
+	if cap(c.buf) < packetEnd {
+		buf := make([]byte, packetEnd)
+		copy(buf, c.buf[:4])
+		c.buf = buf
+	} else {
+		c.buf = c.buf[:packetEnd]
+	}
+	if _, err := io.ReadFull(r, c.buf[4:packetEnd]); err != nil {
+		return nil, err
+	}
+
+	var mac [poly1305.TagSize]byte
+	copy(mac[:], c.buf[contentEnd:packetEnd])
+	if !poly1305.Verify(&mac, c.buf[:contentEnd], c.polyKey(nonce)) {
+		return nil, errors.New("ssh: MAC failure")
+	}
+
+	plain := c.buf[4:contentEnd]
+	chacha20.XORKeyStream(plain, plain, nonce, 1, &c.contentKey)
+
+	if len(plain) == 0 {
+		return nil, errors.New("ssh: empty packet")
+	}
+	padding := plain[0]
+	if padding < 4 {
+		return nil, fmt.Errorf("ssh: illegal padding %d", padding)
+	}
+	if int(padding)+1 >= len(plain) {
+		return nil, fmt.Errorf("ssh: padding %d too large", padding)
+	}
+	return plain[1 : len(plain)-int(padding)], nil
+}
+
+func (c *chacha20Poly1305Cipher) writePacket(seqNum uint32, w io.Writer, rand io.Reader, payload []byte) error {
+	nonce := chacha20Nonce(seqNum)
+
+	// The length is not part of the encrypted blocks, so pad the rest to
+	// the 8 byte multiple of RFC 4253, section 6.
+	padding := 8 - (1+len(payload))%8
+	if padding < 4 {
+		padding += 8
+	}
+	length := 1 + len(payload) + padding
+	contentEnd := 4 + length
+	packetEnd := contentEnd + poly1305.TagSize
+	if cap(c.buf) < packetEnd {
+		c.buf = make([]byte, packetEnd)
+	} else {
+		c.buf = c.buf[:packetEnd]
+	}
+
+	binary.BigEndian.PutUint32(c.buf, uint32(length))
+	chacha20.XORKeyStream(c.buf[:4], c.buf[:4], nonce, 0, &c.lengthKey)
+
+	c.buf[4] = byte(padding)
+	copy(c.buf[5:], payload)
+	if _, err := io.ReadFull(rand, c.buf[5+len(payload):contentEnd]); err != nil {
+		return err
+	}
+	chacha20.XORKeyStream(c.buf[4:contentEnd], c.buf[4:contentEnd], nonce, 1, &c.contentKey)
+
+	var mac [poly1305.TagSize]byte
+	poly1305.Sum(&mac, c.buf[:contentEnd], c.polyKey(nonce))
+	copy(c.buf[contentEnd:], mac[:])
+
+	_, err := w.Write(c.buf)
+	return err
+}
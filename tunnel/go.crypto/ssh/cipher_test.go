@@ -57,3 +57,37 @@ func TestPacketCiphers(t *testing.T) {
 		}
 	}
 }
+
+func TestChaCha20Poly1305Tampering(t *testing.T) {
+	kr := &kexResult{Hash: crypto.SHA256}
+	algs := directionAlgorithms{
+		Cipher:      chacha20Poly1305ID,
+		MAC:         "hmac-sha1",
+		Compression: "none",
+	}
+	client, err := newPacketCipher(clientKeys, algs, kr)
+	if err != nil {
+		t.Fatalf("newPacketCipher: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := client.writePacket(7, buf, rand.Reader, []byte("bla bla")); err != nil {
+		t.Fatalf("writePacket: %v", err)
+	}
+	packet := buf.Bytes()
+
+	for _, i := range []int{0, 4, len(packet) - 1} {
+		server, _ := newPacketCipher(clientKeys, algs, kr)
+		tampered := append([]byte(nil), packet...)
+		tampered[i] ^= 1
+		if _, err := server.readPacket(7, bytes.NewReader(tampered)); err == nil {
+			t.Errorf("readPacket accepted a packet modified at byte %d", i)
+		}
+	}
+
+	// the sequence number is part of the nonce
+	server, _ := newPacketCipher(clientKeys, algs, kr)
+	if _, err := server.readPacket(8, bytes.NewReader(packet)); err == nil {
+		t.Errorf("readPacket accepted a packet with the wrong sequence number")
+	}
+}
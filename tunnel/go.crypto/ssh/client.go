@@ -109,12 +109,18 @@ func (c *connection) clientHandshake(dialAddress string, config *ClientConfig) e
 }
 
 // verifyHostKeySignature verifies the host key obtained in the key
-// exchange.
-func verifyHostKeySignature(hostKey PublicKey, result *kexResult) error {
+// exchange, for the negotiated host key algorithm.
+func verifyHostKeySignature(hostKey PublicKey, algo string, result *kexResult) error {
 	sig, rest, ok := parseSignatureBody(result.Signature)
 	if len(rest) > 0 || !ok {
 		return errors.New("ssh: signature parse error")
 	}
+	if hostKey.Type() != keyTypeForAlgo(algo) {
+		return fmt.Errorf("ssh: host key type %s for algorithm %s", hostKey.Type(), algo)
+	}
+	if sig.Format != underlyingAlgo(algo) {
+		return fmt.Errorf("ssh: signature type %s for algorithm %s", sig.Format, algo)
+	}
 
 	return hostKey.Verify(result.H, sig)
 }
@@ -223,4 +229,9 @@ type ClientConfig struct {
 	// ClientVersion contains the version identification string that will
 	// be used for the connection. If empty, a reasonable default is used.
 	ClientVersion string
+
+	// HostKeyAlgorithms lists the public key algorithms that the client will
+	// accept from the server for host key authentication, in order of
+	// preference. If empty, a reasonable default is used.
+	HostKeyAlgorithms []string
 }
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // clientAuthenticate authenticates with the remote server. See RFC 4252.
@@ -21,6 +22,16 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	if err != nil {
 		return err
 	}
+	// the server may send its extensions first, RFC 8308
+	extensions := map[string][]byte{}
+	if packet[0] == msgExtInfo {
+		if extensions, err = parseExtInfo(packet); err != nil {
+			return err
+		}
+		if packet, err = c.transport.readPacket(); err != nil {
+			return err
+		}
+	}
 	var serviceAccept serviceAcceptMsg
 	if err := Unmarshal(packet, &serviceAccept); err != nil {
 		return err
@@ -31,7 +42,7 @@ func (c *connection) clientAuthenticate(config *ClientConfig) error {
 	tried := make(map[string]bool)
 	var lastMethods []string
 	for auth := AuthMethod(new(noneAuth)); auth != nil; {
-		ok, methods, err := auth.auth(c.transport.getSessionID(), config.User, c.transport, config.Rand)
+		ok, methods, err := auth.auth(c.transport.getSessionID(), config.User, c.transport, config.Rand, extensions)
 		if err != nil {
 			return err
 		}
@@ -80,7 +91,8 @@ type AuthMethod interface {
 	// If authentication is not successful, a []string of alternative
 	// method names is returned. If the slice is nil, it will be ignored
 	// and the previous set of possible methods will be reused.
-	auth(session []byte, user string, p packetConn, rand io.Reader) (bool, []string, error)
+	// The extensions are the ones sent by the server with SSH_MSG_EXT_INFO.
+	auth(session []byte, user string, p packetConn, rand io.Reader, extensions map[string][]byte) (bool, []string, error)
 
 	// method returns the RFC 4252 method name.
 	method() string
@@ -89,7 +101,7 @@ type AuthMethod interface {
 // "none" authentication, RFC 4252 section 5.2.
 type noneAuth int
 
-func (n *noneAuth) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (n *noneAuth) auth(session []byte, user string, c packetConn, rand io.Reader, extensions map[string][]byte) (bool, []string, error) {
 	if err := c.writePacket(Marshal(&userAuthRequestMsg{
 		User:    user,
 		Service: serviceSSH,
@@ -109,7 +121,7 @@ func (n *noneAuth) method() string {
 // a function call, e.g. by prompting the user.
 type passwordCallback func() (password string, err error)
 
-func (cb passwordCallback) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (cb passwordCallback) auth(session []byte, user string, c packetConn, rand io.Reader, extensions map[string][]byte) (bool, []string, error) {
 	type passwordAuthMsg struct {
 		User     string `sshtype:"50"`
 		Service  string
@@ -176,7 +188,7 @@ func (cb publicKeyCallback) method() string {
 	return "publickey"
 }
 
-func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand io.Reader, extensions map[string][]byte) (bool, []string, error) {
 	// Authentication is performed in two stages. The first stage sends an
 	// enquiry to test if each key is acceptable to the remote. The second
 	// stage attempts to authenticate with the valid keys obtained in the
@@ -186,10 +198,15 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 	if err != nil {
 		return false, nil, err
 	}
-	var validKeys []Signer
+	type validKey struct {
+		signer Signer
+		algo   string
+	}
+	var validKeys []validKey
 	for _, signer := range signers {
-		if ok, err := validateKey(signer.PublicKey(), user, c); ok {
-			validKeys = append(validKeys, signer)
+		algo := pickPublicKeyAlgo(signer, extensions)
+		if ok, err := validateKey(signer.PublicKey(), algo, user, c); ok {
+			validKeys = append(validKeys, validKey{signer, algo})
 		} else {
 			if err != nil {
 				return false, nil, err
@@ -199,15 +216,21 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 
 	// methods that may continue if this auth is not successful.
 	var methods []string
-	for _, signer := range validKeys {
-		pub := signer.PublicKey()
+	for _, key := range validKeys {
+		pub := key.signer.PublicKey()
 
 		pubKey := pub.Marshal()
-		sign, err := signer.Sign(rand, buildDataSignedForAuth(session, userAuthRequestMsg{
+		data := buildDataSignedForAuth(session, userAuthRequestMsg{
 			User:    user,
 			Service: serviceSSH,
 			Method:  cb.method(),
-		}, []byte(pub.Type()), pubKey))
+		}, []byte(key.algo), pubKey)
+		var sign *Signature
+		if as, ok := key.signer.(AlgorithmSigner); ok && key.algo != pub.Type() {
+			sign, err = as.SignWithAlgorithm(rand, data, underlyingAlgo(key.algo))
+		} else {
+			sign, err = key.signer.Sign(rand, data)
+		}
 		if err != nil {
 			return false, nil, err
 		}
@@ -221,7 +244,7 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 			Service:  serviceSSH,
 			Method:   cb.method(),
 			HasSig:   true,
-			Algoname: pub.Type(),
+			Algoname: key.algo,
 			PubKey:   pubKey,
 			Sig:      sig,
 		}
@@ -241,27 +264,50 @@ func (cb publicKeyCallback) auth(session []byte, user string, c packetConn, rand
 	return false, methods, nil
 }
 
+// pickPublicKeyAlgo returns the algorithm to authenticate with signer: RSA
+// keys use the best SHA-2 algorithm listed in the server-sig-algs
+// extension, ssh-rsa (SHA-1) if the server didn't send it.
+func pickPublicKeyAlgo(signer Signer, extensions map[string][]byte) string {
+	keyType := signer.PublicKey().Type()
+	if _, ok := signer.(AlgorithmSigner); !ok {
+		return keyType
+	}
+	serverAlgos, ok := extensions[extServerSigAlgs]
+	if !ok {
+		return keyType
+	}
+	accepted := strings.Split(string(serverAlgos), ",")
+	for _, algo := range algorithmsForKey(signer.PublicKey()) {
+		// server-sig-algs lists the signature algorithms, not the
+		// certificate ones
+		if contains(accepted, underlyingAlgo(algo)) {
+			return algo
+		}
+	}
+	return keyType
+}
+
 // validateKey validates the key provided is acceptable to the server.
-func validateKey(key PublicKey, user string, c packetConn) (bool, error) {
+func validateKey(key PublicKey, algo string, user string, c packetConn) (bool, error) {
 	pubKey := key.Marshal()
 	msg := publickeyAuthMsg{
 		User:     user,
 		Service:  serviceSSH,
 		Method:   "publickey",
 		HasSig:   false,
-		Algoname: key.Type(),
+		Algoname: algo,
 		PubKey:   pubKey,
 	}
 	if err := c.writePacket(Marshal(&msg)); err != nil {
 		return false, err
 	}
 
-	return confirmKeyAck(key, c)
+	return confirmKeyAck(key, algo, c)
 }
 
-func confirmKeyAck(key PublicKey, c packetConn) (bool, error) {
+func confirmKeyAck(key PublicKey, algo string, c packetConn) (bool, error) {
 	pubKey := key.Marshal()
-	algoname := key.Type()
+	algoname := algo
 
 	for {
 		packet, err := c.readPacket()
@@ -348,7 +394,7 @@ func (cb KeyboardInteractiveChallenge) method() string {
 	return "keyboard-interactive"
 }
 
-func (cb KeyboardInteractiveChallenge) auth(session []byte, user string, c packetConn, rand io.Reader) (bool, []string, error) {
+func (cb KeyboardInteractiveChallenge) auth(session []byte, user string, c packetConn, rand io.Reader, extensions map[string][]byte) (bool, []string, error) {
 	type initiateMsg struct {
 		User       string `sshtype:"50"`
 		Service    string
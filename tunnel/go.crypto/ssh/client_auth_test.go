@@ -9,6 +9,8 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"strings"
 	"testing"
 )
@@ -41,8 +43,13 @@ func tryAuth(t *testing.T, config *ClientConfig) error {
 			return bytes.Equal(k.Marshal(), testPublicKeys["ecdsa"].Marshal())
 		},
 		UserKeyFallback: func(conn ConnMetadata, key PublicKey) (*Permissions, error) {
-			if conn.User() == "testuser" && bytes.Equal(key.Marshal(), testPublicKeys["rsa"].Marshal()) {
-				return nil, nil
+			if conn.User() != "testuser" {
+				return nil, fmt.Errorf("pubkey for %q not acceptable", conn.User())
+			}
+			for _, name := range []string{"rsa", "rsa-openssh-format", "ed25519"} {
+				if bytes.Equal(key.Marshal(), testPublicKeys[name].Marshal()) {
+					return nil, nil
+				}
 			}
 
 			return nil, fmt.Errorf("pubkey for %q not acceptable", conn.User())
@@ -80,6 +87,7 @@ func tryAuth(t *testing.T, config *ClientConfig) error {
 		},
 	}
 	serverConfig.AddHostKey(testSigners["rsa"])
+	serverConfig.AddHostKey(testSigners["ed25519"])
 
 	go newServer(c1, serverConfig)
 	_, _, _, err = NewClientConn(c2, "", config)
@@ -98,6 +106,88 @@ func TestClientAuthPublicKey(t *testing.T) {
 	}
 }
 
+func TestClientAuthEd25519(t *testing.T) {
+	config := &ClientConfig{
+		User: "testuser",
+		Auth: []AuthMethod{
+			PublicKeys(testSigners["ed25519"]),
+		},
+	}
+	if err := tryAuth(t, config); err != nil {
+		t.Fatalf("unable to dial remote side: %s", err)
+	}
+}
+
+// algoRecorder records the algorithms its signer is asked to sign with
+type algoRecorder struct {
+	AlgorithmSigner
+	algos []string
+}
+
+func (r *algoRecorder) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error) {
+	r.algos = append(r.algos, algorithm)
+	return r.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// plainSigner hides the AlgorithmSigner interface of a signer
+type plainSigner struct {
+	Signer
+}
+
+func TestClientAuthRSASHA2(t *testing.T) {
+	for _, tt := range []struct {
+		key  string
+		want string
+	}{
+		// 512 bits are too short for SHA-512 PKCS#1 v1.5 signatures
+		{"rsa", SigAlgoRSASHA2256},
+		{"rsa-openssh-format", SigAlgoRSASHA2512},
+	} {
+		r := &algoRecorder{AlgorithmSigner: testSigners[tt.key].(AlgorithmSigner)}
+		config := &ClientConfig{
+			User: "testuser",
+			Auth: []AuthMethod{
+				PublicKeys(r),
+			},
+		}
+		if err := tryAuth(t, config); err != nil {
+			t.Fatalf("%s: unable to dial remote side: %s", tt.key, err)
+		}
+		if len(r.algos) != 1 || r.algos[0] != tt.want {
+			t.Errorf("%s: signed with %v, want %s", tt.key, r.algos, tt.want)
+		}
+	}
+}
+
+func TestClientAuthRSAPlainSigner(t *testing.T) {
+	config := &ClientConfig{
+		User: "testuser",
+		Auth: []AuthMethod{
+			PublicKeys(plainSigner{testSigners["rsa"]}),
+		},
+	}
+	if err := tryAuth(t, config); err != nil {
+		t.Fatalf("unable to dial remote side: %s", err)
+	}
+}
+
+func TestPickPublicKeyAlgo(t *testing.T) {
+	s := testSigners["rsa-openssh-format"]
+	if algo := pickPublicKeyAlgo(s, nil); algo != KeyAlgoRSA {
+		t.Errorf("without server-sig-algs got %s, want %s", algo, KeyAlgoRSA)
+	}
+	ext := map[string][]byte{extServerSigAlgs: []byte("ssh-ed25519,rsa-sha2-256")}
+	if algo := pickPublicKeyAlgo(s, ext); algo != SigAlgoRSASHA2256 {
+		t.Errorf("got %s, want %s", algo, SigAlgoRSASHA2256)
+	}
+	if algo := pickPublicKeyAlgo(plainSigner{s}, ext); algo != KeyAlgoRSA {
+		t.Errorf("plain signer got %s, want %s", algo, KeyAlgoRSA)
+	}
+	if algo := pickPublicKeyAlgo(testSigners["ed25519"], ext); algo != KeyAlgoED25519 {
+		t.Errorf("got %s, want %s", algo, KeyAlgoED25519)
+	}
+}
+
 func TestAuthMethodPassword(t *testing.T) {
 	config := &ClientConfig{
 		User: "testuser",
@@ -226,6 +316,68 @@ func TestClientHMAC(t *testing.T) {
 	}
 }
 
+func TestClientHostKeyAlgorithms(t *testing.T) {
+	for _, tt := range []struct {
+		algos []string
+		want  string
+	}{
+		{nil, KeyAlgoRSA},
+		{[]string{KeyAlgoED25519}, KeyAlgoED25519},
+		{[]string{SigAlgoRSASHA2256}, KeyAlgoRSA},
+		{[]string{KeyAlgoRSA}, KeyAlgoRSA},
+	} {
+		var got string
+		config := &ClientConfig{
+			User: "testuser",
+			Auth: []AuthMethod{
+				PublicKeys(testSigners["rsa"]),
+			},
+			HostKeyAlgorithms: tt.algos,
+			HostKeyCallback: func(hostname string, remote net.Addr, key PublicKey) error {
+				got = key.Type()
+				return nil
+			},
+		}
+		if err := tryAuth(t, config); err != nil {
+			t.Fatalf("client could not authenticate with host key algos %v: %v", tt.algos, err)
+		}
+		if got != tt.want {
+			t.Errorf("host key algos %v: got %s host key, want %s", tt.algos, got, tt.want)
+		}
+	}
+}
+
+func TestClientCiphersAndKexes(t *testing.T) {
+	for _, cipher := range supportedCiphers {
+		config := &ClientConfig{
+			User: "testuser",
+			Auth: []AuthMethod{
+				PublicKeys(testSigners["rsa"]),
+			},
+			Config: Config{
+				Ciphers: []string{cipher},
+			},
+		}
+		if err := tryAuth(t, config); err != nil {
+			t.Fatalf("client could not authenticate with cipher %s: %v", cipher, err)
+		}
+	}
+	for _, kex := range supportedKexAlgos {
+		config := &ClientConfig{
+			User: "testuser",
+			Auth: []AuthMethod{
+				PublicKeys(testSigners["rsa"]),
+			},
+			Config: Config{
+				KeyExchanges: []string{kex},
+			},
+		}
+		if err := tryAuth(t, config); err != nil {
+			t.Fatalf("client could not authenticate with kex %s: %v", kex, err)
+		}
+	}
+}
+
 // issue 4285.
 func TestClientUnsupportedCipher(t *testing.T) {
 	config := &ClientConfig{
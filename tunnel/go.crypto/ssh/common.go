@@ -25,6 +25,7 @@ const (
 
 // supportedCiphers specifies the supported ciphers in preference order.
 var supportedCiphers = []string{
+	chacha20Poly1305ID,
 	"aes128-ctr", "aes192-ctr", "aes256-ctr",
 	"aes128-gcm@openssh.com",
 	"arcfour256", "arcfour128",
@@ -33,6 +34,7 @@ var supportedCiphers = []string{
 // supportedKexAlgos specifies the supported key-exchange algorithms in
 // preference order.
 var supportedKexAlgos = []string{
+	kexAlgoCurve25519SHA256, kexAlgoCurve25519SHA256LibSSH,
 	// P384 and P521 are not constant-time yet, but since we don't
 	// reuse ephemeral keys, using them for ECDH should be OK.
 	kexAlgoECDH256, kexAlgoECDH384, kexAlgoECDH521,
@@ -40,15 +42,38 @@ var supportedKexAlgos = []string{
 }
 
 // supportedKexAlgos specifies the supported host-key algorithms (i.e. methods
-// of authenticating servers) in preference order.
+// of authenticating servers) in preference order. Ed25519 comes last so
+// that hosts already known by another key type keep presenting it.
 var supportedHostKeyAlgos = []string{
+	CertAlgoRSASHA2512v01, CertAlgoRSASHA2256v01,
 	CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01,
-	CertAlgoECDSA384v01, CertAlgoECDSA521v01,
+	CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoED25519v01,
 
 	KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521,
+	SigAlgoRSASHA2512, SigAlgoRSASHA2256,
+	KeyAlgoRSA, KeyAlgoDSA, KeyAlgoED25519,
+}
+
+// supportedPubKeyAuthAlgos specifies the public key algorithms accepted
+// for user authentication, sent to clients in the server-sig-algs
+// extension.
+var supportedPubKeyAuthAlgos = []string{
+	KeyAlgoED25519,
+	KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521,
+	SigAlgoRSASHA2512, SigAlgoRSASHA2256,
 	KeyAlgoRSA, KeyAlgoDSA,
 }
 
+// These are the extension negotiation names of RFC 8308.
+const (
+	// extInfoClient is added to the key exchange algorithms of the client
+	// to ask for an SSH_MSG_EXT_INFO message.
+	extInfoClient = "ext-info-c"
+
+	// extServerSigAlgs lists the public key algorithms the server accepts.
+	extServerSigAlgs = "server-sig-algs"
+)
+
 // supportedMACs specifies a default set of MAC algorithms in preference order.
 // This is based on RFC 4253, section 6.4, but with hmac-md5 variants removed
 // because they have reached the end of their useful life.
@@ -61,16 +86,20 @@ var supportedCompressions = []string{compressionNone}
 // hashFuncs keeps the mapping of supported algorithms to their respective
 // hashes needed for signature verification.
 var hashFuncs = map[string]crypto.Hash{
-	KeyAlgoRSA:          crypto.SHA1,
-	KeyAlgoDSA:          crypto.SHA1,
-	KeyAlgoECDSA256:     crypto.SHA256,
-	KeyAlgoECDSA384:     crypto.SHA384,
-	KeyAlgoECDSA521:     crypto.SHA512,
-	CertAlgoRSAv01:      crypto.SHA1,
-	CertAlgoDSAv01:      crypto.SHA1,
-	CertAlgoECDSA256v01: crypto.SHA256,
-	CertAlgoECDSA384v01: crypto.SHA384,
-	CertAlgoECDSA521v01: crypto.SHA512,
+	KeyAlgoRSA:            crypto.SHA1,
+	SigAlgoRSASHA2256:     crypto.SHA256,
+	SigAlgoRSASHA2512:     crypto.SHA512,
+	KeyAlgoDSA:            crypto.SHA1,
+	KeyAlgoECDSA256:       crypto.SHA256,
+	KeyAlgoECDSA384:       crypto.SHA384,
+	KeyAlgoECDSA521:       crypto.SHA512,
+	CertAlgoRSAv01:        crypto.SHA1,
+	CertAlgoRSASHA2256v01: crypto.SHA256,
+	CertAlgoRSASHA2512v01: crypto.SHA512,
+	CertAlgoDSAv01:        crypto.SHA1,
+	CertAlgoECDSA256v01:   crypto.SHA256,
+	CertAlgoECDSA384v01:   crypto.SHA384,
+	CertAlgoECDSA521v01:   crypto.SHA512,
 }
 
 // unexpectedMessageError results when the SSH message that we received didn't
@@ -95,6 +124,12 @@ func findCommonAlgorithm(clientAlgos []string, serverAlgos []string) (commonAlgo
 	return
 }
 
+// aeadCiphers are the ciphers that need no MAC.
+var aeadCiphers = map[string]bool{
+	gcmCipherID:        true,
+	chacha20Poly1305ID: true,
+}
+
 func findCommonCipher(clientCiphers []string, serverCiphers []string) (commonCipher string, ok bool) {
 	for _, clientCipher := range clientCiphers {
 		for _, serverCipher := range serverCiphers {
@@ -143,14 +178,20 @@ func findAgreedAlgorithms(clientKexInit, serverKexInit *kexInitMsg) (algs *algor
 		return
 	}
 
-	result.w.MAC, ok = findCommonAlgorithm(clientKexInit.MACsClientServer, serverKexInit.MACsClientServer)
-	if !ok {
-		return
+	// AEAD ciphers authenticate the packets themselves, the MAC lists
+	// don't have to match
+	if !aeadCiphers[result.w.Cipher] {
+		result.w.MAC, ok = findCommonAlgorithm(clientKexInit.MACsClientServer, serverKexInit.MACsClientServer)
+		if !ok {
+			return
+		}
 	}
 
-	result.r.MAC, ok = findCommonAlgorithm(clientKexInit.MACsServerClient, serverKexInit.MACsServerClient)
-	if !ok {
-		return
+	if !aeadCiphers[result.r.Cipher] {
+		result.r.MAC, ok = findCommonAlgorithm(clientKexInit.MACsServerClient, serverKexInit.MACsServerClient)
+		if !ok {
+			return
+		}
 	}
 
 	result.w.Compression, ok = findCommonAlgorithm(clientKexInit.CompressionClientServer, serverKexInit.CompressionClientServer)
@@ -12,7 +12,7 @@ import (
 	"net"
 	"net/http"
 
-	"golang.org/x/crypto/ssh/terminal"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/terminal"
 )
 
 func ExampleNewServerConn() {
@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"strings"
 	"sync"
 )
 
@@ -66,12 +67,17 @@ type handshakeTransport struct {
 	readError error
 
 	// data for host key checking
-	hostKeyCallback func(hostname string, remote net.Addr, key PublicKey) error
-	dialAddress     string
-	remoteAddr      net.Addr
+	hostKeyCallback   func(hostname string, remote net.Addr, key PublicKey) error
+	hostKeyAlgorithms []string
+	dialAddress       string
+	remoteAddr        net.Addr
 
 	readSinceKex uint64
 
+	// set once the first key exchange is done, extensions are only
+	// negotiated during the first one
+	firstKexDone bool
+
 	// Protects the writing side of the connection
 	mu              sync.Mutex
 	cond            *sync.Cond
@@ -98,6 +104,7 @@ func newClientTransport(conn keyingTransport, clientVersion, serverVersion []byt
 	t.dialAddress = dialAddr
 	t.remoteAddr = addr
 	t.hostKeyCallback = config.HostKeyCallback
+	t.hostKeyAlgorithms = config.HostKeyAlgorithms
 	go t.readLoop()
 	return t
 }
@@ -231,10 +238,16 @@ func (t *handshakeTransport) sendKexInitLocked() (*kexInitMsg, []byte, error) {
 	if len(t.hostKeys) > 0 {
 		for _, k := range t.hostKeys {
 			msg.ServerHostKeyAlgos = append(
-				msg.ServerHostKeyAlgos, k.PublicKey().Type())
+				msg.ServerHostKeyAlgos, hostKeyAlgos(k)...)
 		}
 	} else {
 		msg.ServerHostKeyAlgos = supportedHostKeyAlgos
+		if len(t.hostKeyAlgorithms) > 0 {
+			msg.ServerHostKeyAlgos = t.hostKeyAlgorithms
+		}
+		if !t.firstKexDone {
+			msg.KexAlgos = append(append([]string{}, msg.KexAlgos...), extInfoClient)
+		}
 	}
 	packet := Marshal(msg)
 
@@ -352,14 +365,55 @@ func (t *handshakeTransport) enterKeyExchange(otherInitPacket []byte) error {
 	} else if packet[0] != msgNewKeys {
 		return unexpectedMessageError(msgNewKeys, packet[0])
 	}
+
+	// tell the client which public key algorithms are accepted, RFC 8308
+	if len(t.hostKeys) > 0 && !t.firstKexDone && contains(clientInit.KexAlgos, extInfoClient) {
+		extensions := map[string][]byte{
+			extServerSigAlgs: []byte(strings.Join(supportedPubKeyAuthAlgos, ",")),
+		}
+		if err := t.conn.writePacket(marshalExtInfo(extensions)); err != nil {
+			return err
+		}
+	}
+	t.firstKexDone = true
 	return nil
 }
 
+func contains(list []string, e string) bool {
+	for _, s := range list {
+		if s == e {
+			return true
+		}
+	}
+	return false
+}
+
+// hostKeyAlgos returns the host key algorithms a server can use with k.
+func hostKeyAlgos(k Signer) []string {
+	if _, ok := k.(AlgorithmSigner); ok {
+		return algorithmsForKey(k.PublicKey())
+	}
+	return []string{k.PublicKey().Type()}
+}
+
+// algorithmSignerWrapper is a Signer that signs with the algorithm
+// negotiated for the host key.
+type algorithmSignerWrapper struct {
+	AlgorithmSigner
+	algorithm string
+}
+
+func (w algorithmSignerWrapper) Sign(rand io.Reader, data []byte) (*Signature, error) {
+	return w.SignWithAlgorithm(rand, data, w.algorithm)
+}
+
 func (t *handshakeTransport) server(kex kexAlgorithm, algs *algorithms, magics *handshakeMagics) (*kexResult, error) {
 	var hostKey Signer
 	for _, k := range t.hostKeys {
 		if algs.hostKey == k.PublicKey().Type() {
 			hostKey = k
+		} else if as, ok := k.(AlgorithmSigner); ok && contains(hostKeyAlgos(k), algs.hostKey) {
+			hostKey = algorithmSignerWrapper{as, underlyingAlgo(algs.hostKey)}
 		}
 	}
 
@@ -378,7 +432,7 @@ func (t *handshakeTransport) client(kex kexAlgorithm, algs *algorithms, magics *
 		return nil, err
 	}
 
-	if err := verifyHostKeySignature(hostKey, result); err != nil {
+	if err := verifyHostKeySignature(hostKey, algs.hostKey, result); err != nil {
 		return nil, err
 	}
 
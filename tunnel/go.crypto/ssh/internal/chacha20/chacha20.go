@@ -0,0 +1,91 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package chacha20 implements the original ChaCha20 stream cipher of
+// D. J. Bernstein, with a 64 bit nonce and a 64 bit block counter, as used by
+// the chacha20-poly1305@openssh.com SSH cipher.
+//
+// See https://cr.yp.to/chacha/chacha-20080128.pdf and
+// https://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.chacha20poly1305.
+package chacha20
+
+import "encoding/binary"
+
+// BlockSize is the size of one key stream block.
+const BlockSize = 64
+
+const rounds = 20
+
+// "expand 32-byte k"
+var sigma = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+
+func quarterRound(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d ^= a
+	d = d<<16 | d>>16
+	c += d
+	b ^= c
+	b = b<<12 | b>>20
+	a += b
+	d ^= a
+	d = d<<8 | d>>24
+	c += d
+	b ^= c
+	b = b<<7 | b>>25
+	return a, b, c, d
+}
+
+// core writes the key stream block of state to out.
+func core(out *[BlockSize]byte, state *[16]uint32) {
+	x := *state
+	for i := 0; i < rounds; i += 2 {
+		// columns
+		x[0], x[4], x[8], x[12] = quarterRound(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarterRound(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarterRound(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarterRound(x[3], x[7], x[11], x[15])
+		// diagonals
+		x[0], x[5], x[10], x[15] = quarterRound(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarterRound(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarterRound(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarterRound(x[3], x[4], x[9], x[14])
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+state[i])
+	}
+}
+
+// XORKeyStream crypts in into out with the key stream of key and nonce,
+// starting at the block number counter. out and in may be the same slice
+// but must not otherwise overlap, out must be at least as long as in.
+func XORKeyStream(out, in []byte, nonce *[8]byte, counter uint64, key *[32]byte) {
+	if len(out) < len(in) {
+		panic("chacha20: output smaller than input")
+	}
+
+	var state [16]uint32
+	copy(state[:4], sigma[:])
+	for i := 0; i < 8; i++ {
+		state[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	state[14] = binary.LittleEndian.Uint32(nonce[0:])
+	state[15] = binary.LittleEndian.Uint32(nonce[4:])
+
+	var block [BlockSize]byte
+	for len(in) > 0 {
+		state[12] = uint32(counter)
+		state[13] = uint32(counter >> 32)
+		core(&block, &state)
+
+		n := len(in)
+		if n > BlockSize {
+			n = BlockSize
+		}
+		for i := 0; i < n; i++ {
+			out[i] = in[i] ^ block[i]
+		}
+		in, out = in[n:], out[n:]
+		counter++
+	}
+}
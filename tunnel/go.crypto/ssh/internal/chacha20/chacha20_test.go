@@ -0,0 +1,83 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package chacha20
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Key stream test vectors from RFC 7539, appendix A.1, their 96 bit nonces
+// are zero in the upper 32 bits so they also apply to the 64 bit nonce variant.
+var golden = []struct {
+	key     string
+	nonce   string
+	counter uint64
+	stream  string
+}{
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000",
+		0,
+		"76b8e0ada0f13d90405d6ae55386bd28bdd219b8a08ded1aa836efcc8b770dc7" +
+			"da41597c5157488d7724e03fb8d84a376a43b8f41518a11cc387b669b2ee6586",
+	},
+	{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0000000000000000",
+		1,
+		"9f07e7be5551387a98ba977c732d080dcb0f29a048e3656912c6533e32ee7aed" +
+			"29b721769ce64e43d57133b074d839d531ed1f28510afb45ace10a1f4b794d6f",
+	},
+}
+
+func decode(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestKeyStream(t *testing.T) {
+	for i, g := range golden {
+		var key [32]byte
+		var nonce [8]byte
+		copy(key[:], decode(t, g.key))
+		copy(nonce[:], decode(t, g.nonce))
+		want := decode(t, g.stream)
+
+		got := make([]byte, len(want))
+		XORKeyStream(got, got, &nonce, g.counter, &key)
+		if !bytes.Equal(got, want) {
+			t.Errorf("test %d: got %x, want %x", i, got, want)
+		}
+	}
+}
+
+func TestCounterContinues(t *testing.T) {
+	var key [32]byte
+	var nonce [8]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce[7] = 42
+
+	// a long message is the concatenation of the successive blocks
+	long := make([]byte, 3*BlockSize+10)
+	XORKeyStream(long, long, &nonce, 5, &key)
+	for i := uint64(0); i < 4; i++ {
+		block := make([]byte, BlockSize)
+		XORKeyStream(block, block, &nonce, 5+i, &key)
+		end := (i + 1) * BlockSize
+		if end > uint64(len(long)) {
+			end = uint64(len(long))
+		}
+		if !bytes.Equal(long[i*BlockSize:end], block[:end-i*BlockSize]) {
+			t.Errorf("block %d doesn't match", i)
+		}
+	}
+}
@@ -46,3 +46,57 @@ func TestKexes(t *testing.T) {
 		}
 	}
 }
+
+func TestCurve25519LowOrderPoint(t *testing.T) {
+	var kp curve25519KeyPair
+	if err := kp.generate(rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	// the zero point has order 1, it gives an all zero secret
+	if _, err := kp.secret(make([]byte, 32)); err == nil {
+		t.Errorf("secret accepted a low order point")
+	}
+	if _, err := kp.secret(make([]byte, 31)); err == nil {
+		t.Errorf("secret accepted a short public value")
+	}
+}
+
+func TestDefaultAlgorithms(t *testing.T) {
+	var config Config
+	config.SetDefaults()
+	client := &kexInitMsg{
+		KexAlgos:                config.KeyExchanges,
+		ServerHostKeyAlgos:      supportedHostKeyAlgos,
+		CiphersClientServer:     config.Ciphers,
+		CiphersServerClient:     config.Ciphers,
+		MACsClientServer:        config.MACs,
+		MACsServerClient:        config.MACs,
+		CompressionClientServer: supportedCompressions,
+		CompressionServerClient: supportedCompressions,
+	}
+	// a hardened sshd offering modern algorithms only
+	server := &kexInitMsg{
+		KexAlgos:                []string{"sntrup761x25519-sha512@openssh.com", kexAlgoCurve25519SHA256LibSSH},
+		ServerHostKeyAlgos:      []string{KeyAlgoED25519},
+		CiphersClientServer:     []string{chacha20Poly1305ID},
+		CiphersServerClient:     []string{chacha20Poly1305ID},
+		MACsClientServer:        []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"},
+		MACsServerClient:        []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256"},
+		CompressionClientServer: []string{compressionNone},
+		CompressionServerClient: []string{compressionNone},
+	}
+	algs := findAgreedAlgorithms(client, server)
+	if algs == nil {
+		t.Fatal("no common algorithms with a hardened server")
+	}
+	if algs.kex != kexAlgoCurve25519SHA256LibSSH || algs.w.Cipher != chacha20Poly1305ID || algs.r.Cipher != chacha20Poly1305ID {
+		t.Errorf("got kex %q, ciphers %q/%q", algs.kex, algs.w.Cipher, algs.r.Cipher)
+	}
+
+	// the defaults on both sides
+	server = client
+	if algs = findAgreedAlgorithms(client, server); algs == nil ||
+		algs.kex != kexAlgoCurve25519SHA256 || algs.w.Cipher != chacha20Poly1305ID {
+		t.Errorf("default algorithms %+v", algs)
+	}
+}
@@ -7,8 +7,11 @@ package ssh
 import (
 	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/x509"
@@ -19,6 +22,9 @@ import (
 	"fmt"
 	"io"
 	"math/big"
+	"strings"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/internal/bcrypt_pbkdf"
 )
 
 // These constants represent the algorithm names for key types supported by this
@@ -29,6 +35,14 @@ const (
 	KeyAlgoECDSA256 = "ecdsa-sha2-nistp256"
 	KeyAlgoECDSA384 = "ecdsa-sha2-nistp384"
 	KeyAlgoECDSA521 = "ecdsa-sha2-nistp521"
+	KeyAlgoED25519  = "ssh-ed25519"
+)
+
+// These constants represent the signature algorithms of RSA keys besides
+// KeyAlgoRSA (SHA-1), see RFC 8332.
+const (
+	SigAlgoRSASHA2256 = "rsa-sha2-256"
+	SigAlgoRSASHA2512 = "rsa-sha2-512"
 )
 
 // parsePubKey parses a public key of the given algorithm.
@@ -41,7 +55,9 @@ func parsePubKey(in []byte, algo string) (pubKey PublicKey, rest []byte, err err
 		return parseDSA(in)
 	case KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521:
 		return parseECDSA(in)
-	case CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01:
+	case KeyAlgoED25519:
+		return parseED25519(in)
+	case CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoED25519v01:
 		cert, err := parseCert(in, certToPrivAlgo(algo))
 		if err != nil {
 			return nil, nil, err
@@ -212,6 +228,29 @@ type Signer interface {
 	Sign(rand io.Reader, data []byte) (*Signature, error)
 }
 
+// An AlgorithmSigner is a Signer that can also sign with a given
+// signature algorithm, such as SigAlgoRSASHA2256 for RSA keys.
+type AlgorithmSigner interface {
+	Signer
+
+	// SignWithAlgorithm is like Signer.Sign, but the signature uses
+	// algorithm, which must be supported by the key type.
+	SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error)
+}
+
+// rsaHash returns the hash used by an RSA signature algorithm.
+func rsaHash(algorithm string) (crypto.Hash, bool) {
+	switch algorithm {
+	case KeyAlgoRSA:
+		return crypto.SHA1, true
+	case SigAlgoRSASHA2256:
+		return crypto.SHA256, true
+	case SigAlgoRSASHA2512:
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
 type rsaPublicKey rsa.PublicKey
 
 func (r *rsaPublicKey) Type() string {
@@ -258,13 +297,14 @@ func (r *rsaPublicKey) Marshal() []byte {
 }
 
 func (r *rsaPublicKey) Verify(data []byte, sig *Signature) error {
-	if sig.Format != r.Type() {
+	hash, ok := rsaHash(sig.Format)
+	if !ok {
 		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, r.Type())
 	}
-	h := crypto.SHA1.New()
+	h := hash.New()
 	h.Write(data)
 	digest := h.Sum(nil)
-	return rsa.VerifyPKCS1v15((*rsa.PublicKey)(r), crypto.SHA1, digest, sig.Blob)
+	return rsa.VerifyPKCS1v15((*rsa.PublicKey)(r), hash, digest, sig.Blob)
 }
 
 type rsaPrivateKey struct {
@@ -276,15 +316,23 @@ func (r *rsaPrivateKey) PublicKey() PublicKey {
 }
 
 func (r *rsaPrivateKey) Sign(rand io.Reader, data []byte) (*Signature, error) {
-	h := crypto.SHA1.New()
+	return r.SignWithAlgorithm(rand, data, KeyAlgoRSA)
+}
+
+func (r *rsaPrivateKey) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*Signature, error) {
+	hash, ok := rsaHash(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("ssh: unsupported signature algorithm %s for key type %s", algorithm, KeyAlgoRSA)
+	}
+	h := hash.New()
 	h.Write(data)
 	digest := h.Sum(nil)
-	blob, err := rsa.SignPKCS1v15(rand, r.PrivateKey, crypto.SHA1, digest)
+	blob, err := rsa.SignPKCS1v15(rand, r.PrivateKey, hash, digest)
 	if err != nil {
 		return nil, err
 	}
 	return &Signature{
-		Format: r.PublicKey().Type(),
+		Format: algorithm,
 		Blob:   blob,
 	}, nil
 }
@@ -522,14 +570,76 @@ func (k *ecdsaPrivateKey) Sign(rand io.Reader, data []byte) (*Signature, error)
 	}, nil
 }
 
-// NewSignerFromKey takes a pointer to rsa, dsa or ecdsa PrivateKey
-// returns a corresponding Signer instance. EC keys should use P256,
-// P384 or P521.
+type ed25519PublicKey ed25519.PublicKey
+
+func (k ed25519PublicKey) Type() string {
+	return KeyAlgoED25519
+}
+
+// parseED25519 parses an Ed25519 key according to RFC 8709, section 4.
+func parseED25519(in []byte) (out PublicKey, rest []byte, err error) {
+	var w struct {
+		KeyBytes []byte
+		Rest     []byte `ssh:"rest"`
+	}
+	if err := Unmarshal(in, &w); err != nil {
+		return nil, nil, err
+	}
+	if len(w.KeyBytes) != ed25519.PublicKeySize {
+		return nil, nil, fmt.Errorf("ssh: invalid size %d for Ed25519 public key", len(w.KeyBytes))
+	}
+	return ed25519PublicKey(w.KeyBytes), w.Rest, nil
+}
+
+func (k ed25519PublicKey) Marshal() []byte {
+	w := struct {
+		Name     string
+		KeyBytes []byte
+	}{
+		KeyAlgoED25519,
+		[]byte(k),
+	}
+	return Marshal(&w)
+}
+
+func (k ed25519PublicKey) Verify(data []byte, sig *Signature) error {
+	if sig.Format != k.Type() {
+		return fmt.Errorf("ssh: signature type %s for key type %s", sig.Format, k.Type())
+	}
+	if !ed25519.Verify(ed25519.PublicKey(k), data, sig.Blob) {
+		return errors.New("ssh: signature did not verify")
+	}
+	return nil
+}
+
+type ed25519PrivateKey struct {
+	ed25519.PrivateKey
+}
+
+func (k *ed25519PrivateKey) PublicKey() PublicKey {
+	return ed25519PublicKey(k.PrivateKey.Public().(ed25519.PublicKey))
+}
+
+func (k *ed25519PrivateKey) Sign(rand io.Reader, data []byte) (*Signature, error) {
+	// Ed25519 signatures are deterministic, rand is not used
+	return &Signature{
+		Format: KeyAlgoED25519,
+		Blob:   ed25519.Sign(k.PrivateKey, data),
+	}, nil
+}
+
+// NewSignerFromKey takes a pointer to rsa, dsa or ecdsa PrivateKey, or an
+// ed25519 PrivateKey, and returns a corresponding Signer instance. EC keys
+// should use P256, P384 or P521.
 func NewSignerFromKey(k interface{}) (Signer, error) {
 	var sshKey Signer
 	switch t := k.(type) {
 	case *rsa.PrivateKey:
 		sshKey = &rsaPrivateKey{t}
+	case ed25519.PrivateKey:
+		sshKey = &ed25519PrivateKey{t}
+	case *ed25519.PrivateKey:
+		sshKey = &ed25519PrivateKey{*t}
 	case *dsa.PrivateKey:
 		sshKey = &dsaPrivateKey{t}
 	case *ecdsa.PrivateKey:
@@ -544,13 +654,18 @@ func NewSignerFromKey(k interface{}) (Signer, error) {
 	return sshKey, nil
 }
 
-// NewPublicKey takes a pointer to rsa, dsa or ecdsa PublicKey
-// and returns a corresponding ssh PublicKey instance. EC keys should use P256, P384 or P521.
+// NewPublicKey takes a pointer to rsa, dsa or ecdsa PublicKey, or an ed25519
+// PublicKey, and returns a corresponding ssh PublicKey instance. EC keys should
+// use P256, P384 or P521.
 func NewPublicKey(k interface{}) (PublicKey, error) {
 	var sshKey PublicKey
 	switch t := k.(type) {
 	case *rsa.PublicKey:
 		sshKey = (*rsaPublicKey)(t)
+	case ed25519.PublicKey:
+		sshKey = ed25519PublicKey(t)
+	case *ed25519.PublicKey:
+		sshKey = ed25519PublicKey(*t)
 	case *ecdsa.PublicKey:
 		if !supportedEllipticCurve(t.Curve) {
 			return nil, errors.New("ssh: only P256, P384 and P521 EC keys are supported.")
@@ -564,8 +679,21 @@ func NewPublicKey(k interface{}) (PublicKey, error) {
 	return sshKey, nil
 }
 
+// A PassphraseMissingError indicates that parsing this private key requires a
+// passphrase. Use ParsePrivateKeyWithPassphrase.
+type PassphraseMissingError struct {
+	// PublicKey will be set if the private key format includes an
+	// unencrypted public key along with the encrypted private key.
+	PublicKey PublicKey
+}
+
+func (*PassphraseMissingError) Error() string {
+	return "ssh: this private key is passphrase protected"
+}
+
 // ParsePrivateKey returns a Signer from a PEM encoded private key. It supports
-// the same keys as ParseRawPrivateKey.
+// the same keys as ParseRawPrivateKey. If the private key is encrypted, it
+// will return a PassphraseMissingError.
 func ParsePrivateKey(pemBytes []byte) (Signer, error) {
 	key, err := ParseRawPrivateKey(pemBytes)
 	if err != nil {
@@ -575,14 +703,32 @@ func ParsePrivateKey(pemBytes []byte) (Signer, error) {
 	return NewSignerFromKey(key)
 }
 
+// ParsePrivateKeyWithPassphrase returns a Signer from a PEM encoded private
+// key and passphrase. It supports the same keys as
+// ParseRawPrivateKeyWithPassphrase.
+func ParsePrivateKeyWithPassphrase(pemBytes, passphrase []byte) (Signer, error) {
+	key, err := ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSignerFromKey(key)
+}
+
 // ParseRawPrivateKey returns a private key from a PEM encoded private key. It
-// supports RSA (PKCS#1), DSA (OpenSSL), and ECDSA private keys.
+// supports RSA (PKCS#1), DSA (OpenSSL), ECDSA private keys and the OpenSSH
+// format ("OPENSSH PRIVATE KEY", RSA, DSA, ECDSA and Ed25519 keys). If the
+// private key is encrypted, it will return a PassphraseMissingError.
 func ParseRawPrivateKey(pemBytes []byte) (interface{}, error) {
 	block, _ := pem.Decode(pemBytes)
 	if block == nil {
 		return nil, errors.New("ssh: no key found")
 	}
 
+	if encryptedBlock(block) {
+		return nil, &PassphraseMissingError{}
+	}
+
 	switch block.Type {
 	case "RSA PRIVATE KEY":
 		return x509.ParsePKCS1PrivateKey(block.Bytes)
@@ -590,11 +736,56 @@ func ParseRawPrivateKey(pemBytes []byte) (interface{}, error) {
 		return x509.ParseECPrivateKey(block.Bytes)
 	case "DSA PRIVATE KEY":
 		return ParseDSAPrivateKey(block.Bytes)
+	case "OPENSSH PRIVATE KEY":
+		return parseOpenSSHPrivateKey(block.Bytes, unencryptedOpenSSHKey)
+	default:
+		return nil, fmt.Errorf("ssh: unsupported key type %q", block.Type)
+	}
+}
+
+// ParseRawPrivateKeyWithPassphrase returns a private key decrypted with
+// passphrase from a PEM encoded private key. Legacy PEM encryption
+// ("Proc-Type: 4,ENCRYPTED") and the OpenSSH format with the bcrypt KDF
+// are supported.
+func ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase []byte) (interface{}, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("ssh: no key found")
+	}
+
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		return parseOpenSSHPrivateKey(block.Bytes, passphraseProtectedOpenSSHKey(passphrase))
+	}
+
+	if !encryptedBlock(block) || !x509.IsEncryptedPEMBlock(block) {
+		return nil, errors.New("ssh: not an encrypted key")
+	}
+
+	buf, err := x509.DecryptPEMBlock(block, passphrase)
+	if err != nil {
+		if err == x509.IncorrectPasswordError {
+			return nil, err
+		}
+		return nil, fmt.Errorf("ssh: cannot decode encrypted private keys: %v", err)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(buf)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(buf)
+	case "DSA PRIVATE KEY":
+		return ParseDSAPrivateKey(buf)
 	default:
 		return nil, fmt.Errorf("ssh: unsupported key type %q", block.Type)
 	}
 }
 
+// encryptedBlock tells whether a private key PEM block is encrypted.
+func encryptedBlock(block *pem.Block) bool {
+	return strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED")
+}
+
 // ParseDSAPrivateKey returns a DSA private key from its ASN.1 DER encoding, as
 // specified by the OpenSSL DSA man page.
 func ParseDSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
@@ -626,3 +817,271 @@ func ParseDSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
 		X: k.Pub,
 	}, nil
 }
+
+const opensshKeyMagic = "openssh-key-v1\x00"
+
+// openSSHDecryptFunc decrypts the private section of an OpenSSH key.
+type openSSHDecryptFunc func(cipherName, kdfName, kdfOpts string, privKeyBlock []byte) ([]byte, error)
+
+func unencryptedOpenSSHKey(cipherName, kdfName, kdfOpts string, privKeyBlock []byte) ([]byte, error) {
+	if kdfName != "none" || cipherName != "none" {
+		return nil, &PassphraseMissingError{}
+	}
+	if kdfOpts != "" {
+		return nil, errors.New("ssh: invalid openssh private key")
+	}
+	return privKeyBlock, nil
+}
+
+func passphraseProtectedOpenSSHKey(passphrase []byte) openSSHDecryptFunc {
+	return func(cipherName, kdfName, kdfOpts string, privKeyBlock []byte) ([]byte, error) {
+		if kdfName == "none" || cipherName == "none" {
+			return nil, errors.New("ssh: key is not password protected")
+		}
+		if kdfName != "bcrypt" {
+			return nil, fmt.Errorf("ssh: unknown KDF %q, only supports %q", kdfName, "bcrypt")
+		}
+
+		var opts struct {
+			Salt   string
+			Rounds uint32
+		}
+		if err := Unmarshal([]byte(kdfOpts), &opts); err != nil {
+			return nil, err
+		}
+
+		var keyLen int
+		var newCipher func(key, iv []byte) (cipher.BlockMode, cipher.Stream, error)
+		switch cipherName {
+		case "aes128-ctr", "aes192-ctr", "aes256-ctr", "aes128-cbc", "aes192-cbc", "aes256-cbc":
+			fmt.Sscanf(cipherName, "aes%d-", &keyLen)
+			keyLen /= 8
+			ctr := strings.HasSuffix(cipherName, "-ctr")
+			newCipher = func(key, iv []byte) (cipher.BlockMode, cipher.Stream, error) {
+				c, err := aes.NewCipher(key)
+				if err != nil {
+					return nil, nil, err
+				}
+				if ctr {
+					return nil, cipher.NewCTR(c, iv), nil
+				}
+				return cipher.NewCBCDecrypter(c, iv), nil, nil
+			}
+		default:
+			return nil, fmt.Errorf("ssh: unknown cipher %q, only supports aes-ctr and aes-cbc", cipherName)
+		}
+
+		k, err := bcrypt_pbkdf.Key(passphrase, []byte(opts.Salt), int(opts.Rounds), keyLen+aes.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(privKeyBlock)%aes.BlockSize != 0 {
+			return nil, errors.New("ssh: invalid encrypted private key length, not a multiple of the block size")
+		}
+
+		mode, stream, err := newCipher(k[:keyLen], k[keyLen:])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(privKeyBlock))
+		if stream != nil {
+			stream.XORKeyStream(out, privKeyBlock)
+		} else {
+			mode.CryptBlocks(out, privKeyBlock)
+		}
+		return out, nil
+	}
+}
+
+// parseOpenSSHPrivateKey parses an OpenSSH private key container, as
+// written by ssh-keygen since OpenSSH 7.8. See
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+func parseOpenSSHPrivateKey(key []byte, decrypt openSSHDecryptFunc) (interface{}, error) {
+	if len(key) < len(opensshKeyMagic) || string(key[:len(opensshKeyMagic)]) != opensshKeyMagic {
+		return nil, errors.New("ssh: invalid openssh private key format")
+	}
+	remaining := key[len(opensshKeyMagic):]
+
+	var w struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}
+	if err := Unmarshal(remaining, &w); err != nil {
+		return nil, err
+	}
+	if w.NumKeys != 1 {
+		// OpenSSH itself only supports one key per file
+		return nil, errors.New("ssh: multi-key files are not supported")
+	}
+
+	privKeyBlock, err := decrypt(w.CipherName, w.KdfName, w.KdfOpts, w.PrivKeyBlock)
+	if err != nil {
+		if err, ok := err.(*PassphraseMissingError); ok {
+			pub, errPub := ParsePublicKey(w.PubKey)
+			if errPub != nil {
+				return nil, fmt.Errorf("ssh: failed to parse embedded public key: %v", errPub)
+			}
+			err.PublicKey = pub
+		}
+		return nil, err
+	}
+
+	var pk1 struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}
+	if err := Unmarshal(privKeyBlock, &pk1); err != nil || pk1.Check1 != pk1.Check2 {
+		if w.CipherName != "none" {
+			return nil, x509.IncorrectPasswordError
+		}
+		return nil, errors.New("ssh: malformed OpenSSH key")
+	}
+
+	switch pk1.Keytype {
+	case KeyAlgoRSA:
+		var key struct {
+			N       *big.Int
+			E       *big.Int
+			D       *big.Int
+			Iqmp    *big.Int
+			P       *big.Int
+			Q       *big.Int
+			Comment string
+			Pad     []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(pk1.Rest, &key); err != nil {
+			return nil, err
+		}
+		if err := checkOpenSSHKeyPadding(key.Pad); err != nil {
+			return nil, err
+		}
+		pk := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{
+				N: key.N,
+				E: int(key.E.Int64()),
+			},
+			D:      key.D,
+			Primes: []*big.Int{key.P, key.Q},
+		}
+		if err := pk.Validate(); err != nil {
+			return nil, err
+		}
+		pk.Precompute()
+		return pk, nil
+
+	case KeyAlgoED25519:
+		var key struct {
+			Pub     []byte
+			Priv    []byte
+			Comment string
+			Pad     []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(pk1.Rest, &key); err != nil {
+			return nil, err
+		}
+		if len(key.Priv) != ed25519.PrivateKeySize {
+			return nil, errors.New("ssh: private key unexpected length")
+		}
+		if !bytes.Equal(key.Priv[32:], key.Pub) {
+			return nil, errors.New("ssh: public key does not match private key")
+		}
+		if err := checkOpenSSHKeyPadding(key.Pad); err != nil {
+			return nil, err
+		}
+		pk := make(ed25519.PrivateKey, ed25519.PrivateKeySize)
+		copy(pk, key.Priv)
+		return pk, nil
+
+	case KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521:
+		var key struct {
+			Curve   string
+			Pub     []byte
+			D       *big.Int
+			Comment string
+			Pad     []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(pk1.Rest, &key); err != nil {
+			return nil, err
+		}
+		if err := checkOpenSSHKeyPadding(key.Pad); err != nil {
+			return nil, err
+		}
+		var curve elliptic.Curve
+		switch key.Curve {
+		case "nistp256":
+			curve = elliptic.P256()
+		case "nistp384":
+			curve = elliptic.P384()
+		case "nistp521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("ssh: unhandled elliptic curve %q", key.Curve)
+		}
+		x, y := elliptic.Unmarshal(curve, key.Pub)
+		if x == nil || y == nil {
+			return nil, errors.New("ssh: failed to unmarshal public key")
+		}
+		if key.D.Cmp(curve.Params().N) >= 0 {
+			return nil, errors.New("ssh: scalar is out of range")
+		}
+		x2, y2 := curve.ScalarBaseMult(key.D.Bytes())
+		if x.Cmp(x2) != 0 || y.Cmp(y2) != 0 {
+			return nil, errors.New("ssh: public key does not match private key")
+		}
+		return &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: curve,
+				X:     x,
+				Y:     y,
+			},
+			D: key.D,
+		}, nil
+
+	case KeyAlgoDSA:
+		var key struct {
+			P       *big.Int
+			Q       *big.Int
+			G       *big.Int
+			Y       *big.Int
+			X       *big.Int
+			Comment string
+			Pad     []byte `ssh:"rest"`
+		}
+		if err := Unmarshal(pk1.Rest, &key); err != nil {
+			return nil, err
+		}
+		if err := checkOpenSSHKeyPadding(key.Pad); err != nil {
+			return nil, err
+		}
+		return &dsa.PrivateKey{
+			PublicKey: dsa.PublicKey{
+				Parameters: dsa.Parameters{
+					P: key.P,
+					Q: key.Q,
+					G: key.G,
+				},
+				Y: key.Y,
+			},
+			X: key.X,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("ssh: unhandled key type %q", pk1.Keytype)
+	}
+}
+
+// checkOpenSSHKeyPadding checks the 1, 2, 3... padding of the private section.
+func checkOpenSSHKeyPadding(pad []byte) error {
+	for i, b := range pad {
+		if int(b) != i+1 {
+			return errors.New("ssh: padding not as expected")
+		}
+	}
+	return nil
+}
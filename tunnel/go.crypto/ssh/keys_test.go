@@ -8,16 +8,18 @@ import (
 	"bytes"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 
-	"golang.org/x/crypto/ssh/testdata"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/testdata"
 )
 
 func rawKey(pub PublicKey) interface{} {
@@ -28,6 +30,8 @@ func rawKey(pub PublicKey) interface{} {
 		return (*dsa.PublicKey)(k)
 	case *ecdsaPublicKey:
 		return (*ecdsa.PublicKey)(k)
+	case ed25519PublicKey:
+		return (ed25519.PublicKey)(k)
 	case *Certificate:
 		return k
 	}
@@ -152,6 +156,95 @@ func TestParseDSA(t *testing.T) {
 // Tests for authorized_keys parsing.
 
 // getTestKey returns a public key, and its base64 encoding.
+func TestParseEd25519(t *testing.T) {
+	s, err := ParsePrivateKey(testdata.PEMBytes["ed25519"])
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+	if s.PublicKey().Type() != KeyAlgoED25519 {
+		t.Fatalf("got key type %q, want %q", s.PublicKey().Type(), KeyAlgoED25519)
+	}
+
+	data := []byte("sign me")
+	sig, err := s.Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if err := s.PublicKey().Verify(data, sig); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	data[0] ^= 0xff
+	if err := s.PublicKey().Verify(data, sig); err == nil {
+		t.Errorf("Verify succeeded on modified data")
+	}
+}
+
+func TestParseOpenSSHRSA(t *testing.T) {
+	raw, err := ParseRawPrivateKey(testdata.PEMBytes["rsa-openssh-format"])
+	if err != nil {
+		t.Fatalf("ParseRawPrivateKey: %v", err)
+	}
+	key, ok := raw.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("got %T, want *rsa.PrivateKey", raw)
+	}
+	if err := key.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestParseEncryptedPrivateKeys(t *testing.T) {
+	for _, tt := range testdata.PEMEncryptedKeys {
+		_, err := ParsePrivateKey(tt.PEMBytes)
+		missing, ok := err.(*PassphraseMissingError)
+		if !ok {
+			t.Errorf("%s: ParsePrivateKey returned %v, want PassphraseMissingError", tt.Name, err)
+			continue
+		}
+		if tt.IncludesPublicKey && missing.PublicKey == nil {
+			t.Errorf("%s: PassphraseMissingError without the public key", tt.Name)
+		}
+
+		s, err := ParsePrivateKeyWithPassphrase(tt.PEMBytes, []byte(tt.EncryptionKey))
+		if err != nil {
+			t.Errorf("%s: ParsePrivateKeyWithPassphrase: %v", tt.Name, err)
+			continue
+		}
+		if missing.PublicKey != nil && !bytes.Equal(missing.PublicKey.Marshal(), s.PublicKey().Marshal()) {
+			t.Errorf("%s: the embedded public key doesn't match the private key", tt.Name)
+		}
+
+		_, err = ParsePrivateKeyWithPassphrase(tt.PEMBytes, []byte("wrong"))
+		if err != x509.IncorrectPasswordError {
+			t.Errorf("%s: wrong passphrase returned %v, want %v", tt.Name, err, x509.IncorrectPasswordError)
+		}
+	}
+}
+
+func TestRSASHA2Signatures(t *testing.T) {
+	s, ok := testSigners["rsa-openssh-format"].(AlgorithmSigner)
+	if !ok {
+		t.Fatalf("RSA signer is not an AlgorithmSigner")
+	}
+	data := []byte("sign me")
+	for _, algo := range []string{KeyAlgoRSA, SigAlgoRSASHA2256, SigAlgoRSASHA2512} {
+		sig, err := s.SignWithAlgorithm(rand.Reader, data, algo)
+		if err != nil {
+			t.Errorf("SignWithAlgorithm(%s): %v", algo, err)
+			continue
+		}
+		if sig.Format != algo {
+			t.Errorf("got signature format %q, want %q", sig.Format, algo)
+		}
+		if err := s.PublicKey().Verify(data, sig); err != nil {
+			t.Errorf("Verify(%s): %v", algo, err)
+		}
+	}
+	if _, err := s.SignWithAlgorithm(rand.Reader, data, KeyAlgoED25519); err == nil {
+		t.Errorf("SignWithAlgorithm succeeded with a foreign algorithm")
+	}
+}
+
 func getTestKey() (PublicKey, string) {
 	k := testPublicKeys["rsa"]
 
@@ -12,6 +12,7 @@ import (
 	"io"
 	"math/big"
 	"reflect"
+	"sort"
 	"strconv"
 )
 
@@ -114,6 +115,57 @@ type serviceAcceptMsg struct {
 	Service string `sshtype:"6"`
 }
 
+// See RFC 8308, section 2.3.
+const msgExtInfo = 7
+
+type extInfoMsg struct {
+	NumExtensions uint32 `sshtype:"7"`
+	Payload       []byte `ssh:"rest"`
+}
+
+// marshalExtInfo serializes the extension name and value pairs of an
+// SSH_MSG_EXT_INFO message.
+func marshalExtInfo(extensions map[string][]byte) []byte {
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msg := extInfoMsg{NumExtensions: uint32(len(names))}
+	for _, name := range names {
+		for _, field := range [][]byte{[]byte(name), extensions[name]} {
+			buf := make([]byte, stringLength(len(field)))
+			marshalString(buf, field)
+			msg.Payload = append(msg.Payload, buf...)
+		}
+	}
+	return Marshal(&msg)
+}
+
+// parseExtInfo returns the extensions of an SSH_MSG_EXT_INFO message.
+func parseExtInfo(packet []byte) (map[string][]byte, error) {
+	var msg extInfoMsg
+	if err := Unmarshal(packet, &msg); err != nil {
+		return nil, err
+	}
+	extensions := make(map[string][]byte)
+	payload := msg.Payload
+	for i := uint32(0); i < msg.NumExtensions; i++ {
+		name, rest, ok := parseString(payload)
+		if !ok {
+			return nil, parseError(msgExtInfo)
+		}
+		value, rest, ok := parseString(rest)
+		if !ok {
+			return nil, parseError(msgExtInfo)
+		}
+		extensions[string(name)] = value
+		payload = rest
+	}
+	return extensions, nil
+}
+
 // See RFC 4252, section 5.
 const msgUserAuthRequest = 50
 
@@ -678,6 +730,8 @@ func decode(packet []byte) (interface{}, error) {
 		msg = new(serviceRequestMsg)
 	case msgServiceAccept:
 		msg = new(serviceAcceptMsg)
+	case msgExtInfo:
+		msg = new(extInfoMsg)
 	case msgKexInit:
 		msg = new(kexInitMsg)
 	case msgKexDHInit:
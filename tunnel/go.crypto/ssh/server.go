@@ -212,8 +212,10 @@ func (s *connection) serverHandshake(config *ServerConfig) (*Permissions, error)
 
 func isAcceptableAlgo(algo string) bool {
 	switch algo {
-	case KeyAlgoRSA, KeyAlgoDSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521,
-		CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01:
+	case KeyAlgoRSA, KeyAlgoDSA, KeyAlgoECDSA256, KeyAlgoECDSA384, KeyAlgoECDSA521, KeyAlgoED25519,
+		SigAlgoRSASHA2256, SigAlgoRSASHA2512,
+		CertAlgoRSAv01, CertAlgoDSAv01, CertAlgoECDSA256v01, CertAlgoECDSA384v01, CertAlgoECDSA521v01, CertAlgoED25519v01,
+		CertAlgoRSASHA2256v01, CertAlgoRSASHA2512v01:
 		return true
 	}
 	return false
@@ -329,6 +331,10 @@ userAuthLoop:
 			if err != nil {
 				return nil, err
 			}
+			if pubKey.Type() != keyTypeForAlgo(algo) {
+				authErr = fmt.Errorf("ssh: algorithm %q for key type %q", algo, pubKey.Type())
+				break
+			}
 
 			candidate, ok := cache.get(s.user, pubKeyData)
 			if !ok {
@@ -371,7 +377,8 @@ userAuthLoop:
 				// algorithm name that corresponds to algo with
 				// sig.Format.  This is usually the same, but
 				// for certs, the names differ.
-				if !isAcceptableAlgo(sig.Format) {
+				if !isAcceptableAlgo(sig.Format) || sig.Format != underlyingAlgo(algo) {
+					authErr = fmt.Errorf("ssh: signature %q not accepted for algorithm %q", sig.Format, algo)
 					break
 				}
 				signedData := buildDataSignedForAuth(s.transport.getSessionID(), userAuthReq, algoBytes, pubKeyData)
@@ -10,7 +10,7 @@ package test
 
 import (
 	"bytes"
-	"golang.org/x/crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
 	"errors"
 	"io"
 	"strings"
@@ -56,6 +56,45 @@ func TestHostKeyCheck(t *testing.T) {
 	}
 }
 
+func TestEd25519HostKeyAndAuth(t *testing.T) {
+	server := newServer(t)
+	defer server.Shutdown()
+
+	conf := clientConfig()
+	conf.Auth = []ssh.AuthMethod{ssh.PublicKeys(testSigners["ed25519"])}
+	conf.HostKeyAlgorithms = []string{ssh.KeyAlgoED25519}
+	conn := server.Dial(conf)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("session failed: %v", err)
+	}
+	defer session.Close()
+	if err := session.Run("true"); err != nil {
+		t.Fatalf("session failed: %v", err)
+	}
+}
+
+func TestRSASHA2Auth(t *testing.T) {
+	server := newServer(t)
+	defer server.Shutdown()
+
+	conf := clientConfig()
+	conf.Auth = []ssh.AuthMethod{ssh.PublicKeys(testSigners["rsa-openssh-format"])}
+	conn := server.Dial(conf)
+	defer conn.Close()
+
+	session, err := conn.NewSession()
+	if err != nil {
+		t.Fatalf("session failed: %v", err)
+	}
+	defer session.Close()
+	if err := session.Run("true"); err != nil {
+		t.Fatalf("session failed: %v", err)
+	}
+}
+
 func TestRunCommandStdin(t *testing.T) {
 	server := newServer(t)
 	defer server.Shutdown()
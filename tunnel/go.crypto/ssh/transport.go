@@ -11,7 +11,8 @@ import (
 )
 
 const (
-	gcmCipherID = "aes128-gcm@openssh.com"
+	gcmCipherID        = "aes128-gcm@openssh.com"
+	chacha20Poly1305ID = "chacha20-poly1305@openssh.com"
 )
 
 // packetConn represents a transport that implements packet based
@@ -196,15 +197,16 @@ var (
 // generateKeys generates key material for IV, MAC and encryption.
 func generateKeys(d direction, algs directionAlgorithms, kex *kexResult) (iv, key, macKey []byte) {
 	cipherMode := cipherModes[algs.Cipher]
-	macMode := macModes[algs.MAC]
-
 	iv = make([]byte, cipherMode.ivSize)
 	key = make([]byte, cipherMode.keySize)
-	macKey = make([]byte, macMode.keySize)
-
 	generateKeyMaterial(iv, d.ivTag, kex)
 	generateKeyMaterial(key, d.keyTag, kex)
-	generateKeyMaterial(macKey, d.macKeyTag, kex)
+
+	// no MAC is negotiated with AEAD ciphers
+	if macMode := macModes[algs.MAC]; macMode != nil {
+		macKey = make([]byte, macMode.keySize)
+		generateKeyMaterial(macKey, d.macKeyTag, kex)
+	}
 	return
 }
 
@@ -214,8 +216,11 @@ func generateKeys(d direction, algs directionAlgorithms, kex *kexResult) (iv, ke
 func newPacketCipher(d direction, algs directionAlgorithms, kex *kexResult) (packetCipher, error) {
 	iv, key, macKey := generateKeys(d, algs, kex)
 
-	if algs.Cipher == gcmCipherID {
+	switch algs.Cipher {
+	case gcmCipherID:
 		return newGCMCipher(iv, key, macKey)
+	case chacha20Poly1305ID:
+		return newChaCha20Cipher(key)
 	}
 
 	c := &streamPacketCipher{
@@ -0,0 +1,327 @@
+package tunnel
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/utils"
+)
+
+// delays between two attempts to reconnect, the last one is repeated
+var redialDelays = []time.Duration{
+	1 * time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// attempts to open a listener again on its port after a reconnection, the
+// old sshd session may still hold the port for a few seconds
+const relistenTries = 60
+
+var errClosed = errors.New("ssh connection closed")
+var errReconnecting = errors.New("ssh connection lost, reconnecting")
+
+// Conn is the ssh connection to the Kodi host. It sends keepalive@openssh.com
+// requests, and dials the host again when the connection is lost, so that
+// the streams survive a short network failure.
+type Conn struct {
+	conf *utils.Config
+	host *Host
+	// the ssh configuration of the hops is kept for the reconnections
+	hops []hop
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// nil while reconnecting
+	client *ssh.Client
+	// closed by watch when it gives up on client
+	lost   chan struct{}
+	closed bool
+}
+
+// Open dials host (see Dial) and watches the connection.
+func Open(conf *utils.Config, host *Host) (*Conn, error) {
+	hops, err := route(conf, host)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dialRoute(hops)
+	if err != nil {
+		return nil, err
+	}
+	return open(conf, host, hops, client), nil
+}
+
+// open watches client, connected through hops
+func open(conf *utils.Config, host *Host, hops []hop, client *ssh.Client) *Conn {
+	c := &Conn{conf: conf, host: host, hops: hops, client: client, lost: make(chan struct{})}
+	c.cond = sync.NewCond(&c.mu)
+	go c.watch(client, c.lost)
+	return c
+}
+
+// Dial opens a connection from the Kodi host to addr. It fails while the
+// ssh connection is being established again.
+func (c *Conn) Dial(network, addr string) (net.Conn, error) {
+	client, _, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+// current returns the ssh client and the channel closed when it is lost
+func (c *Conn) current() (*ssh.Client, <-chan struct{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, nil, errClosed
+	}
+	if c.client == nil {
+		return nil, nil, errReconnecting
+	}
+	return c.client, c.lost, nil
+}
+
+// Close closes the connection, it is not established again.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	client := c.client
+	c.closed = true
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	if client == nil {
+		return nil
+	}
+	return client.Close()
+}
+
+// next waits for an ssh client other than old, nil once closed
+func (c *Conn) next(old *ssh.Client) (*ssh.Client, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for !c.closed && (c.client == nil || c.client == old) {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return nil, nil
+	}
+	return c.client, c.lost
+}
+
+// watch sends the keepalives on client until it dies, closes lost, then
+// reconnects
+func (c *Conn) watch(client *ssh.Client, lost chan struct{}) {
+	dead := make(chan error, 1)
+	go func() {
+		dead <- client.Wait()
+	}()
+
+	var tick <-chan time.Time
+	if c.host.ServerAliveInterval > 0 {
+		ticker := time.NewTicker(c.host.ServerAliveInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	missed := 0
+loop:
+	for {
+		select {
+		case err := <-dead:
+			log.Println("ssh connection lost:", err)
+			break loop
+		case <-tick:
+			if keepalive(client, c.host.ServerAliveInterval) {
+				missed = 0
+				continue
+			}
+			missed++
+			if verbose {
+				log.Printf("ssh keepalive %d/%d unanswered", missed, c.host.ServerAliveCountMax)
+			}
+			if missed >= c.host.ServerAliveCountMax {
+				log.Printf("ssh server %s not responding", c.host.Address())
+				break loop
+			}
+		}
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	if !closed {
+		c.client = nil
+	}
+	c.mu.Unlock()
+	close(lost)
+	if closed {
+		return
+	}
+	// wakes up the listeners blocked in Accept
+	client.Close()
+
+	c.redial()
+}
+
+// keepalive tells if the server answers a keepalive@openssh.com request
+// within timeout. Servers answer unknown requests with a failure, that's
+// an answer too.
+func keepalive(client *ssh.Client, timeout time.Duration) bool {
+	answer := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		answer <- err
+	}()
+	select {
+	case err := <-answer:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// redial dials the host until it succeeds or the connection is closed
+func (c *Conn) redial() {
+	for i := 0; ; i++ {
+		delay := redialDelays[len(redialDelays)-1]
+		if i < len(redialDelays) {
+			delay = redialDelays[i]
+		}
+		time.Sleep(delay)
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+
+		log.Printf("Reconnecting to %s", c.host.Address())
+		client, err := dialRoute(c.hops)
+		if err != nil {
+			log.Println("Reconnection failed:", err)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			client.Close()
+			return
+		}
+		lost := make(chan struct{})
+		c.client, c.lost = client, lost
+		c.cond.Broadcast()
+		c.mu.Unlock()
+		log.Printf("Reconnected to %s", c.host.Address())
+		go c.watch(client, lost)
+		return
+	}
+}
+
+// Listen opens addr on the Kodi host. After a reconnection, the listener
+// is opened again on the same address, so that Kodi can resume the streams
+// (with range requests) on the same URL.
+func (c *Conn) Listen(network, addr string) (net.Listener, error) {
+	client, lost, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	l, err := client.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{conn: c, network: network, client: client, lost: lost, l: l}, nil
+}
+
+// listener follows the reconnections of its Conn
+type listener struct {
+	conn    *Conn
+	network string
+
+	mu     sync.Mutex
+	client *ssh.Client
+	lost   <-chan struct{}
+	l      net.Listener
+	closed bool
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		l.mu.Lock()
+		current, client, lost := l.l, l.client, l.lost
+		l.mu.Unlock()
+
+		c, err := current.Accept()
+		if err == nil {
+			return c, nil
+		}
+		l.mu.Lock()
+		closed := l.closed
+		l.mu.Unlock()
+		if closed {
+			return nil, err
+		}
+		// the remote listeners end (io.EOF) with the ssh connection, and
+		// watch gives it up; other errors are about the incoming connection
+		if err != io.EOF {
+			return nil, err
+		}
+		<-lost
+
+		if verbose {
+			log.Println("Remote listener lost, waiting for the reconnection:", err)
+		}
+		if err := l.reopen(client); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reopen opens the listener again on the client that replaces old
+func (l *listener) reopen(old *ssh.Client) error {
+	addr := l.Addr().String()
+	for {
+		client, lost := l.conn.next(old)
+		if client == nil {
+			return errClosed
+		}
+
+		for i := 1; ; i++ {
+			nl, err := client.Listen(l.network, addr)
+			if err == nil {
+				l.mu.Lock()
+				l.client, l.lost, l.l = client, lost, nl
+				l.mu.Unlock()
+				log.Println("Listening again on the target:", addr)
+				return nil
+			}
+			if i == relistenTries {
+				return err
+			}
+			time.Sleep(time.Second)
+			// the new connection is lost too
+			if current, _, _ := l.conn.current(); current != client {
+				break
+			}
+		}
+		old = client
+	}
+}
+
+// Close closes the listener, it is not opened again.
+func (l *listener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return l.l.Close()
+}
+
+func (l *listener) Addr() net.Addr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.l.Addr()
+}
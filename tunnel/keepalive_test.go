@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+)
+
+// connect opens a Conn to the fake server, without keepalives
+func (s *fakeServer) connect(t *testing.T) *Conn {
+	host, port, err := net.SplitHostPort(s.start(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Host{Alias: "kodi", HostName: host, User: "test"}
+	h.Port, _ = strconv.Atoi(port)
+	hops := []hop{{h, &ssh.ClientConfig{User: "test"}}}
+	client, err := dialRoute(hops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return open(nil, h, hops, client)
+}
+
+// waitFor calls f until it returns true, for 5 seconds at most
+func waitFor(f func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if f() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestListenerReconnect(t *testing.T) {
+	delays := redialDelays
+	redialDelays = []time.Duration{10 * time.Millisecond}
+	defer func() { redialDelays = delays }()
+
+	s := &fakeServer{bind: func(port uint32) (uint32, bool) { return port, true }}
+	c := s.connect(t)
+	defer c.Close()
+	l, err := c.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	// the network fails, the listener is requested again on the new
+	// connection
+	s.drop()
+	if !waitFor(func() bool { return len(s.ports()) == 2 }) {
+		t.Fatalf("requested ports %v, want the listener opened again", s.ports())
+	}
+	if ports := s.ports(); ports[1] != 8080 {
+		t.Errorf("requested ports %v, want [8080 8080]", ports)
+	}
+	select {
+	case err := <-accepted:
+		t.Fatalf("Accept returned during the reconnection: %v", err)
+	default:
+	}
+
+	// the forward is registered once the request is answered
+	if !waitFor(func() bool { return s.forward("127.0.0.1", 8080) == nil }) {
+		t.Fatal("forwarded connection refused after the reconnection")
+	}
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Errorf("Accept: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection accepted after the reconnection")
+	}
+	if addr := l.Addr().String(); addr != "127.0.0.1:8080" {
+		t.Errorf("listening on %s, want 127.0.0.1:8080", addr)
+	}
+}
+
+func TestListenerConnClosed(t *testing.T) {
+	s := &fakeServer{bind: func(port uint32) (uint32, bool) { return port, true }}
+	c := s.connect(t)
+	l, err := c.Listen("tcp", "127.0.0.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	c.Close()
+	select {
+	case err := <-accepted:
+		if err == nil {
+			t.Error("Accept succeeded on a closed connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept still blocked once the connection is closed")
+	}
+	if _, err := c.Listen("tcp", "127.0.0.1:8081"); err != errClosed {
+		t.Errorf("Listen on a closed connection: got %v, want %v", err, errClosed)
+	}
+}
@@ -0,0 +1,429 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+)
+
+// host key checking modes
+const (
+	// refuse unknown and changed host keys
+	HOSTKEY_STRICT = "strict"
+	// learn unknown host keys, refuse changed ones
+	HOSTKEY_ACCEPT_NEW = "accept-new"
+	// accept any host key
+	HOSTKEY_OFF = "off"
+)
+
+// system wide known hosts file, never written
+const GLOBAL_KNOWN_HOSTS = "/etc/ssh/ssh_known_hosts"
+
+// one key line of a known_hosts file
+type knownHost struct {
+	// "" , "@cert-authority" or "@revoked"
+	marker   string
+	patterns []string
+	key      ssh.PublicKey
+	file     string
+	line     int
+}
+
+// KnownHosts checks host keys against OpenSSH known_hosts files.
+type KnownHosts struct {
+	// user file, where new keys are added
+	File string
+
+	// one of HOSTKEY_STRICT, HOSTKEY_ACCEPT_NEW or HOSTKEY_OFF
+	Mode string
+
+	hosts []knownHost
+}
+
+// DefaultKnownHostsFile returns ~/.ssh/known_hosts.
+func DefaultKnownHostsFile() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(u.HomeDir, ".ssh", "known_hosts")
+}
+
+// LoadKnownHosts reads the user known hosts file (it may not exist yet)
+// and the optional extra files. Unparsable lines are ignored, as OpenSSH does.
+func LoadKnownHosts(mode, file string, extra ...string) (*KnownHosts, error) {
+	switch mode {
+	case HOSTKEY_STRICT, HOSTKEY_ACCEPT_NEW, HOSTKEY_OFF:
+	default:
+		return nil, fmt.Errorf("unknown host key checking mode %q", mode)
+	}
+
+	k := &KnownHosts{File: file, Mode: mode}
+	for _, f := range append([]string{file}, extra...) {
+		if f == "" {
+			continue
+		}
+		if err := k.read(f); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+func (k *KnownHosts) read(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		h, err := parseKnownHost(scanner.Text())
+		if err != nil {
+			if verbose {
+				log.Printf("%s:%d: %v", file, n, err)
+			}
+			continue
+		}
+		if h == nil {
+			continue
+		}
+		h.file, h.line = file, n
+		k.hosts = append(k.hosts, *h)
+	}
+	return scanner.Err()
+}
+
+// parseKnownHost parses "[marker] patterns keytype base64 [comment]",
+// it returns nil for blank lines and comments.
+func parseKnownHost(line string) (*knownHost, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil, nil
+	}
+
+	h := &knownHost{}
+	if strings.HasPrefix(fields[0], "@") {
+		h.marker = fields[0]
+		fields = fields[1:]
+		if h.marker != "@cert-authority" && h.marker != "@revoked" {
+			return nil, fmt.Errorf("unknown marker %s", h.marker)
+		}
+	}
+	if len(fields) < 3 {
+		return nil, errors.New("missing fields")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	if h.key, err = ssh.ParsePublicKey(raw); err != nil {
+		return nil, err
+	}
+	if h.key.Type() != fields[1] {
+		return nil, fmt.Errorf("key type %s doesn't match %s", h.key.Type(), fields[1])
+	}
+	h.patterns = strings.Split(fields[0], ",")
+	return h, nil
+}
+
+// match tells if one of names matches the host patterns, a negated
+// pattern that matches rejects the whole line
+func (h *knownHost) match(names []string) bool {
+	found := false
+	for _, p := range h.patterns {
+		negate := strings.HasPrefix(p, "!")
+		if negate {
+			p = p[1:]
+		}
+		for _, name := range names {
+			if !matchPattern(p, name) {
+				continue
+			}
+			if negate {
+				return false
+			}
+			found = true
+		}
+	}
+	return found
+}
+
+// matchPattern matches a single known_hosts pattern: hashed "|1|salt|hash",
+// or a host name with * and ? wildcards
+func matchPattern(pattern, name string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(name))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	return wildcard(strings.ToLower(pattern), strings.ToLower(name))
+}
+
+func wildcard(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if wildcard(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 {
+				return false
+			}
+		default:
+			if len(name) == 0 || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// knownHostName gives the known_hosts form of host and port: "host" for
+// the standard port, "[host]:port" otherwise
+func knownHostName(host, port string) string {
+	if port == "" || port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// Fingerprint returns the OpenSSH SHA256 fingerprint of key.
+func Fingerprint(key ssh.PublicKey) string {
+	sum := sha256.Sum256(key.Marshal())
+	return "SHA256:" + strings.TrimRight(base64.StdEncoding.EncodeToString(sum[:]), "=")
+}
+
+func sameKey(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// HostKeyCallback returns the function to set as ssh.ClientConfig.HostKeyCallback.
+func (k *KnownHosts) HostKeyCallback() func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	return k.check
+}
+
+func (k *KnownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if k.Mode == HOSTKEY_OFF {
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		host, port = hostname, ""
+	}
+	name := knownHostName(host, port)
+	names := []string{name}
+	if tcp, ok := remote.(*net.TCPAddr); ok {
+		if ip := knownHostName(tcp.IP.String(), strconv.Itoa(tcp.Port)); ip != name {
+			names = append(names, ip)
+		}
+	}
+
+	if err := k.revoked(name, key); err != nil {
+		return err
+	}
+
+	// CA signed host key
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if err := k.revoked(name, cert.SignatureKey); err != nil {
+			return err
+		}
+		checker := &ssh.CertChecker{
+			IsAuthority: func(auth ssh.PublicKey) bool {
+				for _, h := range k.hosts {
+					if h.marker == "@cert-authority" && sameKey(h.key, auth) && h.match(names) {
+						return true
+					}
+				}
+				return false
+			},
+		}
+		if err := checker.CheckHostKey(host, remote, key); err == nil {
+			return nil
+		} else if verbose {
+			log.Println("Host certificate not accepted:", err)
+		}
+		// fall back to the plain key, as OpenSSH
+		key = cert.Key
+		if err := k.revoked(name, key); err != nil {
+			return err
+		}
+	}
+
+	// a key of another type is not a change, the host is unknown for that
+	// type (see HostKeyAlgorithms)
+	var changed *knownHost
+	for i, h := range k.hosts {
+		if h.marker != "" || !h.match(names) {
+			continue
+		}
+		if sameKey(h.key, key) {
+			return nil
+		}
+		if h.key.Type() == key.Type() && changed == nil {
+			changed = &k.hosts[i]
+		}
+	}
+
+	if changed != nil {
+		return fmt.Errorf("REMOTE HOST IDENTIFICATION HAS CHANGED for %s!\n"+
+			"The %s key sent by the remote host has fingerprint %s,\n"+
+			"the %s key known in %s:%d has fingerprint %s.\n"+
+			"Someone could be eavesdropping on you (man-in-the-middle attack), or the host key has just been changed.\n"+
+			"If it is legitimate, remove the line from %s.",
+			name, key.Type(), Fingerprint(key),
+			changed.key.Type(), changed.file, changed.line, Fingerprint(changed.key),
+			changed.file)
+	}
+
+	if k.Mode == HOSTKEY_STRICT {
+		return fmt.Errorf("no %s host key is known for %s (fingerprint %s) and host key checking is strict.\n"+
+			"Connect once with -hostkeycheck=accept-new, or add the key to %s.",
+			key.Type(), name, Fingerprint(key), k.File)
+	}
+
+	if err := k.add(name, key); err != nil {
+		return err
+	}
+	log.Printf("Permanently added %s key %s for %s to %s", key.Type(), Fingerprint(key), name, k.File)
+	return nil
+}
+
+// host key algorithms of the vendored ssh package, in its order:
+// certificates, then plain keys
+var certHostKeyAlgos = []string{
+	ssh.CertAlgoRSASHA2512v01, ssh.CertAlgoRSASHA2256v01,
+	ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+}
+var defaultHostKeyAlgos = append(append([]string{}, certHostKeyAlgos...),
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoED25519,
+)
+
+// algorithms a key of type keytype signs with, the best first
+func keyAlgos(keytype string) []string {
+	if keytype == ssh.KeyAlgoRSA {
+		return []string{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.KeyAlgoRSA}
+	}
+	return []string{keytype}
+}
+
+// HostKeyAlgorithms returns the host key algorithms to set in
+// ssh.ClientConfig for address (host:port): certificates if a
+// @cert-authority line matches the host, then the types of the keys known
+// for it, then the others. Else the server may send a key of a type not
+// known yet, as OpenSSH avoids. nil if nothing is known about the host.
+func (k *KnownHosts) HostKeyAlgorithms(address string) []string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, ""
+	}
+	names := []string{knownHostName(host, port)}
+
+	algos := []string{}
+	add := func(list ...string) {
+		for _, algo := range list {
+			if !contains(algos, algo) {
+				algos = append(algos, algo)
+			}
+		}
+	}
+	for _, h := range k.hosts {
+		if h.marker == "@cert-authority" && h.match(names) {
+			add(certHostKeyAlgos...)
+		}
+	}
+	for _, h := range k.hosts {
+		if h.marker == "" && h.match(names) {
+			add(keyAlgos(h.key.Type())...)
+		}
+	}
+	if len(algos) == 0 {
+		return nil
+	}
+	add(defaultHostKeyAlgos...)
+	return algos
+}
+
+// revoked returns an error if key is marked as revoked
+func (k *KnownHosts) revoked(name string, key ssh.PublicKey) error {
+	for _, h := range k.hosts {
+		if h.marker == "@revoked" && sameKey(h.key, key) {
+			return fmt.Errorf("%s key %s of %s is marked as revoked in %s:%d",
+				key.Type(), Fingerprint(key), name, h.file, h.line)
+		}
+	}
+	return nil
+}
+
+// add appends key for name to the user known hosts file
+func (k *KnownHosts) add(name string, key ssh.PublicKey) error {
+	if k.File == "" {
+		return errors.New("no known hosts file to add the host key to")
+	}
+	if err := os.MkdirAll(filepath.Dir(k.File), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(k.File, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	line := name + " " + string(ssh.MarshalAuthorizedKey(key))
+	// don't glue the new entry to a last line without newline
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		line = "\n" + line
+	}
+	if _, err := f.WriteString(line); err != nil {
+		return err
+	}
+
+	n := bytes.Count(content, []byte("\n")) + strings.Count(line, "\n")
+	k.hosts = append(k.hosts, knownHost{patterns: []string{name}, key: key, file: k.File, line: n})
+	return nil
+}
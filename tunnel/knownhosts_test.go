@@ -0,0 +1,253 @@
+package tunnel
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+)
+
+func newEd25519Key(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func newECDSAKey(t *testing.T) ssh.Signer {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// knownLine returns the known_hosts line of key for patterns
+func knownLine(patterns string, key ssh.PublicKey) string {
+	return patterns + " " + string(ssh.MarshalAuthorizedKey(key))
+}
+
+// hashed returns the hashed known_hosts form of name
+func hashed(name string) string {
+	salt := make([]byte, sha1.Size)
+	rand.Read(salt)
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(name))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// knownHosts writes content in a temporary known_hosts file and loads it
+func knownHosts(t *testing.T, mode, content string) *KnownHosts {
+	dir, err := ioutil.TempDir("", "idok-known-hosts-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "known_hosts")
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	k, err := LoadKnownHosts(mode, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+var kodiAddr = &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 22}
+
+func TestKnownHostsCheck(t *testing.T) {
+	hostkey := newEd25519Key(t).PublicKey()
+	other := newEd25519Key(t).PublicKey()
+	ecdsakey := newECDSAKey(t).PublicKey()
+
+	tests := []struct {
+		name     string
+		content  string
+		hostname string
+		key      ssh.PublicKey
+		// error substring, "" if accepted
+		want string
+	}{
+		{"known", knownLine("kodi", hostkey), "kodi:22", hostkey, ""},
+		{"known by ip", knownLine("192.168.1.10", hostkey), "kodi:22", hostkey, ""},
+		{"several patterns", knownLine("nas,kodi,tv", hostkey), "kodi:22", hostkey, ""},
+		{"wildcard", knownLine("192.168.1.*", hostkey), "kodi:22", hostkey, ""},
+		{"case insensitive", knownLine("KODI", hostkey), "kodi:22", hostkey, ""},
+		{"hashed", knownLine(hashed("kodi"), hostkey), "kodi:22", hostkey, ""},
+		{"hashed port", knownLine(hashed("[kodi]:2222"), hostkey), "kodi:2222", hostkey, ""},
+		{"port", knownLine("[kodi]:2222", hostkey), "kodi:2222", hostkey, ""},
+		{"standard port only", knownLine("kodi", hostkey), "kodi:2222", hostkey, "no ssh-ed25519 host key is known for [kodi]:2222"},
+		{"other port only", knownLine("[kodi]:2222", hostkey), "kodi:22", hostkey, "no ssh-ed25519 host key is known"},
+		{"negated pattern", knownLine("kodi,!kodi", hostkey), "kodi:22", hostkey, "no ssh-ed25519 host key is known"},
+		{"negated wildcard", knownLine("*,!192.168.1.*", hostkey), "kodi:22", hostkey, "no ssh-ed25519 host key is known"},
+		{"other host", knownLine("nas", hostkey), "kodi:22", hostkey, "no ssh-ed25519 host key is known"},
+		{"comments and bad lines", "# kodi\n\nkodi ssh-ed25519 AAAA\n@unknown kodi x y\n" + knownLine("kodi", hostkey), "kodi:22", hostkey, ""},
+		{"changed", knownLine("kodi", other), "kodi:22", hostkey, "REMOTE HOST IDENTIFICATION HAS CHANGED"},
+		{"changed, hashed", knownLine(hashed("kodi"), other), "kodi:22", hostkey, "HAS CHANGED"},
+		{"changed among others", knownLine("kodi", ecdsakey) + knownLine("kodi", other), "kodi:22", hostkey, "HAS CHANGED"},
+		{"other key type", knownLine("kodi", ecdsakey), "kodi:22", hostkey, "no ssh-ed25519 host key is known"},
+		{"revoked", "@revoked * " + string(ssh.MarshalAuthorizedKey(hostkey)) + knownLine("kodi", hostkey), "kodi:22", hostkey, "revoked"},
+		{"revoked other key", "@revoked * " + string(ssh.MarshalAuthorizedKey(other)) + knownLine("kodi", hostkey), "kodi:22", hostkey, ""},
+	}
+	for _, test := range tests {
+		k := knownHosts(t, HOSTKEY_STRICT, test.content)
+		err := k.HostKeyCallback()(test.hostname, kodiAddr, test.key)
+		if test.want == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+
+	// anything goes
+	k := knownHosts(t, HOSTKEY_OFF, knownLine("kodi", other))
+	if err := k.HostKeyCallback()("kodi:22", kodiAddr, hostkey); err != nil {
+		t.Errorf("off: %v", err)
+	}
+}
+
+func TestKnownHostsCertAuthority(t *testing.T) {
+	ca := newEd25519Key(t)
+	hostkey := newECDSAKey(t)
+	cert := &ssh.Certificate{
+		Key:             hostkey.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"kodi"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	caline := "@cert-authority *.lan,kodi " + string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+
+	k := knownHosts(t, HOSTKEY_STRICT, caline)
+	if err := k.HostKeyCallback()("kodi:22", kodiAddr, cert); err != nil {
+		t.Errorf("signed host key: %v", err)
+	}
+	// the authority doesn't sign for that host, the plain key is unknown
+	if err := k.HostKeyCallback()("nas:22", &net.TCPAddr{IP: net.ParseIP("192.168.1.11"), Port: 22}, cert); err == nil {
+		t.Error("certificate of another host accepted")
+	}
+	// a revoked authority
+	k = knownHosts(t, HOSTKEY_STRICT, caline+"@revoked * "+string(ssh.MarshalAuthorizedKey(ca.PublicKey())))
+	if err := k.HostKeyCallback()("kodi:22", kodiAddr, cert); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("revoked authority: got %v", err)
+	}
+	// certificates first
+	k = knownHosts(t, HOSTKEY_STRICT, caline)
+	if algos := k.HostKeyAlgorithms("kodi:22"); len(algos) == 0 || !strings.HasSuffix(algos[0], "-cert-v01@openssh.com") {
+		t.Errorf("host key algorithms %v", algos)
+	}
+}
+
+func TestKnownHostsAcceptNew(t *testing.T) {
+	hostkey := newEd25519Key(t).PublicKey()
+	ecdsakey := newECDSAKey(t).PublicKey()
+	other := newEd25519Key(t).PublicKey()
+
+	// the last line has no newline
+	k := knownHosts(t, HOSTKEY_ACCEPT_NEW, strings.TrimSuffix(knownLine("nas", other), "\n"))
+	check := k.HostKeyCallback()
+	if err := check("kodi:2222", kodiAddr, hostkey); err != nil {
+		t.Fatal(err)
+	}
+	// a new key type for a known host is added too
+	if err := check("nas:22", &net.TCPAddr{IP: net.ParseIP("192.168.1.11"), Port: 22}, ecdsakey); err != nil {
+		t.Fatal(err)
+	}
+	// still a change for a known type
+	if err := check("nas:22", &net.TCPAddr{IP: net.ParseIP("192.168.1.11"), Port: 22}, hostkey); err == nil ||
+		!strings.Contains(err.Error(), "HAS CHANGED") {
+		t.Errorf("changed key: got %v", err)
+	}
+
+	content, err := ioutil.ReadFile(k.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := knownLine("nas", other) + knownLine("[kodi]:2222", hostkey) + knownLine("nas", ecdsakey)
+	if string(content) != want {
+		t.Errorf("known_hosts:\n%s\nwant:\n%s", content, want)
+	}
+
+	// added keys are known, in memory and once read again
+	if err := check("kodi:2222", kodiAddr, hostkey); err != nil {
+		t.Error(err)
+	}
+	reloaded, err := LoadKnownHosts(HOSTKEY_STRICT, k.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloaded.HostKeyCallback()("kodi:2222", kodiAddr, hostkey); err != nil {
+		t.Error(err)
+	}
+
+	// nothing is written in strict mode
+	strict := knownHosts(t, HOSTKEY_STRICT, "")
+	if err := strict.HostKeyCallback()("kodi:22", kodiAddr, hostkey); err == nil {
+		t.Error("strict: unknown host accepted")
+	}
+	if content, _ := ioutil.ReadFile(strict.File); len(content) != 0 {
+		t.Errorf("strict: known_hosts written: %s", content)
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsakey, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edkey := newEd25519Key(t).PublicKey()
+	k := knownHosts(t, HOSTKEY_STRICT, knownLine("kodi", edkey)+knownLine("[nas]:2222", rsakey)+knownLine("nas", edkey))
+
+	tests := []struct {
+		address string
+		first   []string
+	}{
+		{"kodi:22", []string{ssh.KeyAlgoED25519}},
+		{"nas:2222", []string{ssh.SigAlgoRSASHA2512, ssh.SigAlgoRSASHA2256, ssh.KeyAlgoRSA}},
+		{"unknown:22", nil},
+	}
+	for _, test := range tests {
+		algos := k.HostKeyAlgorithms(test.address)
+		if test.first == nil {
+			if algos != nil {
+				t.Errorf("%s: got %v, want the default algorithms", test.address, algos)
+			}
+			continue
+		}
+		if len(algos) != len(defaultHostKeyAlgos) {
+			t.Errorf("%s: %d algorithms, want %d", test.address, len(algos), len(defaultHostKeyAlgos))
+		}
+		if strings.Join(algos[:len(test.first)], " ") != strings.Join(test.first, " ") {
+			t.Errorf("%s: got %v, want %v first", test.address, algos, test.first)
+		}
+	}
+}
@@ -1,84 +1,55 @@
 package tunnel
 
 import (
-	"fmt"
-	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/asserver"
 	"github.com/sdbbs/idok/utils"
-	"io"
 	"log"
-	"math/rand"
 	"net/http"
-	"os"
-	"path/filepath"
-	"time"
 )
 
-// SshForward digs a tunnel to xbmc/kodi, then open a port and bind socket to
-// the local http server
-func SshHTTPForward(config *ssh.ClientConfig, file, dir string) {
+var verbose bool
 
-	// Setup sshClientConn (type *ssh.ClientConn)
-	sshClientConn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", utils.GlobalConfig.Target, utils.GlobalConfig.Sshport), config)
-	if err != nil {
-		log.Fatal(err)
+func SetVerbose(inbool bool) {
+	verbose = inbool
+	if verbose {
+		log.Println(" tunnel verbose: ", verbose)
 	}
+}
 
-	// Setup sshConn (type net.Conn)
-	// Because dropbear doesn't accept :0 port to open random port
-	// we do the randomisation ourself
-	rand.Seed(int64(time.Now().Nanosecond()))
-	dport := 10000 + rand.Intn(9999)
-	tries := 0
-	sshConn, err := sshClientConn.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dport))
-	for err != nil && tries < 500 {
-		dport = 10000 + rand.Intn(9999)
-		sshConn, err = sshClientConn.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dport))
-		tries++
+// SshForward opens a port on xbmc/kodi through the ssh connection (see Open),
+// then bind socket to the local http server that serves entries. The port is
+// opened again when the connection is re-established.
+func SshHTTPForward(sshClientConn *Conn, entries []utils.PlaylistEntry) {
+	sshConn, err := ListenRemote(sshClientConn, sshClientConn.conf.Remoteport)
+	if err != nil {
+		utils.Fatal(err)
 	}
-	log.Println("Listening port on raspberry: ", dport)
+	dport := remotePort(sshConn)
 
-	// send xbmc the file query
-	go utils.Send("http", "localhost", file, dport)
+	// send xbmc the files query, and quit when media ends
+	go func() {
+		utils.QuitOnEnd(asserver.SendEntries("http", "localhost", entries, dport))
+	}()
 
-	// now serve file
-	fullpath := filepath.Join(dir, file)
-	http.Serve(sshConn, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, fullpath)
-	}))
+	// now serve files
+	utils.Fatal(http.Serve(sshConn, asserver.PlaylistHandler(entries)))
 }
 
-// SshForwardStdin reads stdin and stream this to distant socket
-// through SSH tunnel
-func SshForwardStdin(config *ssh.ClientConfig) {
-
-	// Setup sshClientConn (type *ssh.ClientConn)
-	sshClientConn, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", utils.GlobalConfig.Target, utils.GlobalConfig.Sshport), config)
+// SshForwardStdin streams stdin over http through the ssh tunnel, as
+// asserver.HTTPServeStdin does on a local port: name is the fake file name
+// of the stream, see asserver.StdinName.
+func SshForwardStdin(sshClientConn *Conn, name string) {
+	sshConn, err := ListenRemote(sshClientConn, sshClientConn.conf.Remoteport)
 	if err != nil {
-		log.Fatal(err)
+		utils.Fatal(err)
 	}
 
-	// Setup sshConn (type net.Conn)
-	// Because dropbear doesn't accept :0 port to open random port
-	// we do the randomisation ourself
-	rand.Seed(int64(time.Now().Nanosecond()))
-	dport := 10000 + rand.Intn(9999)
-	tries := 0
-	sshConn, err := sshClientConn.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dport))
-	for err != nil && tries < 500 {
-		dport = 10000 + rand.Intn(9999)
-		sshConn, err = sshClientConn.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", dport))
-		tries++
+	// delay sending command to Kodi until first bytes arrive for the stream
+	if err := asserver.WaitStdin(); err != nil {
+		utils.Fatal(err)
 	}
-	log.Println("Listening port on the target: ", dport)
+	name = asserver.StdinName(name)
+	asserver.SendStdin("127.0.0.1", name, remotePort(sshConn))
 
-	// send xbmc the file query
-	go utils.Send("tcp", "127.0.0.1", "", dport)
-	if err != nil {
-		log.Fatal(err)
-	}
-	c, err := sshConn.Accept()
-	if err != nil {
-		log.Fatal(err)
-	}
-	go io.Copy(c, os.Stdin)
+	utils.Fatal(http.Serve(sshConn, asserver.StdinHandler(name)))
 }
@@ -1,17 +1,52 @@
 package tunnel
 
 import (
+	"bytes"
+	"errors"
 	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
 	"io/ioutil"
 	"log"
 )
 
-// Parse local ssh private key to get signer
-func parseSSHKeys(keyfile string) (ssh.Signer, error) {
+var errKeyInAgent = errors.New("key is in ssh-agent")
+
+// Parse local ssh private key to get signer. The passphrase of protected
+// keys is asked on the terminal, unless ssh-agent (agentsigners) holds them.
+func parseSSHKeys(keyfile string, agentsigners []ssh.Signer) (ssh.Signer, error) {
 	content, err := ioutil.ReadFile(keyfile)
-	private, err := ssh.ParsePrivateKey(content)
 	if err != nil {
-		log.Println("Unable to parse private key")
+		return nil, err
+	}
+	private, err := ssh.ParsePrivateKey(content)
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok {
+		public := missing.PublicKey
+		if public == nil {
+			public, _ = identityKey(keyfile)
+		}
+		if public != nil && hasKey(agentsigners, public) {
+			if verbose {
+				log.Println("Private key", keyfile, "is passphrase protected, using it from ssh-agent")
+			}
+			return nil, errKeyInAgent
+		}
+		if !canPrompt() {
+			log.Println("Private key", keyfile, "is passphrase protected, add it to ssh-agent (ssh-add) to use it")
+			return nil, err
+		}
+		if private, err = decryptKey(keyfile, content); err != nil {
+			log.Println("Private key", keyfile, "not used:", err)
+		}
+	} else if err != nil {
+		log.Println("Unable to parse private key", keyfile+":", err)
 	}
 	return private, err
 }
+
+func hasKey(signers []ssh.Signer, key ssh.PublicKey) bool {
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sdbbs/idok/utils"
+)
+
+// ports tried when the ssh server can't choose the port itself: dropbear
+// refuses to listen on port 0
+const (
+	REMOTE_PORT_MIN = 10000
+	REMOTE_PORT_MAX = 19999
+)
+
+// maximum number of ports tried in a range
+const maxPortTries = 500
+
+var portRandom = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// remoteListener is an ssh.Client or a Conn
+type remoteListener interface {
+	Listen(network, addr string) (net.Listener, error)
+}
+
+// ListenRemote opens a port on 127.0.0.1 of the Kodi host. ports is "" to
+// let the ssh server choose it (a random one of REMOTE_PORT_MIN-REMOTE_PORT_MAX
+// if the server refuses), a port or a range "N-M" whose ports are tried in
+// random order.
+func ListenRemote(client remoteListener, ports string) (net.Listener, error) {
+	min, max, err := utils.PortRange(ports)
+	if err != nil {
+		return nil, err
+	}
+
+	if min == 0 {
+		// old OpenSSH servers are handled by the ssh package
+		l, err := client.Listen("tcp", "127.0.0.1:0")
+		if err == nil {
+			if remotePort(l) != 0 {
+				log.Println("Listening port on the target:", remotePort(l))
+				return l, nil
+			}
+			l.Close()
+		}
+		if verbose {
+			log.Printf("The ssh server doesn't choose the port (%v), trying %d-%d", err, REMOTE_PORT_MIN, REMOTE_PORT_MAX)
+		}
+		min, max = REMOTE_PORT_MIN, REMOTE_PORT_MAX
+	}
+
+	candidates := portRandom.Perm(max - min + 1)
+	if len(candidates) > maxPortTries {
+		candidates = candidates[:maxPortTries]
+	}
+	for _, i := range candidates {
+		var l net.Listener
+		port := min + i
+		if l, err = client.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+			log.Println("Listening port on the target:", port)
+			return l, nil
+		}
+		if verbose {
+			log.Printf("Port %d refused on the target: %v", port, err)
+		}
+	}
+	if min == max {
+		return nil, fmt.Errorf("unable to listen on port %d of the target: %v", min, err)
+	}
+	return nil, fmt.Errorf("unable to listen on the target, %d ports of %d-%d tried: %v", len(candidates), min, max, err)
+}
+
+func remotePort(l net.Listener) int {
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
@@ -0,0 +1,261 @@
+package tunnel
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+)
+
+// fake ssh server answering tcpip-forward requests with bind, that returns
+// the port really opened and false to refuse. Requested ports are recorded.
+type fakeServer struct {
+	bind func(port uint32) (uint32, bool)
+
+	mu        sync.Mutex
+	requested []uint32
+	// connections, the last one is the current
+	conns    []net.Conn
+	sshconns []*ssh.ServerConn
+}
+
+func (s *fakeServer) ports() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint32{}, s.requested...)
+}
+
+// start starts the server and returns its address, it accepts connections
+// until the end of the test
+func (s *fakeServer) start(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		l.Close()
+		s.drop()
+	})
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c, config)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (s *fakeServer) serve(c net.Conn, config *ssh.ServerConfig) {
+	sshconn, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, c)
+	s.sshconns = append(s.sshconns, sshconn)
+	s.mu.Unlock()
+	go func() {
+		for ch := range chans {
+			ch.Reject(ssh.Prohibited, "no channel")
+		}
+	}()
+	for r := range reqs {
+		if r.Type != "tcpip-forward" {
+			r.Reply(false, nil)
+			continue
+		}
+		m := struct {
+			Addr string
+			Port uint32
+		}{}
+		ssh.Unmarshal(r.Payload, &m)
+		s.mu.Lock()
+		s.requested = append(s.requested, m.Port)
+		s.mu.Unlock()
+
+		port, ok := s.bind(m.Port)
+		if ok && m.Port == 0 {
+			r.Reply(true, ssh.Marshal(&struct{ Port uint32 }{port}))
+		} else {
+			r.Reply(ok, nil)
+		}
+	}
+}
+
+// drop closes the connections, as a network failure would
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+}
+
+// forward opens a forwarded-tcpip channel on the current connection, as a
+// client connecting to the forwarded port
+func (s *fakeServer) forward(addr string, port uint32) error {
+	s.mu.Lock()
+	sshconn := s.sshconns[len(s.sshconns)-1]
+	s.mu.Unlock()
+	payload := ssh.Marshal(&struct {
+		Addr       string
+		Port       uint32
+		OriginAddr string
+		OriginPort uint32
+	}{addr, port, "127.0.0.1", 50000})
+	ch, reqs, err := sshconn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		return err
+	}
+	go ssh.DiscardRequests(reqs)
+	ch.Close()
+	return nil
+}
+
+// dial starts the server and returns a client connected to it
+func (s *fakeServer) dial(t *testing.T) *ssh.Client {
+	client, err := ssh.Dial("tcp", s.start(t), &ssh.ClientConfig{User: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestListenRemoteServerChooses(t *testing.T) {
+	s := &fakeServer{bind: func(port uint32) (uint32, bool) {
+		if port == 0 {
+			return 43210, true
+		}
+		return port, true
+	}}
+	client := s.dial(t)
+	defer client.Close()
+
+	l, err := ListenRemote(client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := remotePort(l); p != 43210 {
+		t.Errorf("got port %d, want the one of the server, 43210", p)
+	}
+	if ports := s.ports(); len(ports) != 1 || ports[0] != 0 {
+		t.Errorf("requested ports %v, want [0]", ports)
+	}
+}
+
+// dropbear refuses port 0
+func TestListenRemoteFallbackRange(t *testing.T) {
+	s := &fakeServer{bind: func(port uint32) (uint32, bool) {
+		// a few ports of the range are taken
+		return port, port != 0 && port%3 == 0
+	}}
+	client := s.dial(t)
+	defer client.Close()
+
+	l, err := ListenRemote(client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := remotePort(l)
+	if p < REMOTE_PORT_MIN || p > REMOTE_PORT_MAX || p%3 != 0 {
+		t.Errorf("got port %d, want a free port of %d-%d", p, REMOTE_PORT_MIN, REMOTE_PORT_MAX)
+	}
+}
+
+func TestListenRemoteRange(t *testing.T) {
+	s := &fakeServer{bind: func(port uint32) (uint32, bool) {
+		return port, port == 20007
+	}}
+	client := s.dial(t)
+	defer client.Close()
+
+	l, err := ListenRemote(client, "20000-20009")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := remotePort(l); p != 20007 {
+		t.Errorf("got port %d, want 20007", p)
+	}
+	seen := map[uint32]bool{}
+	for _, p := range s.ports() {
+		if p < 20000 || p > 20009 || seen[p] {
+			t.Errorf("port %d requested out of the range or twice", p)
+		}
+		seen[p] = true
+	}
+}
+
+func TestListenRemoteFixedPort(t *testing.T) {
+	s := &fakeServer{bind: func(port uint32) (uint32, bool) {
+		return port, true
+	}}
+	client := s.dial(t)
+	defer client.Close()
+
+	l, err := ListenRemote(client, "12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := remotePort(l); p != 12345 {
+		t.Errorf("got port %d, want 12345", p)
+	}
+}
+
+func TestListenRemoteRefused(t *testing.T) {
+	for _, ports := range []string{"", "12345", "20000-20009"} {
+		s := &fakeServer{bind: func(port uint32) (uint32, bool) {
+			return 0, false
+		}}
+		client := s.dial(t)
+
+		l, err := ListenRemote(client, ports)
+		if err == nil || l != nil {
+			t.Errorf("%q: got listener %v, error %v, want an error", ports, l, err)
+		}
+		requested := s.ports()
+		switch ports {
+		case "":
+			// port 0, then the default range
+			if len(requested) != 1+maxPortTries {
+				t.Errorf("%q: %d ports requested, want %d", ports, len(requested), 1+maxPortTries)
+			}
+		case "12345":
+			if len(requested) != 1 || !strings.Contains(err.Error(), "12345") {
+				t.Errorf("%q: ports %v requested, error %v", ports, requested, err)
+			}
+		case "20000-20009":
+			if len(requested) != 10 {
+				t.Errorf("%q: %d ports requested, want each port of the range once", ports, len(requested))
+			}
+		}
+		client.Close()
+	}
+}
+
+func TestListenRemoteBadPorts(t *testing.T) {
+	for _, ports := range []string{"abc", "0", "70000", "20-10", "10-", "-10"} {
+		if _, err := ListenRemote(nil, ports); err == nil {
+			t.Errorf("%q accepted", ports)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package tunnel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh"
+	"github.com/sdbbs/idok/tunnel/go.crypto/ssh/terminal"
+)
+
+// passphrase attempts for a key, as OpenSSH
+const passphraseTries = 3
+
+// decrypted keys by file, kept for the reconnections (see Conn): the user
+// may be away from the terminal by then
+var decryptedKeys = struct {
+	sync.Mutex
+	keys map[string]ssh.Signer
+}{keys: map[string]ssh.Signer{}}
+
+// openTTY returns the terminal to prompt on: stdin if it's one, else the
+// controlling terminal (stdin is the stream with -stdin)
+func openTTY() (*os.File, error) {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return os.Stdin, nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil || !terminal.IsTerminal(int(tty.Fd())) {
+		return nil, errors.New("no terminal to prompt on")
+	}
+	return tty, nil
+}
+
+// canPrompt tells if there's a terminal to ask secrets
+func canPrompt() bool {
+	tty, err := openTTY()
+	if err != nil {
+		return false
+	}
+	if tty != os.Stdin {
+		tty.Close()
+	}
+	return true
+}
+
+// prompt prints question on the terminal and reads the answer, without
+// echo unless echo is true
+func prompt(question string, echo bool) (string, error) {
+	tty, err := openTTY()
+	if err != nil {
+		return "", err
+	}
+	if tty != os.Stdin {
+		defer tty.Close()
+	}
+
+	fmt.Fprint(os.Stderr, question)
+	if !echo {
+		answer, err := terminal.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(os.Stderr)
+		return string(answer), err
+	}
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	return strings.TrimRight(answer, "\r\n"), err
+}
+
+// decryptKey asks the passphrase of keyfile on the terminal. An empty
+// passphrase skips the key.
+func decryptKey(keyfile string, content []byte) (ssh.Signer, error) {
+	decryptedKeys.Lock()
+	defer decryptedKeys.Unlock()
+	if signer, ok := decryptedKeys.keys[keyfile]; ok {
+		return signer, nil
+	}
+
+	var err error
+	for i := 0; i < passphraseTries; i++ {
+		var passphrase string
+		passphrase, err = prompt(fmt.Sprintf("Enter passphrase for key '%s': ", keyfile), false)
+		if err != nil {
+			return nil, err
+		}
+		if passphrase == "" {
+			return nil, errors.New("no passphrase given")
+		}
+		var signer ssh.Signer
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(content, []byte(passphrase))
+		if err == nil {
+			decryptedKeys.keys[keyfile] = signer
+			return signer, nil
+		}
+		fmt.Fprintln(os.Stderr, "Bad passphrase, try again for", keyfile)
+	}
+	return nil, err
+}
+
+// passwordPrompt asks the password of host on the terminal, the answer is
+// kept in host for the next connections
+func passwordPrompt(host *Host) func() (string, error) {
+	return func() (string, error) {
+		if host.Password != "" {
+			return host.Password, nil
+		}
+		password, err := prompt(fmt.Sprintf("%s@%s's password: ", host.User, host.HostName), false)
+		if err == nil {
+			host.Password = password
+		}
+		return password, err
+	}
+}
+
+// keyboardInteractive answers the questions of the server (PAM, dropbear).
+// A single hidden question is answered with the password of host if
+// known, else questions are asked on the terminal.
+func keyboardInteractive(host *Host) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			if instruction != "" {
+				fmt.Fprintln(os.Stderr, instruction)
+			}
+			return []string{}, nil
+		}
+		if len(questions) == 1 && !echos[0] && host.Password != "" {
+			return []string{host.Password}, nil
+		}
+
+		if instruction != "" {
+			fmt.Fprintln(os.Stderr, instruction)
+		}
+		if user == "" {
+			user = host.User
+		}
+		answers := make([]string, len(questions))
+		for i, q := range questions {
+			answer, err := prompt(fmt.Sprintf("(%s@%s) %s", user, host.HostName, q), echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		// a password, kept for the next connections (one time codes are not)
+		if len(questions) == 1 && !echos[0] {
+			host.Password = answers[0]
+		}
+		return answers, nil
+	}
+}
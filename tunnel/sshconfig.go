@@ -0,0 +1,457 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdbbs/idok/utils"
+)
+
+// system wide ssh client configuration, read after the user one
+const GLOBAL_SSH_CONFIG = "/etc/ssh/ssh_config"
+
+// maximum depth of Include directives, as OpenSSH
+const maxIncludeDepth = 16
+
+// keepalive defaults when ~/.ssh/config doesn't set ServerAliveInterval and
+// ServerAliveCountMax. These are idok's own: OpenSSH sends none by default,
+// but a tunnel that serves a movie for hours has to notice a lost
+// connection. "ServerAliveInterval 0" disables them, as OpenSSH.
+const (
+	DEFAULT_SERVER_ALIVE_INTERVAL  = 15 * time.Second
+	DEFAULT_SERVER_ALIVE_COUNT_MAX = 3
+)
+
+// one "Keyword arguments" line of ssh_config
+type sshOption struct {
+	keyword string
+	args    []string
+	file    string
+	line    int
+}
+
+// a Host (or Match) block, options before the first one belong to "Host *"
+type sshBlock struct {
+	patterns []string
+	// Match blocks other than "Match all" never match
+	match   bool
+	options []sshOption
+}
+
+// SSHConfig is the OpenSSH client configuration (ssh_config(5)), only the
+// Host blocks are honoured.
+type SSHConfig struct {
+	blocks []*sshBlock
+}
+
+// Host is a ssh destination resolved with the ssh client configuration.
+type Host struct {
+	// name given as target, Host patterns are matched against it
+	Alias string
+
+	HostName string
+	Port     int
+	User     string
+
+	// private key files as written in the configuration, see Identities
+	IdentityFiles []string
+
+	// use only IdentityFiles, agent keys included
+	IdentitiesOnly bool
+
+	// jump hosts, [user@]host[:port] or Host aliases, in connection order
+	ProxyJump []string
+
+	// 0 disables keepalives
+	ServerAliveInterval time.Duration
+
+	// unanswered keepalives before the connection is considered lost
+	ServerAliveCountMax int
+
+	// password authentication is tried if not empty
+	Password string
+
+	// configuration the host comes from, to resolve the jump hosts
+	sshconf *SSHConfig
+}
+
+// Address returns host:port to dial.
+func (h *Host) Address() string {
+	return net.JoinHostPort(h.HostName, strconv.Itoa(h.Port))
+}
+
+// DefaultSSHConfigFile returns ~/.ssh/config.
+func DefaultSSHConfigFile() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(u.HomeDir, ".ssh", "config")
+}
+
+// LoadSSHConfig reads the ssh client configuration files, in priority order.
+// Missing files are ignored.
+func LoadSSHConfig(files ...string) (*SSHConfig, error) {
+	c := &SSHConfig{}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		// each file starts with an implicit "Host *"
+		c.blocks = append(c.blocks, &sshBlock{patterns: []string{"*"}})
+		if err := c.read(file, 0); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *SSHConfig) read(file string, depth int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	n := 0
+	for scanner.Scan() {
+		n++
+		keyword, args, err := splitSSHOption(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		if keyword == "" {
+			continue
+		}
+		if len(args) == 0 {
+			return fmt.Errorf("%s:%d: missing argument for %s", file, n, keyword)
+		}
+
+		switch keyword {
+		case "host":
+			c.blocks = append(c.blocks, &sshBlock{patterns: args})
+		case "match":
+			block := &sshBlock{}
+			if len(args) == 1 && strings.ToLower(args[0]) == "all" {
+				block.patterns = []string{"*"}
+			} else {
+				block.match = true
+				if verbose {
+					log.Printf("%s:%d: Match is not supported, the block is ignored", file, n)
+				}
+			}
+			c.blocks = append(c.blocks, block)
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s:%d: too many nested Include", file, n)
+			}
+			for _, pattern := range args {
+				pattern = expandTilde(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(filepath.Dir(DefaultSSHConfigFile()), pattern)
+				}
+				included, _ := filepath.Glob(pattern)
+				for _, inc := range included {
+					if err := c.read(inc, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			block := c.blocks[len(c.blocks)-1]
+			block.options = append(block.options, sshOption{keyword, args, file, n})
+		}
+	}
+	return scanner.Err()
+}
+
+// splitSSHOption splits "Keyword args" or "Keyword=args", double quoted
+// arguments may contain spaces. The keyword is lower cased, it is empty for
+// blank lines and comments.
+func splitSSHOption(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	if strings.HasPrefix(rest, "=") {
+		rest = strings.TrimLeft(rest[1:], " \t")
+	}
+
+	args := []string{}
+	for rest != "" {
+		var arg string
+		if rest[0] == '"' {
+			close := strings.IndexByte(rest[1:], '"')
+			if close < 0 {
+				return "", nil, fmt.Errorf("unterminated quote in %q", line)
+			}
+			arg, rest = rest[1:close+1], rest[close+2:]
+		} else if i := strings.IndexAny(rest, " \t"); i >= 0 {
+			arg, rest = rest[:i], rest[i:]
+		} else {
+			arg, rest = rest, ""
+		}
+		if strings.HasPrefix(arg, "#") {
+			break
+		}
+		args = append(args, arg)
+		rest = strings.TrimLeft(rest, " \t")
+	}
+	return keyword, args, nil
+}
+
+// matches tells if the block applies to alias, a negated pattern that
+// matches excludes the block
+func (b *sshBlock) matches(alias string) bool {
+	if b.match {
+		return false
+	}
+	found := false
+	for _, p := range b.patterns {
+		negate := strings.HasPrefix(p, "!")
+		if negate {
+			p = p[1:]
+		}
+		if !wildcard(strings.ToLower(p), strings.ToLower(alias)) {
+			continue
+		}
+		if negate {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// get returns the first value of keyword for alias, as OpenSSH the first
+// obtained value wins
+func (c *SSHConfig) get(alias, keyword string) *sshOption {
+	for _, b := range c.blocks {
+		if !b.matches(alias) {
+			continue
+		}
+		for i := range b.options {
+			if b.options[i].keyword == keyword {
+				return &b.options[i]
+			}
+		}
+	}
+	return nil
+}
+
+// getAll returns every value of keyword for alias, for cumulative
+// keywords (IdentityFile)
+func (c *SSHConfig) getAll(alias, keyword string) []sshOption {
+	all := []sshOption{}
+	for _, b := range c.blocks {
+		if !b.matches(alias) {
+			continue
+		}
+		for _, o := range b.options {
+			if o.keyword == keyword {
+				all = append(all, o)
+			}
+		}
+	}
+	return all
+}
+
+func expandTilde(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if u, err := user.Current(); err == nil {
+			return filepath.Join(u.HomeDir, path[1:])
+		}
+	}
+	return path
+}
+
+// expandTokens replaces the % tokens of ssh_config(5) that idok knows
+func (h *Host) expandTokens(value string) string {
+	local := ""
+	home := ""
+	if u, err := user.Current(); err == nil {
+		local, home = u.Username, u.HomeDir
+	}
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%d", home,
+		"%h", h.HostName,
+		"%n", h.Alias,
+		"%p", strconv.Itoa(h.Port),
+		"%r", h.User,
+		"%u", local,
+	)
+	return replacer.Replace(value)
+}
+
+// Identities returns the private key files, "~" and % tokens expanded.
+func (h *Host) Identities() []string {
+	files := []string{}
+	for _, file := range h.IdentityFiles {
+		files = append(files, expandTilde(h.expandTokens(file)))
+	}
+	return files
+}
+
+// Resolve returns the settings of alias. login and port are used if the
+// configuration doesn't give them.
+func (c *SSHConfig) Resolve(alias, login string, port int) (*Host, error) {
+	h := &Host{
+		Alias:               alias,
+		HostName:            alias,
+		User:                login,
+		Port:                port,
+		ServerAliveInterval: DEFAULT_SERVER_ALIVE_INTERVAL,
+		ServerAliveCountMax: DEFAULT_SERVER_ALIVE_COUNT_MAX,
+		sshconf:             c,
+	}
+
+	if o := c.get(alias, "hostname"); o != nil {
+		h.HostName = strings.NewReplacer("%%", "%", "%h", alias).Replace(o.args[0])
+	}
+	if o := c.get(alias, "user"); o != nil {
+		h.User = o.args[0]
+	}
+	if o := c.get(alias, "port"); o != nil {
+		p, err := strconv.Atoi(o.args[0])
+		if err != nil || p <= 0 || p > 65535 {
+			return nil, fmt.Errorf("%s:%d: bad port %q", o.file, o.line, o.args[0])
+		}
+		h.Port = p
+	}
+	if o := c.get(alias, "identitiesonly"); o != nil {
+		only, err := sshBool(o)
+		if err != nil {
+			return nil, err
+		}
+		h.IdentitiesOnly = only
+	}
+	if o := c.get(alias, "proxyjump"); o != nil {
+		h.ProxyJump = splitProxyJump(o.args[0])
+	}
+	if o := c.get(alias, "serveraliveinterval"); o != nil {
+		s, err := strconv.Atoi(o.args[0])
+		if err != nil || s < 0 {
+			return nil, fmt.Errorf("%s:%d: bad interval %q", o.file, o.line, o.args[0])
+		}
+		h.ServerAliveInterval = time.Duration(s) * time.Second
+	}
+	if o := c.get(alias, "serveralivecountmax"); o != nil {
+		n, err := strconv.Atoi(o.args[0])
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%s:%d: bad count %q", o.file, o.line, o.args[0])
+		}
+		h.ServerAliveCountMax = n
+	}
+	for _, o := range c.getAll(alias, "identityfile") {
+		if strings.ToLower(o.args[0]) == "none" {
+			continue
+		}
+		h.IdentityFiles = append(h.IdentityFiles, o.args[0])
+	}
+	return h, nil
+}
+
+// splitProxyJump splits the comma separated jump hosts, "none" means no
+// jump host
+func splitProxyJump(value string) []string {
+	if value == "" || strings.ToLower(value) == "none" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func sshBool(o *sshOption) (bool, error) {
+	switch strings.ToLower(o.args[0]) {
+	case "yes", "true":
+		return true, nil
+	case "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("%s:%d: %s should be yes or no", o.file, o.line, o.keyword)
+}
+
+// source returns file:line of the value of keyword for alias
+func (c *SSHConfig) source(alias, keyword string) string {
+	if o := c.get(alias, keyword); o != nil {
+		return fmt.Sprintf("%s:%d", o.file, o.line)
+	}
+	return ""
+}
+
+// ApplySSHConfig resolves conf.Target with the ssh client configuration
+// (~/.ssh/config then /etc/ssh/ssh_config). The target becomes the HostName
+// of the alias, so that jsonrpc reaches it too. User, Port and ProxyJump are
+// used only if sshuser, sshport and proxyjump are not set by an option, the
+// environment or a configuration file. identity replaces IdentityFile.
+func ApplySSHConfig(conf *utils.Config) (*Host, error) {
+	sshconf, err := LoadSSHConfig(DefaultSSHConfigFile(), GLOBAL_SSH_CONFIG)
+	if err != nil {
+		return nil, err
+	}
+	return applySSHConfig(conf, sshconf)
+}
+
+// applySSHConfig is ApplySSHConfig with the ssh client configuration given
+func applySSHConfig(conf *utils.Config, sshconf *SSHConfig) (*Host, error) {
+	alias := conf.Target
+	h, err := sshconf.Resolve(alias, conf.Sshuser, conf.Sshport)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.HostName != alias {
+		conf.Target = h.HostName
+		conf.Sources["target"] += ", HostName " + sshconf.source(alias, "hostname")
+	}
+	if conf.Sources["sshuser"] == "default" {
+		if src := sshconf.source(alias, "user"); src != "" {
+			conf.Sshuser, conf.Sources["sshuser"] = h.User, src
+		}
+	} else {
+		h.User = conf.Sshuser
+	}
+	if conf.Sources["sshport"] == "default" {
+		if src := sshconf.source(alias, "port"); src != "" {
+			conf.Sshport, conf.Sources["sshport"] = h.Port, src
+		}
+	} else {
+		h.Port = conf.Sshport
+	}
+
+	if conf.Sources["proxyjump"] == "default" {
+		if src := sshconf.source(alias, "proxyjump"); src != "" {
+			conf.Proxyjump, conf.Sources["proxyjump"] = strings.Join(h.ProxyJump, ","), src
+		}
+	} else {
+		h.ProxyJump = splitProxyJump(conf.Proxyjump)
+	}
+	// as IdentitiesOnly: only these keys, from the files or the agent
+	if conf.Identity != "" {
+		h.IdentityFiles = strings.Split(conf.Identity, ",")
+		h.IdentitiesOnly = true
+	}
+	h.Password = conf.Sshpassword
+
+	if verbose {
+		log.Printf("ssh host %s: %s@%s, identities %v (only: %v), jump hosts %v, keepalive %v x %d",
+			alias, h.User, h.Address(), h.Identities(), h.IdentitiesOnly, h.ProxyJump, h.ServerAliveInterval, h.ServerAliveCountMax)
+	}
+	return h, nil
+}
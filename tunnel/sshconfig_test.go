@@ -0,0 +1,291 @@
+package tunnel
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sdbbs/idok/utils"
+)
+
+// sshConfigDir writes the files in a temporary directory, "$DIR" in their
+// content is replaced by the directory
+func sshConfigDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "idok-ssh-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		content = strings.Replace(content, "$DIR", dir, -1)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestSplitSSHOption(t *testing.T) {
+	tests := []struct {
+		line    string
+		keyword string
+		args    []string
+	}{
+		{"", "", nil},
+		{"   # comment", "", nil},
+		{"Host kodi", "host", []string{"kodi"}},
+		{"\tHostName=kodi.lan", "hostname", []string{"kodi.lan"}},
+		{"Port = 2222", "port", []string{"2222"}},
+		{"HOST kodi  tv\t*.lan", "host", []string{"kodi", "tv", "*.lan"}},
+		{"User kodi # comment", "user", []string{"kodi"}},
+		{`IdentityFile "~/my keys/id_ed25519"`, "identityfile", []string{"~/my keys/id_ed25519"}},
+		{`IdentityFile="~/my keys/id" ~/id`, "identityfile", []string{"~/my keys/id", "~/id"}},
+		{`Host "" x`, "host", []string{"", "x"}},
+		{"Compression", "compression", nil},
+	}
+	for _, test := range tests {
+		keyword, args, err := splitSSHOption(test.line)
+		if err != nil {
+			t.Errorf("%q: %v", test.line, err)
+			continue
+		}
+		if keyword != test.keyword || len(args) != len(test.args) || (len(args) > 0 && !reflect.DeepEqual(args, test.args)) {
+			t.Errorf("%q: got %q %q, want %q %q", test.line, keyword, args, test.keyword, test.args)
+		}
+	}
+	if _, _, err := splitSSHOption(`IdentityFile "~/my keys/id`); err == nil {
+		t.Error("unterminated quote: no error")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := sshConfigDir(t, map[string]string{
+		"config": `# before any Host, for every host
+ServerAliveCountMax 4
+Include $DIR/conf.d/*.conf
+
+Host kodi
+    HostName 192.168.1.10
+    Port 2222
+    User kodi
+    IdentityFile ~/.ssh/kodi
+
+Host *.lan !nas.lan
+    User lan
+    IdentityFile "~/.ssh/lan key"
+
+Match host tv.lan
+    User matched
+
+Host *.box
+    HostName %h.internal
+
+Host kodi *
+    Port 2200
+    User=other
+    IdentityFile ~/.ssh/id_ed25519
+    ProxyJump bastion,gw:2222
+`,
+		"conf.d/a.conf": "Host media\n    HostName media.lan\n    User media\n",
+		"conf.d/b.conf": "Host media\n    User ignored\n    Port 2022\n    ProxyJump none\n",
+		"ssh_config":    "Host *\n    IdentityFile ~/.ssh/global\n    IdentityFile none\n",
+	})
+	sshconf, err := LoadSSHConfig(filepath.Join(dir, "config"), filepath.Join(dir, "missing"), filepath.Join(dir, "ssh_config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jumps := []string{"bastion", "gw:2222"}
+	tests := []struct {
+		alias string
+		want  Host
+	}{
+		// first obtained value wins, IdentityFile accumulates
+		{"kodi", Host{HostName: "192.168.1.10", Port: 2222, User: "kodi",
+			IdentityFiles: []string{"~/.ssh/kodi", "~/.ssh/id_ed25519", "~/.ssh/global"}, ProxyJump: jumps}},
+		{"KODI", Host{HostName: "192.168.1.10", Port: 2222, User: "kodi",
+			IdentityFiles: []string{"~/.ssh/kodi", "~/.ssh/id_ed25519", "~/.ssh/global"}, ProxyJump: jumps}},
+		// Match blocks are ignored
+		{"tv.lan", Host{HostName: "tv.lan", Port: 2200, User: "lan",
+			IdentityFiles: []string{"~/.ssh/lan key", "~/.ssh/id_ed25519", "~/.ssh/global"}, ProxyJump: jumps}},
+		// negated pattern
+		{"nas.lan", Host{HostName: "nas.lan", Port: 2200, User: "other",
+			IdentityFiles: []string{"~/.ssh/id_ed25519", "~/.ssh/global"}, ProxyJump: jumps}},
+		{"tv.box", Host{HostName: "tv.box.internal", Port: 2200, User: "other",
+			IdentityFiles: []string{"~/.ssh/id_ed25519", "~/.ssh/global"}, ProxyJump: jumps}},
+		// included files, in name order
+		{"media", Host{HostName: "media.lan", Port: 2022, User: "media",
+			IdentityFiles: []string{"~/.ssh/id_ed25519", "~/.ssh/global"}}},
+	}
+	for _, test := range tests {
+		h, err := sshconf.Resolve(test.alias, "pi", 22)
+		if err != nil {
+			t.Errorf("%s: %v", test.alias, err)
+			continue
+		}
+		if h.Alias != test.alias || h.ServerAliveCountMax != 4 {
+			t.Errorf("%s: alias %q, ServerAliveCountMax %d", test.alias, h.Alias, h.ServerAliveCountMax)
+		}
+		got := Host{HostName: h.HostName, Port: h.Port, User: h.User, IdentityFiles: h.IdentityFiles, ProxyJump: h.ProxyJump}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.alias, got, test.want)
+		}
+	}
+
+	// login and port given are the defaults
+	empty, err := LoadSSHConfig(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := empty.Resolve("kodi", "pi", 22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.HostName != "kodi" || h.User != "pi" || h.Port != 22 || h.IdentityFiles != nil || h.ProxyJump != nil {
+		t.Errorf("no configuration: got %+v", h)
+	}
+}
+
+func TestLoadSSHConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// error substring, of LoadSSHConfig or Resolve
+		want string
+	}{
+		{"unterminated quote", "Host kodi\n    IdentityFile \"~/id\n", "config:2: unterminated quote"},
+		{"missing argument", "Host kodi\n    User\n", "config:2: missing argument for user"},
+		{"include loop", "Include $DIR/config\n", "too many nested Include"},
+		{"bad port", "Host kodi\n    Port 70000\n", "config:2: bad port"},
+		{"bad boolean", "Host kodi\n    IdentitiesOnly maybe\n", "config:2: identitiesonly should be yes or no"},
+	}
+	for _, test := range tests {
+		dir := sshConfigDir(t, map[string]string{"config": test.content})
+		sshconf, err := LoadSSHConfig(filepath.Join(dir, "config"))
+		if err == nil {
+			_, err = sshconf.Resolve("kodi", "pi", 22)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	dir := sshConfigDir(t, map[string]string{
+		"config": "Host kodi\n    HostName 192.168.1.10\n    User kodi\n    Port 2222\n    ProxyJump bastion\n" +
+			"    IdentityFile ~/.ssh/kodi\n",
+	})
+	file := filepath.Join(dir, "config")
+	sshconf, err := LoadSSHConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a configuration with sshuser, sshport, proxyjump and identity from
+	// sources, "default" if not set
+	config := func(sources map[string]string, user string, port int, proxyjump, identity string) *utils.Config {
+		conf := &utils.Config{Target: "kodi", Sshuser: user, Sshport: port, Proxyjump: proxyjump, Identity: identity,
+			Sources: map[string]string{"target": "option -target"}}
+		for _, key := range []string{"sshuser", "sshport", "proxyjump"} {
+			conf.Sources[key] = "default"
+			if source, found := sources[key]; found {
+				conf.Sources[key] = source
+			}
+		}
+		return conf
+	}
+
+	// ~/.ssh/config fills the defaults
+	conf := config(nil, "pi", 22, "", "")
+	h, err := applySSHConfig(conf, sshconf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Target != "192.168.1.10" || conf.Sources["target"] != "option -target, HostName "+file+":2" {
+		t.Errorf("target %s (%s)", conf.Target, conf.Sources["target"])
+	}
+	if conf.Sshuser != "kodi" || conf.Sources["sshuser"] != file+":3" ||
+		conf.Sshport != 2222 || conf.Sources["sshport"] != file+":4" ||
+		conf.Proxyjump != "bastion" || conf.Sources["proxyjump"] != file+":5" {
+		t.Errorf("configuration %+v", conf)
+	}
+	if h.User != "kodi" || h.Port != 2222 || !reflect.DeepEqual(h.ProxyJump, []string{"bastion"}) ||
+		!reflect.DeepEqual(h.IdentityFiles, []string{"~/.ssh/kodi"}) || h.IdentitiesOnly {
+		t.Errorf("host %+v", h)
+	}
+
+	// idok.conf, the environment or an option wins
+	sources := map[string]string{"sshuser": "idok.conf:1", "sshport": "env IDOK_SSHPORT", "proxyjump": "option -proxyjump"}
+	conf = config(sources, "osmc", 22, "none", "~/.ssh/a,~/.ssh/b")
+	if h, err = applySSHConfig(conf, sshconf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Sshuser != "osmc" || conf.Sshport != 22 || conf.Proxyjump != "none" ||
+		conf.Sources["sshuser"] != "idok.conf:1" || conf.Sources["sshport"] != "env IDOK_SSHPORT" {
+		t.Errorf("configuration %+v", conf)
+	}
+	if h.User != "osmc" || h.Port != 22 || h.ProxyJump != nil || h.Address() != "192.168.1.10:22" {
+		t.Errorf("host %+v", h)
+	}
+	// identity replaces IdentityFile
+	if !reflect.DeepEqual(h.IdentityFiles, []string{"~/.ssh/a", "~/.ssh/b"}) || !h.IdentitiesOnly {
+		t.Errorf("identities %v (only: %v)", h.IdentityFiles, h.IdentitiesOnly)
+	}
+}
+
+func TestServerAlive(t *testing.T) {
+	f, err := ioutil.TempFile("", "idok-ssh-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`Host off
+    ServerAliveInterval 0
+
+Host slow
+    ServerAliveInterval 60
+    ServerAliveCountMax 5
+
+Host bad
+    ServerAliveInterval -1
+`)
+	f.Close()
+	sshconf, err := LoadSSHConfig(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alias    string
+		interval time.Duration
+		count    int
+	}{
+		// idok's own default, OpenSSH sends none
+		{"default", DEFAULT_SERVER_ALIVE_INTERVAL, DEFAULT_SERVER_ALIVE_COUNT_MAX},
+		{"off", 0, DEFAULT_SERVER_ALIVE_COUNT_MAX},
+		{"slow", 60 * time.Second, 5},
+	}
+	for _, test := range tests {
+		h, err := sshconf.Resolve(test.alias, "user", 22)
+		if err != nil {
+			t.Errorf("%s: %v", test.alias, err)
+			continue
+		}
+		if h.ServerAliveInterval != test.interval || h.ServerAliveCountMax != test.count {
+			t.Errorf("%s: got %v/%d, want %v/%d", test.alias, h.ServerAliveInterval, h.ServerAliveCountMax,
+				test.interval, test.count)
+		}
+	}
+	if _, err := sshconf.Resolve("bad", "user", 22); err == nil {
+		t.Error("negative interval: no error")
+	}
+}
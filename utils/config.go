@@ -1,13 +1,18 @@
 package utils
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+
+	"github.com/sdbbs/idok/kodi"
 )
 
 type Config struct {
@@ -23,8 +28,8 @@ type Config struct {
 	// Kodi password
 	Password string
 
-	// host url to jsonrpc
-	JsonRPC string
+	// target TCP jsonrpc port, used to get notifications
+	Notifyport int
 
 	// Local port if computer should serve
 	Localport int
@@ -41,129 +46,532 @@ type Config struct {
 	// Use SSH to stream
 	Ssh bool
 
+	// SSH host key checking: strict, accept-new or off
+	Hostkeycheck string
+
+	// ssh-agent identity to use (comment, fingerprint or public key file),
+	// all agent keys and key files if empty
+	Agentidentity string
+
+	// private key files to use only, comma separated
+	Identity string
+
+	// ssh jump hosts, [user@]host[:port] comma separated, "none" to ignore
+	// the ProxyJump of ~/.ssh/config
+	Proxyjump string
+
+	// port opened on the Kodi host to stream through ssh: "" lets the
+	// server choose, "N" or "N-M" (see PortRange)
+	Remoteport string
+
+	// ffmpeg executable for -transcode
+	Ffmpeg string
+
 	// Check for new release
 	ReleaseCheck bool
+
+	// profile (configuration file section) in use
+	Profile string
+
+	// where each setting comes from, by configuration key
+	Sources map[string]string
 }
 
 var GlobalConfig *Config
 
-// Set the target host, port and ssh jsonrpc user/pass
+// jsonrpc client built from GlobalConfig
+var Kodi *kodi.Client
+
+// Set the target host, port and jsonrpc user/pass
 func SetTarget(conf *Config) {
+	// assign package conf
+	GlobalConfig = conf
+	Kodi = kodi.NewClient(conf.Target, conf.Targetport, conf.User, conf.Password)
+}
 
-	host := conf.Target
-	// XBMC can be configured to have username/password
-	if conf.User != "" {
-		host = conf.User + ":" + conf.Password + "@" + conf.Target
+// ConfigFiles returns existing configuration files, the last one has the
+// highest priority.
+func ConfigFiles() []string {
+	filelist := []string{"/etc/idok.conf"}
+	if u, err := user.Current(); err == nil {
+		filelist = append(filelist,
+			filepath.Join(u.HomeDir, ".local", "etc", "idok.conf"),
+			filepath.Join(u.HomeDir, ".config", "idok", "idok.conf"),
+		)
 	}
+	filelist = append(filelist, "./idok.conf")
 
-	conf.JsonRPC = fmt.Sprintf("http://%s:%d/jsonrpc", host, conf.Targetport)
+	files := []string{}
+	for _, file := range filelist {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
 
-	// assign package conf
-	GlobalConfig = conf
+// ConfigError is an error in configuration file, with its line number.
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// key = value line of configuration file
+type configEntry struct {
+	key   string
+	value string
+	line  int
 }
 
-// Try to get config files
-func CheckLocalConfigFiles() (string, bool) {
-	u, _ := user.Current()
-	home := u.HomeDir
+// parsed configuration file, entries before the first [section] are
+// global ones and apply to every profile
+type configFile struct {
+	name     string
+	global   []configEntry
+	profiles map[string][]configEntry
+	// profile names in file order
+	order []string
+}
 
-	filelist := []string{
-		"./idok.conf",
-		home + "/.config/idok/idok.conf",
-		home + "/.local/etc/idok.conf",
-		"/etc/idok.conf",
+func intValue(value string) (int, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q should be an integer", value)
 	}
+	return i, nil
+}
 
-	for _, file := range filelist {
-		if _, err := os.Stat(file); err == nil {
-			return file, true
+// PortRange parses "N" or "N-M", "" gives 0, 0.
+func PortRange(value string) (min, max int, err error) {
+	if value == "" {
+		return 0, 0, nil
+	}
+	bad := fmt.Errorf("%q should be a port or a port range (eg. 10000-19999)", value)
+	parts := strings.SplitN(value, "-", 2)
+	if min, err = strconv.Atoi(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, bad
+	}
+	max = min
+	if len(parts) == 2 {
+		if max, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, bad
 		}
 	}
+	if min <= 0 || max > 65535 || min > max {
+		return 0, 0, bad
+	}
+	return min, max, nil
+}
 
-	return "", false
+// ParseSize parses a size in bytes with an optional K, M or G suffix
+// (powers of 1024).
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		unit = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		unit = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q should be a size (eg. 512K, 32M)", value)
+	}
+	return n * unit, nil
+}
+
+func boolValue(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q should be true or false", value)
+}
+
+// a configuration setting
+type configKey struct {
+	// key in configuration file, IDOK_KEY environment variable
+	name string
+
+	// command line option
+	flag string
+
+	// built-in default
+	def string
+
+	set func(config *Config, value string) error
+	get func(config *Config) string
+}
+
+// configuration keys, in the order of "idok config show"
+var configKeys = []configKey{
+	{"target", "target", "",
+		func(config *Config, value string) error {
+			config.Target = value
+			return nil
+		},
+		func(config *Config) string { return config.Target }},
+	{"targetport", "targetport", "80",
+		func(config *Config, value string) (err error) {
+			config.Targetport, err = intValue(value)
+			return
+		},
+		func(config *Config) string { return strconv.Itoa(config.Targetport) }},
+	{"notifyport", "notifyport", "9090",
+		func(config *Config, value string) (err error) {
+			config.Notifyport, err = intValue(value)
+			return
+		},
+		func(config *Config) string { return strconv.Itoa(config.Notifyport) }},
+	{"login", "login", "",
+		func(config *Config, value string) error {
+			config.User = value
+			return nil
+		},
+		func(config *Config) string { return config.User }},
+	{"password", "password", "",
+		func(config *Config, value string) error {
+			config.Password = value
+			return nil
+		},
+		func(config *Config) string { return hidden(config.Password) }},
+	{"localport", "port", "8080",
+		func(config *Config, value string) (err error) {
+			config.Localport, err = intValue(value)
+			return
+		},
+		func(config *Config) string { return strconv.Itoa(config.Localport) }},
+	{"ssh", "ssh", "false",
+		func(config *Config, value string) (err error) {
+			config.Ssh, err = boolValue(value)
+			return
+		},
+		func(config *Config) string { return strconv.FormatBool(config.Ssh) }},
+	{"sshuser", "sshuser", "pi",
+		func(config *Config, value string) error {
+			config.Sshuser = value
+			return nil
+		},
+		func(config *Config) string { return config.Sshuser }},
+	{"sshpass", "sshpass", "",
+		func(config *Config, value string) error {
+			config.Sshpassword = value
+			return nil
+		},
+		func(config *Config) string { return hidden(config.Sshpassword) }},
+	{"sshport", "sshport", "22",
+		func(config *Config, value string) (err error) {
+			config.Sshport, err = intValue(value)
+			return
+		},
+		func(config *Config) string { return strconv.Itoa(config.Sshport) }},
+	{"hostkeycheck", "hostkeycheck", "accept-new",
+		func(config *Config, value string) error {
+			switch value {
+			case "strict", "accept-new", "off":
+				config.Hostkeycheck = value
+				return nil
+			}
+			return fmt.Errorf("invalid host key checking mode %q (strict, accept-new or off)", value)
+		},
+		func(config *Config) string { return config.Hostkeycheck }},
+	{"agentidentity", "agentidentity", "",
+		func(config *Config, value string) error {
+			config.Agentidentity = value
+			return nil
+		},
+		func(config *Config) string { return config.Agentidentity }},
+	{"identity", "identity", "",
+		func(config *Config, value string) error {
+			config.Identity = value
+			return nil
+		},
+		func(config *Config) string { return config.Identity }},
+	{"proxyjump", "proxyjump", "",
+		func(config *Config, value string) error {
+			config.Proxyjump = value
+			return nil
+		},
+		func(config *Config) string { return config.Proxyjump }},
+	{"remoteport", "remoteport", "",
+		func(config *Config, value string) error {
+			if _, _, err := PortRange(value); err != nil {
+				return err
+			}
+			config.Remoteport = value
+			return nil
+		},
+		func(config *Config) string { return config.Remoteport }},
+	{"ffmpeg", "ffmpeg", "ffmpeg",
+		func(config *Config, value string) error {
+			config.Ffmpeg = value
+			return nil
+		},
+		func(config *Config) string { return config.Ffmpeg }},
+	{"release-check", "", "false",
+		func(config *Config, value string) (err error) {
+			config.ReleaseCheck, err = boolValue(value)
+			return
+		},
+		func(config *Config) string { return strconv.FormatBool(config.ReleaseCheck) }},
+}
+
+// options that set a key to a fixed value
+var negativeFlags = map[string][2]string{
+	"nossh":                 {"ssh", "false"},
+	"disable-check-release": {"release-check", "false"},
 }
 
-func LoadLocalConfig(filename string, config *Config) {
-	content, _ := ioutil.ReadFile(filename)
-	lines := strings.Split(string(content), "\n")
+func hidden(password string) string {
+	if password == "" {
+		return ""
+	}
+	return "********"
+}
+
+func findConfigKey(name string) *configKey {
+	for i := range configKeys {
+		if configKeys[i].name == name {
+			return &configKeys[i]
+		}
+	}
+	return nil
+}
+
+// key of global section that gives the default profile
+const PROFILE_KEY = "profile"
+
+// prefix of environment variables, eg. IDOK_TARGET, IDOK_RELEASE_CHECK
+const ENV_PREFIX = "IDOK_"
 
-	for _, line := range lines {
-		if strings.Trim(line, "") == "" {
+func envName(key string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.Replace(key, "-", "_", -1))
+}
+
+// parse configuration file, keys are checked but not applied
+func parseConfigFile(filename string) (*configFile, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	file := &configFile{name: filename, profiles: map[string][]configEntry{}}
+	section := ""
+
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		// blank lines and comments
+		if line == "" || line[0] == '#' || line[0] == ';' {
 			continue
 		}
-		// comments
-		if line[0] == '#' {
+
+		// [profile]
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' || strings.TrimSpace(line[1:len(line)-1]) == "" {
+				return nil, &ConfigError{filename, i + 1, fmt.Sprintf("bad section %s", line)}
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if _, found := file.profiles[section]; found {
+				return nil, &ConfigError{filename, i + 1, fmt.Sprintf("profile [%s] is defined twice", section)}
+			}
+			file.profiles[section] = []configEntry{}
+			file.order = append(file.order, section)
 			continue
 		}
+
 		// Get key = value...
 		val := strings.SplitN(line, "=", 2)
-		for i, _ := range val {
-			val[i] = strings.Trim(val[i], " ")
+		if len(val) != 2 {
+			return nil, &ConfigError{filename, i + 1, fmt.Sprintf("expected key = value, got %q", line)}
 		}
-
-		// val[0] = key, val[1] = value
-		switch strings.ToLower(val[0]) {
-		case "target":
-			config.Target = val[1]
-		case "targetport":
-			if val[1] == "" {
-				continue
-			}
-			port, err := strconv.Atoi(val[1])
-			if err != nil {
-				log.Fatal("Target port in config file should be integer")
-			}
-			config.Targetport = int(port)
-		case "login":
-			config.User = val[1]
-		case "password":
-			config.Password = val[1]
-		case "localport":
-			port, err := strconv.Atoi(val[1])
-			if err != nil {
-				log.Fatal("Local port in config file should be integer")
-			}
-			config.Localport = int(port)
-		case "sshuser":
-			config.Sshuser = val[1]
-		case "sshpass":
-			config.Sshpassword = val[1]
-		case "sshport":
-			if val[1] == "" {
-				continue
+		entry := configEntry{
+			key:   strings.ToLower(strings.TrimSpace(val[0])),
+			value: strings.TrimSpace(val[1]),
+			line:  i + 1,
+		}
+		if entry.key == PROFILE_KEY && section == "" {
+			file.global = append(file.global, entry)
+			continue
+		}
+		key := findConfigKey(entry.key)
+		if key == nil {
+			return nil, &ConfigError{filename, i + 1, fmt.Sprintf("unknown key %q", entry.key)}
+		}
+		// check value
+		if entry.value != "" {
+			if err := key.set(&Config{}, entry.value); err != nil {
+				return nil, &ConfigError{filename, i + 1, fmt.Sprintf("%s: %v", entry.key, err)}
 			}
-			port, err := strconv.Atoi(val[1])
-			if err != nil {
-				log.Fatal("SSH port in config file should be integer")
+		}
+		if section == "" {
+			file.global = append(file.global, entry)
+		} else {
+			file.profiles[section] = append(file.profiles[section], entry)
+		}
+	}
+	return file, nil
+}
+
+// apply entries of file to config, blank values are ignored
+func (file *configFile) apply(entries []configEntry, section string, config *Config) {
+	for _, entry := range entries {
+		if entry.key == PROFILE_KEY || entry.value == "" {
+			continue
+		}
+		findConfigKey(entry.key).set(config, entry.value)
+		config.Sources[entry.key] = fmt.Sprintf("%s:%d%s", file.name, entry.line, section)
+	}
+}
+
+// set key from source, the error tells where the bad value comes from
+func setConfigKey(config *Config, key *configKey, value, source string) error {
+	if err := key.set(config, value); err != nil {
+		return fmt.Errorf("%s: %s: %v", source, key.name, err)
+	}
+	config.Sources[key.name] = source
+	return nil
+}
+
+// LoadConfig builds the configuration from, by increasing priority:
+// built-in defaults, configuration files (see ConfigFiles), IDOK_*
+// environment variables and options given on the command line. The
+// profile is given by -profile, IDOK_PROFILE or the "profile" key.
+func LoadConfig() (*Config, error) {
+	// options explicitly given
+	flags := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return loadConfig(ConfigFiles(), flags)
+}
+
+// loadConfig is LoadConfig with the configuration files and the options
+// given, by name
+func loadConfig(filenames []string, flags map[string]string) (*Config, error) {
+	config := &Config{Sources: map[string]string{}}
+	for i := range configKeys {
+		setConfigKey(config, &configKeys[i], configKeys[i].def, "default")
+	}
+
+	files := []*configFile{}
+	for _, filename := range filenames {
+		file, err := parseConfigFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	// profile to use
+	profilesource := "default"
+	for _, file := range files {
+		for _, entry := range file.global {
+			if entry.key == PROFILE_KEY {
+				config.Profile = entry.value
+				profilesource = fmt.Sprintf("%s:%d", file.name, entry.line)
 			}
-			config.Sshport = int(port)
-		case "ssh":
-			if val[1] == "true" {
-				config.Ssh = true
+		}
+	}
+	if value := os.Getenv(envName(PROFILE_KEY)); value != "" {
+		config.Profile, profilesource = value, "env "+envName(PROFILE_KEY)
+	}
+	if value, found := flags[PROFILE_KEY]; found {
+		config.Profile, profilesource = value, "option -"+PROFILE_KEY
+	}
+	config.Sources[PROFILE_KEY] = profilesource
+
+	// files, global keys then profile keys of each file
+	profilefound := false
+	for _, file := range files {
+		file.apply(file.global, "", config)
+		if entries, found := file.profiles[config.Profile]; found && config.Profile != "" {
+			profilefound = true
+			file.apply(entries, " ["+config.Profile+"]", config)
+		}
+	}
+	if config.Profile != "" && !profilefound {
+		if len(files) == 0 {
+			return nil, fmt.Errorf("Profile %s asked but there is no configuration file", config.Profile)
+		}
+		names := []string{}
+		for _, file := range files {
+			names = append(names, file.order...)
+		}
+		return nil, fmt.Errorf("Profile [%s] not found, profiles are: %s", config.Profile, strings.Join(names, ", "))
+	}
+
+	// environment
+	for i := range configKeys {
+		key := &configKeys[i]
+		if value := os.Getenv(envName(key.name)); value != "" {
+			if err := setConfigKey(config, key, value, "env "+envName(key.name)); err != nil {
+				return nil, err
 			}
-		case "release-check":
-			if val[1] == "false" {
-				config.ReleaseCheck = false
+		}
+	}
+
+	// command line options
+	for i := range configKeys {
+		key := &configKeys[i]
+		if value, found := flags[key.flag]; found && key.flag != "" {
+			if err := setConfigKey(config, key, value, "option -"+key.flag); err != nil {
+				return nil, err
 			}
-		default:
-			log.Fatalf("Bad Key in configuration file: %s\n", val)
 		}
+	}
+	for name, set := range negativeFlags {
+		if value, found := flags[name]; found && value == "true" {
+			setConfigKey(config, findConfigKey(set[0]), set[1], "option -"+name)
+		}
+	}
 
+	return config, nil
+}
+
+// PrintConfig writes the value of each setting and where it comes from.
+func PrintConfig(w io.Writer, config *Config) {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "%s\t= %s\t# %s\n", PROFILE_KEY, config.Profile, config.Sources[PROFILE_KEY])
+	for _, key := range configKeys {
+		fmt.Fprintf(tw, "%s\t= %s\t# %s\n", key.name, key.get(config), config.Sources[key.name])
 	}
+	tw.Flush()
 }
 
 func PrintExampleConfig() {
 
 	fmt.Println(`# blank value means default
 #
-# Idok checks if that file exists in that order:
-# - ./idok.conf
-# - $HOME/.config/idok/idok.conf
-# - $HOME/.local/etc/idok.conf
+# Idok reads these files if they exist, values of a file override values
+# of the previous ones:
 # - /etc/idok.conf
+# - $HOME/.local/etc/idok.conf
+# - $HOME/.config/idok/idok.conf
+# - ./idok.conf
 #
-# If option is given, it override the configuration file corresponding option
+# IDOK_* environment variables (eg. IDOK_TARGET, IDOK_RELEASE_CHECK)
+# override configuration files, and options given on command line override
+# everything. "idok config show" prints values and where they come from.
 #
+# Keys before the first [profile] section are used by every profile. Each
+# [profile] section can set its own keys, select one with -profile=NAME.
+# The "profile" key below selects the default profile.
+#
+# default profile (blank means no profile)
+profile = 
+
 # IP or hostname of Kodi/XBMC
 # (-target)
 target = 
@@ -172,6 +580,11 @@ target =
 # (-targetport)
 targetport = 
 
+# Kodi TCP jsonrpc port, to be notified when media stops
+# (polling jsonrpc every second if unreachable)
+# (-notifyport)
+notifyport = 
+
 # Kodi/XBMC jsonrpc username and password
 # (-login -password)
 login = 
@@ -190,7 +603,48 @@ sshport =
 # (-ssh -nossh)
 ssh = 
 
+# check the ssh server key against ~/.ssh/known_hosts: strict refuses
+# unknown hosts, accept-new learns them, off disables the check.
+# A changed key is always refused unless off.
+# (-hostkeycheck)
+hostkeycheck = 
+
+# keys of the running ssh-agent ($SSH_AUTH_SOCK) are tried first, then
+# ~/.ssh/id_rsa, id_ecdsa, id_ed25519 and id_dsa. Set an agent key comment, SHA256
+# fingerprint or public key file to use only this agent identity
+# (-agentidentity)
+agentidentity = 
+
+# private key files to use instead of ~/.ssh/config IdentityFile and the
+# default ones, comma separated. The passphrase of protected keys is asked
+# unless ssh-agent holds them (-identity)
+identity = 
+
+# ssh jump hosts to reach the Kodi host, comma separated [user@]host[:port]
+# or ~/.ssh/config aliases, as ProxyJump of ~/.ssh/config (-proxyjump)
+proxyjump = 
+
+# port opened on the Kodi host to stream through ssh, blank lets the ssh
+# server choose (random port in 10000-19999 if it can't), or a port, or a
+# range like 10000-10100 (-remoteport)
+remoteport = 
+
+# ffmpeg executable used by -transcode, looked for in $PATH if it's not a
+# path (-ffmpeg)
+ffmpeg = 
+
 # check for new release
 release-check = false
+
+# Profiles example:
+#
+# [livingroom]
+# target = 192.168.0.10
+# targetport = 8080
+#
+# [bedroom]
+# target = osmc.local
+# ssh = true
+# sshuser = osmc
 `)
 }
@@ -0,0 +1,234 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configFiles writes the configuration files in a temporary directory and
+// returns their paths. IDOK_* variables are cleared for the test.
+func configFiles(t *testing.T, contents ...string) []string {
+	dir, err := ioutil.TempDir("", "idok-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, key := range configKeys {
+		t.Setenv(envName(key.name), "")
+	}
+	t.Setenv(envName(PROFILE_KEY), "")
+
+	files := []string{}
+	for i, content := range contents {
+		file := filepath.Join(dir, string('a'+rune(i))+".conf")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		env   map[string]string
+		flags map[string]string
+		// key: value and source, the file name is prefixed
+		want map[string][2]string
+	}{
+		{"defaults", nil, nil, nil, map[string][2]string{
+			"targetport": {"80", "default"},
+			"ssh":        {"false", "default"},
+			"sshuser":    {"pi", "default"},
+		}},
+		{"files, the last one wins",
+			[]string{"targetport = 8080\nsshuser = kodi\n", "# comment\n; comment\n\ntargetport = 9000\nsshuser =\n"},
+			nil, nil, map[string][2]string{
+				"targetport": {"9000", "b.conf:4"},
+				"sshuser":    {"kodi", "a.conf:2"},
+			}},
+		{"environment over files",
+			[]string{"targetport = 8080\nrelease-check = true\n"},
+			map[string]string{"IDOK_TARGETPORT": "81", "IDOK_RELEASE_CHECK": "false"}, nil,
+			map[string][2]string{
+				"targetport":    {"81", "env IDOK_TARGETPORT"},
+				"release-check": {"false", "env IDOK_RELEASE_CHECK"},
+			}},
+		{"options over environment",
+			[]string{"targetport = 8080\nlocalport = 8000\n"},
+			map[string]string{"IDOK_TARGETPORT": "81", "IDOK_LOCALPORT": "8001"},
+			map[string]string{"targetport": "82", "port": "8002"},
+			map[string][2]string{
+				"targetport": {"82", "option -targetport"},
+				"localport":  {"8002", "option -port"},
+			}},
+		{"negative options",
+			[]string{"ssh = true\nrelease-check = true\n"}, nil,
+			map[string]string{"nossh": "true", "disable-check-release": "true"},
+			map[string][2]string{
+				"ssh":           {"false", "option -nossh"},
+				"release-check": {"false", "option -disable-check-release"},
+			}},
+	}
+	for _, test := range tests {
+		files := configFiles(t, test.files...)
+		for name, value := range test.env {
+			t.Setenv(name, value)
+		}
+		config, err := loadConfig(files, test.flags)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		for name, want := range test.want {
+			value, source := findConfigKey(name).get(config), config.Sources[name]
+			if strings.Contains(want[1], ".conf:") {
+				source = filepath.Base(source)
+			}
+			if value != want[0] || source != want[1] {
+				t.Errorf("%s: %s = %s (%s), want %s (%s)", test.name, name, value, source, want[0], want[1])
+			}
+		}
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		line    int
+		msg     string
+	}{
+		{"target = kodi\n\nvolume = 10\n", 3, `unknown key "volume"`},
+		{"# ports\ntargetport = abc\n", 2, "targetport"},
+		{"ssh = maybe\n", 1, "should be true or false"},
+		{"hostkeycheck = ask\n", 1, "invalid host key checking mode"},
+		{"remoteport = 9000-8000\n", 1, "remoteport"},
+		{"target\n", 1, "expected key = value"},
+		{"[home\ntarget = kodi\n", 1, "bad section"},
+		{"[]\n", 1, "bad section"},
+		{"[home]\ntarget = a\n[home]\n", 3, "defined twice"},
+		{"[home]\nfoo = bar\n", 2, `unknown key "foo"`},
+	}
+	for _, test := range tests {
+		files := configFiles(t, test.content)
+		_, err := loadConfig(files, nil)
+		cerr, ok := err.(*ConfigError)
+		if !ok {
+			t.Errorf("%q: got %v, want a ConfigError", test.content, err)
+			continue
+		}
+		if cerr.File != files[0] || cerr.Line != test.line || !strings.Contains(cerr.Msg, test.msg) {
+			t.Errorf("%q: got %v, want line %d: %s", test.content, err, test.line, test.msg)
+		}
+		if !strings.HasPrefix(err.Error(), files[0]+":") {
+			t.Errorf("%q: message %q", test.content, err)
+		}
+	}
+
+	// bad values of the environment and of the options tell their source
+	files := configFiles(t)
+	t.Setenv("IDOK_TARGETPORT", "abc")
+	if _, err := loadConfig(files, nil); err == nil || !strings.Contains(err.Error(), "env IDOK_TARGETPORT") {
+		t.Errorf("bad environment value: got %v", err)
+	}
+	t.Setenv("IDOK_TARGETPORT", "")
+	if _, err := loadConfig(files, map[string]string{"sshport": "x"}); err == nil || !strings.Contains(err.Error(), "option -sshport") {
+		t.Errorf("bad option value: got %v", err)
+	}
+	if _, err := loadConfig([]string{filepath.Join(os.TempDir(), "idok-missing.conf")}, nil); err == nil {
+		t.Error("missing file: no error")
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	home := "target = 10.0.0.1\nprofile = home\nsshuser = osmc\n\n[home]\ntarget = 192.168.1.10\n\n[away]\ntarget = kodi.example.org\nssh = true\n"
+	tests := []struct {
+		name  string
+		files []string
+		env   string
+		flag  string
+		// profile, target, ssh, sshuser and source of target
+		want [5]string
+	}{
+		{"no profile", []string{"target = 10.0.0.1\n[home]\ntarget = 192.168.1.10\n"}, "", "",
+			[5]string{"", "10.0.0.1", "false", "pi", "a.conf:1"}},
+		{"profile key", []string{home}, "", "",
+			[5]string{"home", "192.168.1.10", "false", "osmc", "a.conf:6 [home]"}},
+		{"environment", []string{home}, "away", "",
+			[5]string{"away", "kodi.example.org", "true", "osmc", "a.conf:9 [away]"}},
+		{"option over environment", []string{home}, "home", "away",
+			[5]string{"away", "kodi.example.org", "true", "osmc", "a.conf:9 [away]"}},
+		// globals of the next file override the profile of the first one
+		{"profiles of several files", []string{home, "[away]\nsshuser = root\n", "target = 10.0.0.2\n"}, "", "away",
+			[5]string{"away", "10.0.0.2", "true", "root", "c.conf:1"}},
+	}
+	for _, test := range tests {
+		files := configFiles(t, test.files...)
+		t.Setenv(envName(PROFILE_KEY), test.env)
+		flags := map[string]string{}
+		if test.flag != "" {
+			flags[PROFILE_KEY] = test.flag
+		}
+		config, err := loadConfig(files, flags)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := [5]string{config.Profile, config.Target, findConfigKey("ssh").get(config), config.Sshuser,
+			filepath.Base(config.Sources["target"])}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// unknown profile
+	files := configFiles(t, home, "[office]\ntarget = 10.1.0.1\n")
+	_, err := loadConfig(files, map[string]string{PROFILE_KEY: "cottage"})
+	if err == nil || !strings.Contains(err.Error(), "[cottage] not found") || !strings.Contains(err.Error(), "home, away, office") {
+		t.Errorf("unknown profile: got %v", err)
+	}
+	files = configFiles(t)
+	t.Setenv(envName(PROFILE_KEY), "home")
+	if _, err := loadConfig(files, nil); err == nil || !strings.Contains(err.Error(), "no configuration file") {
+		t.Errorf("profile without file: got %v", err)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	files := configFiles(t, "profile = home\n[home]\ntarget = 192.168.1.10\npassword = secret\n")
+	config, err := loadConfig(files, map[string]string{"port": "8000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	PrintConfig(&out, config)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(configKeys)+1 {
+		t.Fatalf("%d lines, want %d:\n%s", len(lines), len(configKeys)+1, out.String())
+	}
+	for _, want := range []string{
+		"profile = home # " + files[0] + ":1",
+		"target = 192.168.1.10 # " + files[0] + ":3 [home]",
+		"password = ******** # " + files[0] + ":4 [home]",
+		"localport = 8000 # option -port",
+		"targetport = 80 # default",
+	} {
+		found := false
+		for _, line := range lines {
+			if strings.Join(strings.Fields(line), " ") == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no line %q in:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Error("password printed")
+	}
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"errors"
-	"log"
 	"net"
 )
 
@@ -12,7 +11,7 @@ func GetLocalInterfaceIP() (string, error) {
 	ips, _ := net.LookupIP(GlobalConfig.Target)
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		log.Fatalf("Error while checking you interfaces: %v", err)
+		Fatalf("Error while checking you interfaces: %v", err)
 	}
 	for _, ip := range ips {
 		for _, iface := range ifaces {
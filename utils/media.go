@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// extensions of media files taken from directories
+var videoExtensions = []string{
+	".avi", ".divx", ".flv", ".m2ts", ".m4v", ".mkv", ".mov", ".mp4", ".mpeg",
+	".mpg", ".ogm", ".ogv", ".rmvb", ".ts", ".vob", ".webm", ".wmv",
+}
+var audioExtensions = []string{
+	".aac", ".ac3", ".aiff", ".alac", ".ape", ".dts", ".flac", ".m4a", ".mka",
+	".mp2", ".mp3", ".oga", ".ogg", ".opus", ".wav", ".wma", ".wv",
+}
+
+// content types of the usual stream formats, not all systems know them
+var mediaTypes = map[string]string{
+	".avi":  "video/x-msvideo",
+	".flv":  "video/x-flv",
+	".m2ts": "video/mp2t",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".mpeg": "video/mpeg",
+	".mpg":  "video/mpeg",
+	".ogv":  "video/ogg",
+	".ts":   "video/mp2t",
+	".webm": "video/webm",
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mka":  "audio/x-matroska",
+	".mp3":  "audio/mpeg",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+}
+
+// MediaType returns the content type of file from its extension,
+// application/octet-stream if unknown.
+func MediaType(file string) string {
+	ext := strings.ToLower(filepath.Ext(file))
+	if t, ok := mediaTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func hasExtension(file string, extensions []string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMediaFile returns true if file extension is a known video or audio one.
+func IsMediaFile(file string) bool {
+	return hasExtension(file, videoExtensions) || hasExtension(file, audioExtensions)
+}
+
+// IsAudioFile returns true if file extension is a known audio one.
+func IsAudioFile(file string) bool {
+	return hasExtension(file, audioExtensions)
+}
+
+// CollectMedia returns absolute paths of files to serve, in natural order
+// ("idok ep*.mkv" plays ep2 before ep10). Files are taken as is,
+// directories are replaced by the media files they contain (and their
+// subdirectories if recursive is true).
+func CollectMedia(args []string, recursive bool) ([]string, error) {
+	files := []string{}
+	for _, arg := range args {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		found, err := collectDir(path, recursive)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("No media file found in %s", arg)
+		}
+		files = append(files, found...)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return NaturalLess(files[i], files[j])
+	})
+	return files, nil
+}
+
+// media files of dir, hidden files are ignored
+func collectDir(dir string, recursive bool) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if !recursive {
+				continue
+			}
+			found, err := collectDir(path, recursive)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, found...)
+		} else if IsMediaFile(entry.Name()) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// NaturalLess compares strings the way humans do, numbers inside strings
+// are compared by value ("ep2" < "ep10"). Letters case is ignored.
+func NaturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		achunk, arest := naturalChunk(a)
+		bchunk, brest := naturalChunk(b)
+		if achunk != bchunk {
+			if isDigit(achunk[0]) && isDigit(bchunk[0]) {
+				atrim := strings.TrimLeft(achunk, "0")
+				btrim := strings.TrimLeft(bchunk, "0")
+				if len(atrim) != len(btrim) {
+					return len(atrim) < len(btrim)
+				}
+				if atrim != btrim {
+					return atrim < btrim
+				}
+				// same value, less leading zeros first
+				return len(achunk) < len(bchunk)
+			}
+			return achunk < bchunk
+		}
+		a, b = arest, brest
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// split s after its first chunk of digits or non digits
+func naturalChunk(s string) (string, string) {
+	digit := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// path of the M3U playlist served when playlist files are given
+const PLAYLIST_PATH = "playlist.m3u"
+
+// PlaylistEntry is a media to play, local file or remote url.
+type PlaylistEntry struct {
+	// local absolute path, or url if Remote is true
+	Location string
+	Remote   bool
+
+	// title and duration in seconds (-1 if unknown) of the media
+	Title    string
+	Duration int
+}
+
+// IsPlaylistFile returns true if file is a M3U, PLS or XSPF playlist.
+func IsPlaylistFile(file string) bool {
+	return hasExtension(file, []string{".m3u", ".m3u8", ".pls", ".xspf"})
+}
+
+// NeedsM3U returns true if entries can't be sent to Kodi as a list of
+// served files, because of remote urls, titles or durations.
+func NeedsM3U(entries []PlaylistEntry) bool {
+	for _, entry := range entries {
+		if entry.Remote || entry.Title != "" || entry.Duration >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalFiles returns the location of entries that are local files.
+func LocalFiles(entries []PlaylistEntry) []string {
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.Remote {
+			files = append(files, entry.Location)
+		}
+	}
+	return files
+}
+
+// CollectEntries returns entries to play. Without playlist files, this is
+// the CollectMedia list. Else each argument is expanded in place, playlist
+// files keep their order.
+func CollectEntries(args []string, recursive bool) ([]PlaylistEntry, error) {
+	hasplaylist := false
+	for _, arg := range args {
+		if IsPlaylistFile(arg) {
+			hasplaylist = true
+		}
+	}
+	if !hasplaylist {
+		files, err := CollectMedia(args, recursive)
+		return fileEntries(files), err
+	}
+
+	entries := []PlaylistEntry{}
+	for _, arg := range args {
+		if !IsPlaylistFile(arg) {
+			files, err := CollectMedia([]string{arg}, recursive)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, fileEntries(files)...)
+			continue
+		}
+		found, err := ParsePlaylist(arg)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+	return entries, nil
+}
+
+func fileEntries(files []string) []PlaylistEntry {
+	entries := make([]PlaylistEntry, len(files))
+	for i, file := range files {
+		entries[i] = PlaylistEntry{Location: file, Duration: -1}
+	}
+	return entries
+}
+
+// ParsePlaylist reads M3U (extended or not), PLS or XSPF file. Relative
+// paths are relative to the playlist directory, missing local files are
+// skipped.
+func ParsePlaylist(file string) ([]PlaylistEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(abs)
+
+	var entries []PlaylistEntry
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".pls":
+		entries, err = parsePLS(f, dir)
+	case ".xspf":
+		entries, err = parseXSPF(f, dir)
+	default:
+		entries, err = parseM3U(f, dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	found := []PlaylistEntry{}
+	for _, entry := range entries {
+		if !entry.Remote {
+			if _, err := os.Stat(entry.Location); err != nil {
+				log.Println("Skipping playlist entry:", err)
+				continue
+			}
+		}
+		found = append(found, entry)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("No media found in playlist %s", file)
+	}
+	return found, nil
+}
+
+// return entry for location found in a playlist of dir, uri is true if
+// location is an uri (XSPF) and must be unescaped
+func newEntry(location, dir string, uri bool) PlaylistEntry {
+	entry := PlaylistEntry{Location: location, Duration: -1}
+	if strings.HasPrefix(strings.ToLower(location), "file://") {
+		if u, err := url.Parse(location); err == nil {
+			entry.Location = filepath.FromSlash(u.Path)
+		}
+		return entry
+	}
+	if strings.Contains(location, "://") {
+		entry.Remote = true
+		return entry
+	}
+	if uri {
+		if path, err := url.PathUnescape(location); err == nil {
+			location = path
+		}
+	}
+	location = filepath.FromSlash(location)
+	if !filepath.IsAbs(location) {
+		location = filepath.Join(dir, location)
+	}
+	entry.Location = location
+	return entry
+}
+
+// M3U and extended M3U (#EXTINF:duration,title)
+func parseM3U(r io.Reader, dir string) ([]PlaylistEntry, error) {
+	entries := []PlaylistEntry{}
+	title, duration := "", -1
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXTINF:") {
+			info := strings.SplitN(line[len("#EXTINF:"):], ",", 2)
+			// duration may be followed by attributes: -1 tvg-id="..."
+			fields := strings.Fields(info[0])
+			duration = -1
+			if len(fields) > 0 {
+				if d, err := strconv.ParseFloat(fields[0], 64); err == nil && d >= 0 {
+					duration = int(d)
+				}
+			}
+			title = ""
+			if len(info) == 2 {
+				title = strings.TrimSpace(info[1])
+			}
+			continue
+		}
+		if line[0] == '#' {
+			continue
+		}
+		entry := newEntry(line, dir, false)
+		entry.Title, entry.Duration = title, duration
+		entries = append(entries, entry)
+		title, duration = "", -1
+	}
+	return entries, scanner.Err()
+}
+
+// PLS, FileN, TitleN and LengthN keys of [playlist] section
+func parsePLS(r io.Reader, dir string) ([]PlaylistEntry, error) {
+	byindex := map[int]*PlaylistEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		val := strings.SplitN(line, "=", 2)
+		if len(val) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(val[0]))
+		value := strings.TrimSpace(val[1])
+
+		var prefix string
+		for _, p := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, p) {
+				prefix = p
+			}
+		}
+		if prefix == "" {
+			continue
+		}
+		index, err := strconv.Atoi(key[len(prefix):])
+		if err != nil {
+			continue
+		}
+		entry, ok := byindex[index]
+		if !ok {
+			entry = &PlaylistEntry{Duration: -1}
+			byindex[index] = entry
+		}
+		switch prefix {
+		case "file":
+			e := newEntry(value, dir, false)
+			entry.Location, entry.Remote = e.Location, e.Remote
+		case "title":
+			entry.Title = value
+		case "length":
+			if d, err := strconv.Atoi(value); err == nil && d >= 0 {
+				entry.Duration = d
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	indexes := []int{}
+	for index := range byindex {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	entries := []PlaylistEntry{}
+	for _, index := range indexes {
+		if byindex[index].Location != "" {
+			entries = append(entries, *byindex[index])
+		}
+	}
+	return entries, nil
+}
+
+// XSPF, duration is in milliseconds
+func parseXSPF(r io.Reader, dir string) ([]PlaylistEntry, error) {
+	playlist := struct {
+		Tracks []struct {
+			Location []string `xml:"location"`
+			Title    string   `xml:"title"`
+			Duration int      `xml:"duration"`
+		} `xml:"trackList>track"`
+	}{}
+	if err := xml.NewDecoder(r).Decode(&playlist); err != nil {
+		return nil, err
+	}
+
+	entries := []PlaylistEntry{}
+	for _, track := range playlist.Tracks {
+		if len(track.Location) == 0 {
+			continue
+		}
+		entry := newEntry(strings.TrimSpace(track.Location[0]), dir, true)
+		entry.Title = strings.TrimSpace(track.Title)
+		if track.Duration > 0 {
+			entry.Duration = track.Duration / 1000
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// WriteM3U writes entries as an extended M3U playlist, urls are the
+// locations to write for each entry.
+func WriteM3U(w io.Writer, entries []PlaylistEntry, urls []string) error {
+	if _, err := fmt.Fprintln(w, "#EXTM3U"); err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		title := entry.Title
+		if title == "" {
+			title = filepath.Base(entry.Location)
+		}
+		if _, err := fmt.Fprintf(w, "#EXTINF:%d,%s\n%s\n", entry.Duration, title, urls[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
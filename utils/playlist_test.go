@@ -0,0 +1,206 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// mediaDir creates files in a temporary directory, removed by the returned
+// function
+func mediaDir(t *testing.T, files ...string) (string, func()) {
+	dir, err := ioutil.TempDir("", "idok-playlist-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestParsePlaylist(t *testing.T) {
+	dir, clean := mediaDir(t, "a.mkv", "sub/b.mkv", "c d.mkv")
+	defer clean()
+	a := filepath.Join(dir, "a.mkv")
+	b := filepath.Join(dir, "sub", "b.mkv")
+	cd := filepath.Join(dir, "c d.mkv")
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []PlaylistEntry
+	}{
+		{"m3u relative", "list.m3u", "a.mkv\nsub/b.mkv\n",
+			[]PlaylistEntry{{a, false, "", -1}, {b, false, "", -1}}},
+		{"m3u in subdirectory", "sub/list.m3u", "b.mkv\n../a.mkv\n" + a + "\n",
+			[]PlaylistEntry{{b, false, "", -1}, {a, false, "", -1}, {a, false, "", -1}}},
+		{"extended m3u", "list.m3u8",
+			"\ufeff#EXTM3U\n#EXTINF:123,Title A\na.mkv\n#EXTINF:-1 tvg-id=\"radio\",Radio\nhttp://host/stream\n\n# comment\nsub/b.mkv\n",
+			[]PlaylistEntry{{a, false, "Title A", 123}, {"http://host/stream", true, "Radio", -1}, {b, false, "", -1}}},
+		{"missing file skipped", "list.m3u", "#EXTINF:10,Missing\nmissing.mkv\na.mkv\n",
+			[]PlaylistEntry{{a, false, "", -1}}},
+		{"pls with gaps", "list.pls",
+			"[playlist]\nFile1=a.mkv\nTitle1=A\nLength1=60\nFile3=http://host/stream\nTitle3=Stream\nLength3=-1\nFile10=sub/b.mkv\nTitle5=Orphan\nNumberOfEntries=3\nVersion=2\n",
+			[]PlaylistEntry{{a, false, "A", 60}, {"http://host/stream", true, "Stream", -1}, {b, false, "", -1}}},
+		{"xspf", "list.xspf", `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track><location>file://` + filepath.ToSlash(dir) + `/c%20d.mkv</location><title>C D</title><duration>90500</duration></track>
+    <track><location>c%20d.mkv</location></track>
+    <track><title>No location</title></track>
+    <track><location>http://host/stream</location></track>
+  </trackList>
+</playlist>`,
+			[]PlaylistEntry{{cd, false, "C D", 90}, {cd, false, "", -1}, {"http://host/stream", true, "", -1}}},
+	}
+	for _, test := range tests {
+		file := filepath.Join(dir, filepath.FromSlash(test.file))
+		if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParsePlaylist(file)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParsePlaylistErrors(t *testing.T) {
+	dir, clean := mediaDir(t, "a.mkv")
+	defer clean()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"empty m3u", "list.m3u", ""},
+		{"comments only", "list.m3u", "#EXTM3U\n#EXTINF:10,Title\n"},
+		{"missing files only", "list.m3u", "missing.mkv\n"},
+		{"pls without file", "list.pls", "[playlist]\nTitle1=A\nFileX=a.mkv\nNumberOfEntries=1\n"},
+		{"truncated xspf", "list.xspf", "<playlist><trackList><track><location>a.mkv"},
+		{"not xml", "list.xspf", "a.mkv\n"},
+	}
+	for _, test := range tests {
+		file := filepath.Join(dir, test.file)
+		if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ParsePlaylist(file); err == nil {
+			t.Errorf("%s: no error, got %v", test.name, got)
+		}
+	}
+	if _, err := ParsePlaylist(filepath.Join(dir, "missing.m3u")); err == nil {
+		t.Error("missing playlist: no error")
+	}
+}
+
+func TestNeedsM3U(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []PlaylistEntry
+		want    bool
+	}{
+		{"no entry", nil, false},
+		{"files", []PlaylistEntry{{"/a.mkv", false, "", -1}, {"/b.mkv", false, "", -1}}, false},
+		{"remote", []PlaylistEntry{{"/a.mkv", false, "", -1}, {"http://host/s", true, "", -1}}, true},
+		{"title", []PlaylistEntry{{"/a.mkv", false, "A", -1}}, true},
+		{"duration", []PlaylistEntry{{"/a.mkv", false, "", 0}}, true},
+	}
+	for _, test := range tests {
+		if got := NeedsM3U(test.entries); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWriteM3U(t *testing.T) {
+	entries := []PlaylistEntry{
+		{"/media/a.mkv", false, "Title A", 123},
+		{"/media/b.mkv", false, "", -1},
+		{"http://host/stream", true, "Radio", -1},
+	}
+	urls := []string{"http://idok:8080/a.mkv", "http://idok:8080/b.mkv", "http://host/stream"}
+	var out bytes.Buffer
+	if err := WriteM3U(&out, entries, urls); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n" +
+		"#EXTINF:123,Title A\nhttp://idok:8080/a.mkv\n" +
+		"#EXTINF:-1,b.mkv\nhttp://idok:8080/b.mkv\n" +
+		"#EXTINF:-1,Radio\nhttp://host/stream\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+func TestCollectEntries(t *testing.T) {
+	dir, clean := mediaDir(t, "z.mkv", "a.mkv", "ep1.mkv", "ep10.mkv", "ep2.mkv", "season/ep10.mkv", "season/ep2.mkv",
+		"season/notes.txt", "season/.hidden.mkv", "season/extra/ep1.mkv")
+	defer clean()
+	path := func(file string) string {
+		return filepath.Join(dir, filepath.FromSlash(file))
+	}
+	if err := ioutil.WriteFile(path("list.m3u"), []byte("#EXTINF:5,A\na.mkv\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		recursive bool
+		want      []PlaylistEntry
+	}{
+		{"files in natural order", []string{path("z.mkv"), path("a.mkv")}, false,
+			[]PlaylistEntry{{path("a.mkv"), false, "", -1}, {path("z.mkv"), false, "", -1}}},
+		// "ep*.mkv" expanded by the shell, in byte order
+		{"glob", []string{path("ep1.mkv"), path("ep10.mkv"), path("ep2.mkv")}, false,
+			[]PlaylistEntry{{path("ep1.mkv"), false, "", -1}, {path("ep2.mkv"), false, "", -1},
+				{path("ep10.mkv"), false, "", -1}}},
+		{"directory in natural order", []string{path("z.mkv"), path("season")}, false,
+			[]PlaylistEntry{{path("season/ep2.mkv"), false, "", -1}, {path("season/ep10.mkv"), false, "", -1},
+				{path("z.mkv"), false, "", -1}}},
+		{"recursive", []string{path("season")}, true,
+			[]PlaylistEntry{{path("season/ep2.mkv"), false, "", -1}, {path("season/ep10.mkv"), false, "", -1},
+				{path("season/extra/ep1.mkv"), false, "", -1}}},
+		{"playlist expanded in place", []string{path("z.mkv"), path("list.m3u"), path("season")}, false,
+			[]PlaylistEntry{{path("z.mkv"), false, "", -1}, {path("a.mkv"), false, "A", 5},
+				{path("season/ep2.mkv"), false, "", -1}, {path("season/ep10.mkv"), false, "", -1}}},
+	}
+	for _, test := range tests {
+		got, err := CollectEntries(test.args, test.recursive)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := CollectEntries([]string{path("missing.mkv")}, false); err == nil {
+		t.Error("missing file: no error")
+	}
+	if _, err := CollectEntries([]string{path("season/extra"), path("list.m3u")}, false); err != nil {
+		t.Error("directory with media:", err)
+	}
+	os.Remove(path("season/extra/ep1.mkv"))
+	if _, err := CollectEntries([]string{path("season/extra")}, false); err == nil {
+		t.Error("directory without media: no error")
+	}
+}
@@ -1,25 +1,31 @@
 package utils
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
 	"time"
+
+	"github.com/sdbbs/idok/kodi"
 )
 
 const TICK_CHECK = 1
+
+// seconds without active player before the media is known to be finished:
+// Kodi stops between playlist items, and takes time to start the player
+// after Player.Open
+const (
+	STOP_GRACE  = 3
+	START_GRACE = 10
+)
+
 var verbose = false
 
-// response of get players
-type itemresp struct {
-	Id      int
-	Jsonrpc string
-	Result  []map[string]interface{}
-}
+// playlist options
+var (
+	shuffle = false
+	repeat  = ""
+)
 
 func SetVerbose(inbool bool) {
 	verbose = inbool
@@ -28,30 +34,111 @@ func SetVerbose(inbool bool) {
 	}
 }
 
-// Send the play command to Kodi/XBMC.
-func Send(scheme, host, file string, port int) <-chan int {
+// SetPlaylistOptions sets shuffle and repeat mode ("off", "one", "all")
+// of playlists opened by SendList.
+func SetPlaylistOptions(inshuffle bool, inrepeat string) {
+	shuffle = inshuffle
+	repeat = inrepeat
+}
 
+// url of file served on host:port
+func mediaURL(scheme, host, file string, port int) string {
 	u := url.URL{Path: file}
 	file = u.String()
-	var addr string
 	if file == "/" {
-		addr = fmt.Sprintf("%s://%s:%d/", scheme, host, port)
+		return fmt.Sprintf("%s://%s:%d/", scheme, host, port)
 	} else if file == "-" {
-		addr = fmt.Sprintf("%s://%s:%d", scheme, host, port)
-	} else {
-		addr = fmt.Sprintf("%s://%s:%d/%s", scheme, host, port, file)
+		return fmt.Sprintf("%s://%s:%d", scheme, host, port)
 	}
+	return fmt.Sprintf("%s://%s:%d/%s", scheme, host, port, file)
+}
 
-	request := []interface{} {GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(BODY, addr))}
+// Send the play command to Kodi/XBMC.
+func Send(scheme, host, file string, port int) <-chan int {
+
+	addr := mediaURL(scheme, host, file, port)
 	if verbose {
-		log.Println(" Send request: ", request)
+		log.Println(" Send request: ", addr)
 	}
-	r, err := http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(BODY, addr)))
-	if err != nil {
-		log.Fatal(err)
+	if err := Kodi.PlayerOpen(kodi.Item{File: addr}); err != nil {
+		Fatal(err)
+	}
+
+	// and wait media end
+	return checkPlaying()
+}
+
+// SendList asks Kodi/XBMC to play files served on host:port. Several files
+// are added to a new playlist (music playlist if all files are audio ones,
+// video playlist else) that is opened with playlist options.
+func SendList(scheme, host string, files []string, port int) <-chan int {
+	if len(files) == 1 && !shuffle && repeat == "" {
+		return Send(scheme, host, files[0], port)
+	}
+
+	playlist := kodi.PLAYLIST_MUSIC
+	for _, file := range files {
+		if !IsAudioFile(file) {
+			playlist = kodi.PLAYLIST_VIDEO
+			break
+		}
+	}
+
+	if err := Kodi.PlaylistClear(playlist); err != nil {
+		Fatal(err)
+	}
+	for _, file := range files {
+		addr := mediaURL(scheme, host, file, port)
+		if verbose {
+			log.Println(" Playlist.Add: ", addr)
+		}
+		if err := Kodi.PlaylistAdd(playlist, kodi.Item{File: addr}); err != nil {
+			Fatal(err)
+		}
+	}
+	log.Printf("Added %d media to playlist %d\n", len(files), playlist)
+
+	options := kodi.OpenOptions{Shuffled: shuffle, Repeat: repeat}
+	if err := Kodi.PlayerOpenWithOptions(kodi.PlaylistItem(playlist, 0), options); err != nil {
+		Fatal(err)
+	}
+
+	// and wait media end
+	return checkPlaying()
+}
+
+// SendPlaylistFile asks Kodi/XBMC to play the playlist file served on
+// host:port, then sets playlist options on the player.
+func SendPlaylistFile(scheme, host, file string, port int) <-chan int {
+	addr := mediaURL(scheme, host, file, port)
+	if verbose {
+		log.Println(" SendPlaylistFile request: ", addr)
+	}
+	if err := Kodi.PlayerOpen(kodi.Item{File: addr}); err != nil {
+		Fatal(err)
+	}
+
+	if shuffle || repeat != "" {
+		// Kodi loads the playlist before the player starts
+		for i := 0; i < 10; i++ {
+			players, err := Kodi.PlayerGetActivePlayers()
+			if err != nil || len(players) == 0 {
+				time.Sleep(time.Second)
+				continue
+			}
+			if shuffle {
+				if err := Kodi.PlayerSetShuffle(players[0].PlayerId, true); err != nil {
+					log.Println(err)
+				}
+			}
+			if repeat != "" {
+				if err := Kodi.PlayerSetRepeat(players[0].PlayerId, repeat); err != nil {
+					log.Println(err)
+				}
+			}
+			break
+		}
 	}
-	response, _ := ioutil.ReadAll(r.Body)
-	log.Println(string(response))
 
 	// and wait media end
 	return checkPlaying()
@@ -59,17 +146,12 @@ func Send(scheme, host, file string, port int) <-chan int {
 
 // send basic stream...
 func SendBasicStream(uri string, local bool) <-chan int {
-	_body := fmt.Sprintf(BODY, uri)
-
-	r, err := http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(_body))
-	if err != nil {
-		log.Fatal(err)
+	if err := Kodi.PlayerOpen(kodi.Item{File: uri}); err != nil {
+		Fatal(err)
 	}
-	response, _ := ioutil.ReadAll(r.Body)
-	log.Println(string(response))
 
 	// handle CTRL+C to stop
-	go OnQuit()
+	HandleQuit()
 
 	// and wait the end of media
 	return checkPlaying()
@@ -78,82 +160,132 @@ func SendBasicStream(uri string, local bool) <-chan int {
 // Ask to play youtube video.
 func PlayYoutube(vidid string) <-chan int {
 
-	request := []interface{} {GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(YOUTUBEAPI, vidid))}
+	file := fmt.Sprintf(YOUTUBEPLUGIN, vidid)
 	if verbose {
-		log.Println(" PlayYoutube request: ", request)
+		log.Println(" PlayYoutube request: ", file)
 	}
-	r, err := http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(YOUTUBEAPI, vidid)))
-	if err != nil {
-		log.Fatal(err)
+	if err := Kodi.PlayerOpen(kodi.Item{File: file}); err != nil {
+		Fatal(err)
 	}
-	response, _ := ioutil.ReadAll(r.Body)
-	log.Println(string(response))
 
 	// handle CTRL+C to stop
-	go OnQuit()
+	HandleQuit()
 
 	return checkPlaying()
 }
 
 func PlayViaSendToKodi(vidid string) <-chan int {
 
-	request := []interface{} {GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(PLAYSENDTOKODIAPI, vidid))}
+	file := fmt.Sprintf(SENDTOKODIPLUGIN, vidid)
 	if verbose {
-		log.Println(" PlayViaSendToKodi request: ", request)
+		log.Println(" PlayViaSendToKodi request: ", file)
 	}
-	r, err := http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(PLAYSENDTOKODIAPI, vidid)))
-	if err != nil {
-		log.Fatal(err)
+	if err := Kodi.PlayerOpen(kodi.Item{File: file}); err != nil {
+		Fatal(err)
 	}
-	response, _ := ioutil.ReadAll(r.Body)
-	log.Println(string(response))
 
 	// handle CTRL+C to stop
-	go OnQuit()
+	HandleQuit()
 
 	return checkPlaying()
 }
 
 func AddViaSendToKodi(vidid string) <-chan int {
 
-	request := []interface{} {GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(ADDSENDTOKODIAPI, vidid))}
+	file := fmt.Sprintf(SENDTOKODIPLUGIN, vidid)
 	if verbose {
-		log.Println(" AddViaSendToKodi request: ", request)
+		log.Println(" AddViaSendToKodi request: ", file)
 	}
-	r, err := http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(ADDSENDTOKODIAPI, vidid)))
-	if err != nil {
-		log.Fatal(err)
+	if err := Kodi.PlaylistAdd(kodi.PLAYLIST_VIDEO, kodi.Item{File: file}); err != nil {
+		Fatal(err)
 	}
-	response, _ := ioutil.ReadAll(r.Body)
-	log.Println(string(response))
 
 	// handle CTRL+C to stop
-	go OnQuit()
+	HandleQuit()
 
 	return checkPlaying()
 }
 
+// QuitOnEnd waits for the end of media and exits.
+func QuitOnEnd(end <-chan int) {
+	<-end
+	log.Println("Media has finished, quitting")
+	Exit(0)
+}
+
 // test if media is playing, write 1 in returned chan when media has finished.
+// Kodi notifications are used if possible, else we poll active players.
 func checkPlaying() <-chan int {
-	tick := time.Tick(TICK_CHECK * time.Second)
-	c := make(chan int, 0)
+	c := make(chan int, 1)
 	go func() {
-		for _ = range tick {
-			resp := getActivePlayer()
-			if len(resp.Result) == 0 {
-				c <- 1
+		listener, err := Kodi.Listen(GlobalConfig.Notifyport)
+		if err != nil {
+			if verbose {
+				log.Println(" Unable to get Kodi notifications, polling: ", err)
+			}
+			pollPlaying(c)
+			return
+		}
+		defer listener.Close()
+
+		// the media may have ended before the listener was connected
+		check := time.After(START_GRACE * time.Second)
+		for {
+			select {
+			case n, ok := <-listener.C:
+				if !ok {
+					log.Println("Kodi notification connection lost, polling")
+					pollPlaying(c)
+					return
+				}
+				switch n.Method {
+				case "Player.OnStop":
+					// Kodi stops between playlist items, the next one
+					// should start soon
+					check = time.After(STOP_GRACE * time.Second)
+				case "Player.OnPlay", "Player.OnAVStart":
+					check = nil
+				}
+			case <-check:
+				players, err := Kodi.PlayerGetActivePlayers()
+				if err == nil && len(players) == 0 {
+					c <- 1
+					return
+				}
+				// check again, a later Player.OnStop may be missed
+				if err != nil {
+					log.Println(err)
+				}
+				check = time.After(STOP_GRACE * time.Second)
 			}
 		}
 	}()
 	return c
 }
 
-// return active player from XBMC.
-func getActivePlayer() *itemresp {
-	r, _ := http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(GETPLAYERBODY))
-	response, _ := ioutil.ReadAll(r.Body)
-	resp := &itemresp{}
-	resp.Result = make([]map[string]interface{}, 0)
-	json.Unmarshal(response, resp)
-	return resp
+// poll active players each TICK_CHECK second, write 1 in c when no player
+// is active for STOP_GRACE seconds. Player.Open has succeeded, but the
+// media may end before the first tick: without any player for START_GRACE
+// seconds, it has ended.
+func pollPlaying(c chan<- int) {
+	ticker := time.NewTicker(TICK_CHECK * time.Second)
+	defer ticker.Stop()
+	seen := false
+	idle := 0
+	for _ = range ticker.C {
+		players, err := Kodi.PlayerGetActivePlayers()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if len(players) > 0 {
+			seen, idle = true, 0
+			continue
+		}
+		idle += TICK_CHECK
+		if (seen && idle >= STOP_GRACE) || idle >= START_GRACE {
+			c <- 1
+			return
+		}
+	}
 }
@@ -1,31 +1,70 @@
 package utils
 
 import (
-	"bytes"
 	"fmt"
-	"net/http"
+	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 )
 
+// functions to call before exiting
+var atexit []func()
+
+// AtExit registers f to be called by Exit (eg. to restore the terminal).
+// The signal handler is installed for f to be called on SIGTERM too, see
+// HandleQuit.
+func AtExit(f func()) {
+	atexit = append(atexit, f)
+	HandleQuit()
+}
+
+// Exit calls functions registered with AtExit and exits with code.
+func Exit(code int) {
+	for i := len(atexit) - 1; i >= 0; i-- {
+		atexit[i]()
+	}
+	os.Exit(code)
+}
+
+// Fatal is log.Fatal calling the AtExit functions: it logs v and exits
+// with Exit(1).
+func Fatal(v ...interface{}) {
+	log.Output(2, fmt.Sprint(v...))
+	Exit(1)
+}
+
+// Fatalf is log.Fatalf calling the AtExit functions.
+func Fatalf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+	Exit(1)
+}
+
+var quitOnce sync.Once
+
+// HandleQuit runs OnQuit in a goroutine, once.
+func HandleQuit() {
+	quitOnce.Do(func() {
+		go OnQuit()
+	})
+}
+
 // when quiting (CTRL+C for example) - tell to XBMC to stop.
 func OnQuit() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
 	<-c
 	fmt.Println("Quiting")
-	resp := getActivePlayer()
-	var playerid int
-	for _, result := range resp.Result {
-		// TODO maybe result["playerid"] is ok...
-		for key, val := range result {
-			if key == "playerid" {
-				playerid = int(val.(float64))
-			}
-		}
+	players, err := Kodi.PlayerGetActivePlayers()
+	if err != nil {
+		log.Println(err)
 	}
 	// tell to Kodi to stop
-	http.Post(GlobalConfig.JsonRPC, "application/json", bytes.NewBufferString(fmt.Sprintf(STOPBODY, playerid)))
-	os.Exit(0)
+	for _, player := range players {
+		if err := Kodi.PlayerStop(player.PlayerId); err != nil {
+			log.Println(err)
+		}
+	}
+	Exit(0)
 }
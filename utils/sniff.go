@@ -0,0 +1,75 @@
+package utils
+
+import "bytes"
+
+// bytes of a stream needed by SniffExtension to tell its format
+const SNIFF_SIZE = 4096
+
+// MPEG-TS packets, M2TS (blu-ray) ones have a 4 bytes timecode first
+const (
+	tsPacket   = 188
+	m2tsPacket = 192
+)
+
+// SniffExtension returns the file extension of the format of a stream from
+// its first bytes (see SNIFF_SIZE), "" if it's not recognized. The formats
+// are the ones encoders write to a pipe: MPEG-TS, Matroska/WebM, MP4
+// (fragmented), FLAC, Ogg, MP3 and AAC (ADTS).
+func SniffExtension(data []byte) string {
+	switch {
+	case isTransportStream(data, 0, tsPacket):
+		return ".ts"
+	case isTransportStream(data, 4, m2tsPacket):
+		return ".m2ts"
+	case bytes.HasPrefix(data, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		// EBML header, its DocType tells WebM from Matroska
+		header := data
+		if len(header) > 64 {
+			header = header[:64]
+		}
+		if bytes.Contains(header, []byte("webm")) {
+			return ".webm"
+		}
+		return ".mkv"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		switch string(data[8:12]) {
+		case "qt  ":
+			return ".mov"
+		case "M4A ":
+			return ".m4a"
+		}
+		return ".mp4"
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return ".flac"
+	case bytes.HasPrefix(data, []byte("OggS")):
+		if bytes.Contains(data, []byte("\x80theora")) {
+			return ".ogv"
+		}
+		return ".ogg"
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return ".mp3"
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xf6 == 0xf0:
+		// 12 bits of sync, layer 0
+		return ".aac"
+	case len(data) >= 2 && data[0] == 0xff && data[1]&0xe6 == 0xe2:
+		// 11 bits of sync, layer III
+		return ".mp3"
+	}
+	return ""
+}
+
+// isTransportStream tells if data is made of packets of size, with the sync
+// byte at offset. The stream may start in the middle of a packet, at least
+// two sync bytes have to follow.
+func isTransportStream(data []byte, offset, size int) bool {
+	for start := offset; start < offset+size && start+size < len(data); start++ {
+		syncs := 0
+		for i := start; i < len(data) && data[i] == 0x47; i += size {
+			syncs++
+		}
+		if syncs >= 2 && start+syncs*size >= len(data) {
+			return true
+		}
+	}
+	return false
+}
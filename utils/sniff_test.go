@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+// packets of size with the sync byte at offset, starting at skip
+func packets(size, offset, skip int) []byte {
+	data := make([]byte, SNIFF_SIZE+skip)
+	for i := offset; i < len(data); i += size {
+		data[i] = 0x47
+	}
+	return data[skip:]
+}
+
+func TestSniffExtension(t *testing.T) {
+	ebml := []byte{0x1a, 0x45, 0xdf, 0xa3, 0x9f, 0x42, 0x86, 0x81, 0x01, 0x42, 0x82, 0x84}
+	tests := []struct {
+		name string
+		data []byte
+		ext  string
+	}{
+		{"mpeg-ts", packets(188, 0, 0), ".ts"},
+		{"mpeg-ts, cut packet", packets(188, 0, 100), ".ts"},
+		{"m2ts", packets(192, 4, 0), ".m2ts"},
+		{"matroska", append(ebml, "matroska"...), ".mkv"},
+		{"webm", append(ebml, "webm"...), ".webm"},
+		{"mp4", []byte("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00"), ".mp4"},
+		{"mov", []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"), ".mov"},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A \x00\x00\x02\x00"), ".m4a"},
+		{"flac", []byte("fLaC\x00\x00\x00\x22"), ".flac"},
+		{"ogg", []byte("OggS\x00\x02\x00\x00\x01\x1evorbis"), ".ogg"},
+		{"ogg theora", []byte("OggS\x00\x02\x00\x00\x2a\x80theora"), ".ogv"},
+		{"mp3 id3", []byte("ID3\x04\x00\x00"), ".mp3"},
+		{"mp3", []byte{0xff, 0xfb, 0x90, 0x64}, ".mp3"},
+		{"adts", []byte{0xff, 0xf1, 0x50, 0x80}, ".aac"},
+		{"unknown", bytes.Repeat([]byte("text"), 100), ""},
+		{"empty", nil, ""},
+		{"single sync byte", []byte{0x47, 1, 2, 3}, ""},
+	}
+	for _, test := range tests {
+		if ext := SniffExtension(test.data); ext != test.ext {
+			t.Errorf("%s: got %q, want %q", test.name, ext, test.ext)
+		}
+	}
+
+	// a sync byte is missing
+	data := packets(188, 0, 0)
+	data[188*5] = 0
+	if ext := SniffExtension(data); ext != "" {
+		t.Errorf("broken mpeg-ts: got %q", ext)
+	}
+}
@@ -4,16 +4,26 @@ import (
 	"flag"
 	"fmt"
 	"os"
+
+	"github.com/sdbbs/idok/ctl"
 )
 
 // Usage() prints command line documentation
 func Usage() {
 	fmt.Fprintf(os.Stderr, "\nUsage: ")
 	fmt.Fprintf(os.Stderr, "%s [options] mediafile|youtubeurl|streamurl\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Several media files, directories and playlist files (m3u, pls, xspf) can be given, they are sent as a playlist:")
+	fmt.Fprintf(os.Stderr, "\n\t%s [options] [-recursive] [-shuffle] [-repeat=all] file1 file2 directory...\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "Opening external URL dosen't open local or remote port. Your media center will fetch data itself.\n\n")
 	fmt.Fprintf(os.Stderr, "You may be able to stream stdout -> stdin:")
 	fmt.Fprintf(os.Stderr, "\n\t%s [options] -stdin < file\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "Or:\n\tcommand | %s [options] -stdin \n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Or:\n\tcommand | %s [options] -stdin \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Files that Kodi can't play can be converted with ffmpeg:\n\t%s [options] -transcode=h264-aac file\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "You can remote control the media center:")
+	fmt.Fprintf(os.Stderr, "\n\t%s [options] [ctl] command [args]\n\nCommands are:\n", os.Args[0])
+	ctl.Usage()
+	fmt.Fprintf(os.Stderr, "Commands exit with 0 on success, 1 on bad usage, 2 if Kodi can't be reached, 3 if nothing is playing, 4 if Kodi returned an error.\n\n")
+	fmt.Fprintf(os.Stderr, "Without -target, idok looks for Kodi on the network. To list media centers found:\n\n\t%s discover\n\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "Using ssh option is only managed for local files.\n")
 	fmt.Fprintf(os.Stderr, "Default mode is HTTP mode, it opens :8080 port on your host and send message to Kodi to read from that port. So, you must configure your firewall to open that port. You can override used port with -port option.\n")
 	fmt.Fprintf(os.Stderr, "You can use SSH with -ssh option, %s will try to use key pair authtification, then use -sshpass to try login/password auth. With -ssh, you should change -sshuser if your Kodi user is not \"pi\" (default on raspbmc)\n", os.Args[0])
@@ -21,12 +31,13 @@ func Usage() {
 	fmt.Fprintf(os.Stderr, "\n\nOptions:\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, `
-You can create a configuration file in current directory, or:
-- $HOME/.config/idok/idok.conf
-- $HOME/.local/etc/idok.conf
+Configuration files are read in that order, each one overrides the previous ones:
 - /etc/idok.conf
+- $HOME/.local/etc/idok.conf
+- $HOME/.config/idok/idok.conf
+- ./idok.conf
 
-Configuration file will set default options. Using options in the command line will override configuration file
+IDOK_* environment variables (IDOK_TARGET, IDOK_SSHPORT...) override configuration files. Using options in the command line overrides everything. Use "config show" command to print the configuration and where each value comes from.
 
 The -conf-example option prints a default configuration file.
 `)